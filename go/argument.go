@@ -0,0 +1,194 @@
+package confetti
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Argument is a directive argument with accessors that interpret it as other types.
+type Argument string
+
+// Arg returns the directive's argument at index i, or "" if there is none.
+func (d Directive) Arg(i int) Argument {
+	if i < 0 || i >= len(d.Arguments) {
+		return ""
+	}
+	return Argument(d.Arguments[i])
+}
+
+func (a Argument) String() string {
+	return string(a)
+}
+
+// Quoting is the way an argument was written in the source.
+type Quoting uint8
+
+const (
+	QuotingNone   Quoting = iota // unquoted
+	QuotingSingle                // "quoted"
+	QuotingTriple                // """triple quoted"""
+)
+
+// ArgumentSource is how an argument of a parsed directive was written.
+type ArgumentSource struct {
+	Quoting Quoting
+	// Text is the argument as written, including quotes and escapes.
+	Text string
+}
+
+// decodes reports whether the source text is still that of the argument a.
+func (s ArgumentSource) decodes(a string, puncts []string) bool {
+	switch s.Quoting {
+	case QuotingSingle:
+		return len(s.Text) >= 2 && unescape(s.Text[1:len(s.Text)-1]) == a
+	case QuotingTriple:
+		return len(s.Text) >= 6 && unescape(s.Text[3:len(s.Text)-3]) == a
+	}
+	// unquoted arguments that would be read differently with some extension enabled are quoted instead
+	return s.Text == a && !needsQuotes(a, puncts)
+}
+
+// argError reports that a could not be interpreted as kind, wrapping strconv.ErrSyntax or strconv.ErrRange where they apply.
+func argError(a Argument, kind string, err error) error {
+	if ne, ok := err.(*strconv.NumError); ok {
+		err = ne.Err
+	}
+	return fmt.Errorf("invalid %s %q: %w", kind, string(a), err)
+}
+
+// Int interprets the argument as an integer. It accepts an optional sign, the base prefixes 0b, 0o, and 0x, and underscores between digits, as in Go.
+func (a Argument) Int() (int, error) {
+	n, err := strconv.ParseInt(string(a), 0, strconv.IntSize)
+	if err != nil {
+		return 0, argError(a, "integer", err)
+	}
+	return int(n), nil
+}
+
+// Bool interprets the argument as a boolean. It accepts true, yes, on, and 1, and false, no, off, and 0, ignoring case.
+func (a Argument) Bool() (bool, error) {
+	switch strings.ToLower(string(a)) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return false, argError(a, "boolean", strconv.ErrSyntax)
+}
+
+// Float64 interprets the argument as a floating-point number, accepting the same syntax as strconv.ParseFloat.
+func (a Argument) Float64() (float64, error) {
+	f, err := strconv.ParseFloat(string(a), 64)
+	if err != nil {
+		return 0, argError(a, "number", err)
+	}
+	return f, nil
+}
+
+// Duration interprets the argument as a duration, accepting the same syntax as time.ParseDuration, such as "1h30m".
+func (a Argument) Duration() (time.Duration, error) {
+	d, err := time.ParseDuration(string(a))
+	if err != nil {
+		return 0, argError(a, "duration", strconv.ErrSyntax)
+	}
+	return d, nil
+}
+
+var byteUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+}
+
+// Bytes interprets the argument as a size in bytes: a non-negative number, optionally followed by a unit. The units B, KB, MB, GB, TB, and PB are powers of 1000, and KiB, MiB, GiB, TiB, and PiB are powers of 1024. Units ignore case, so "10MB" and "10mb" are both 10,000,000 bytes. Fractional sizes are rounded down to whole bytes.
+func (a Argument) Bytes() (int64, error) {
+	s := string(a)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+
+	unit, ok := byteUnits[strings.ToLower(s[i:])]
+	if !ok || i == 0 {
+		return 0, argError(a, "size", strconv.ErrSyntax)
+	}
+
+	if !strings.Contains(s[:i], ".") {
+		n, err := strconv.ParseInt(s[:i], 10, 64)
+		if err != nil {
+			return 0, argError(a, "size", err)
+		} else if n > math.MaxInt64/unit {
+			return 0, argError(a, "size", strconv.ErrRange)
+		}
+		return n * unit, nil
+	}
+
+	f, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, argError(a, "size", err)
+	} else if f *= float64(unit); f >= math.MaxInt64 {
+		return 0, argError(a, "size", strconv.ErrRange)
+	}
+	return int64(f), nil
+}
+
+// value returns the single argument after the name of the directive at path, as Lookup finds it, or false if there is no such directive or it has another number of arguments.
+func (doc Document) value(path string) (Argument, bool) {
+	d, err := doc.Lookup(path)
+	if err != nil || len(d.Arguments) != 2 {
+		return "", false
+	}
+	return d.Arg(1), true
+}
+
+// StringOr returns the single argument of the directive at path, as Lookup finds it, or def if there is no such directive or it has no or several arguments.
+func (doc Document) StringOr(path, def string) string {
+	if a, ok := doc.value(path); ok {
+		return a.String()
+	}
+	return def
+}
+
+// IntOr returns the single argument of the directive at path as Argument.Int interprets it, or def if it is missing or invalid, as StringOr would give def.
+func (doc Document) IntOr(path string, def int) int {
+	if a, ok := doc.value(path); ok {
+		if n, err := a.Int(); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// BoolOr returns the single argument of the directive at path as Argument.Bool interprets it, or def if it is missing or invalid, as StringOr would give def.
+func (doc Document) BoolOr(path string, def bool) bool {
+	if a, ok := doc.value(path); ok {
+		if b, err := a.Bool(); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// DurationOr returns the single argument of the directive at path as Argument.Duration interprets it, or def if it is missing or invalid, as StringOr would give def.
+func (doc Document) DurationOr(path string, def time.Duration) time.Duration {
+	if a, ok := doc.value(path); ok {
+		if dur, err := a.Duration(); err == nil {
+			return dur
+		}
+	}
+	return def
+}
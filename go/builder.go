@@ -0,0 +1,71 @@
+package confetti
+
+// A Builder constructs a directive one part at a time:
+//
+//	confetti.NewDirective("server").Arg("example.com").Sub(
+//		confetti.NewDirective("listen").Arg("443"),
+//	)
+//
+// The first invalid part, such as an argument containing a forbidden character, is reported by Build.
+type Builder struct {
+	d   Directive
+	err error
+}
+
+// NewDirective returns a builder for a directive named name.
+func NewDirective(name string) *Builder {
+	return new(Builder).Arg(name)
+}
+
+// Arg adds arguments to the directive.
+func (b *Builder) Arg(args ...string) *Builder {
+	for _, a := range args {
+		if err := checkArgument(a); err != nil && b.err == nil {
+			b.err = err
+		}
+		b.d.Arguments = append(b.d.Arguments, a)
+	}
+	return b
+}
+
+// Sub adds subdirectives to the directive.
+func (b *Builder) Sub(subs ...*Builder) *Builder {
+	for _, s := range subs {
+		d, err := s.Build()
+		if err != nil && b.err == nil {
+			b.err = err
+		}
+		b.d.Subdirectives = append(b.d.Subdirectives, d)
+	}
+	return b
+}
+
+// Comment adds a comment on the line before the directive, such as "# note".
+func (b *Builder) Comment(c string) *Builder {
+	if err := checkComment(c); err != nil && b.err == nil {
+		b.err = err
+	}
+	b.d.LeadingComments = append(b.d.LeadingComments, c)
+	return b
+}
+
+// Build returns the directive, or the first error in its construction or that of its subdirectives.
+func (b *Builder) Build() (Directive, error) {
+	if b.err != nil {
+		return Directive{}, b.err
+	}
+	return b.d.Clone(), nil
+}
+
+// BuildDocument returns a document holding the directives built by bs, ready to be encoded. Set its Extensions to those of the source it is written for, so that arguments containing punctuators are quoted.
+func BuildDocument(bs ...*Builder) (Document, error) {
+	doc := Document{Directives: make([]Directive, len(bs))}
+	for i, b := range bs {
+		d, err := b.Build()
+		if err != nil {
+			return Document{}, err
+		}
+		doc.Directives[i] = d
+	}
+	return doc, nil
+}
@@ -0,0 +1,57 @@
+// Command confetti-lsp is a language server for Confetti configuration files, speaking the Language Server Protocol over standard input and output.
+//
+// Usage:
+//
+//	confetti-lsp [-schema file] [flags]
+//
+// It reports syntax errors as diagnostics, formats documents, and provides folding ranges for blocks. Given a schema, a JSON encoding of confetti.Schema, it also reports directives that do not match it and shows the Doc of a directive when its name is hovered over.
+// The extension flags -c-style-comments, -expression-arguments, and -punctuators enable the corresponding language extensions.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	confetti "github.com/Heliodex/confetti"
+)
+
+func main() {
+	comments := flag.Bool("c-style-comments", false, "enable C-style comments")
+	expressions := flag.Bool("expression-arguments", false, "enable expression arguments")
+	punctuators := flag.String("punctuators", "", "enable punctuator arguments, separated by spaces or newlines")
+	schemaFile := flag.String("schema", "", "validate documents against the schema in this JSON file")
+	flag.Parse()
+
+	s := &server{conn: newConn(os.Stdin, os.Stdout), docs: map[string]string{}}
+	if *comments {
+		s.opts = append(s.opts, confetti.WithCStyleComments())
+	}
+	if *expressions {
+		s.opts = append(s.opts, confetti.WithExpressionArguments())
+	}
+	if *punctuators != "" {
+		s.opts = append(s.opts, confetti.WithPunctuators(strings.Fields(*punctuators)...))
+	}
+	if *schemaFile != "" {
+		data, err := os.ReadFile(*schemaFile)
+		if err == nil {
+			s.schema = &confetti.Schema{}
+			err = json.Unmarshal(data, s.schema)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "confetti-lsp:", err)
+			os.Exit(2)
+		}
+	}
+
+	down, err := s.serve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "confetti-lsp:", err)
+	}
+	if !down || err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import "unicode/utf8"
+
+// The subset of the Language Server Protocol used by the server.
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type textDocumentItem struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type didOpenParams struct {
+	TextDocument textDocumentItem `json:"textDocument"`
+}
+
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type didCloseParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type documentParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type positionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+type diagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+const (
+	severityError = iota + 1
+	severityWarning
+	severityInformation
+)
+
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+type textEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type foldingRange struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    lspRange      `json:"range"`
+}
+
+// lineBreak returns the length of the line break at the start of s, counting "\r\n" as one as the protocol does, or 0 if there is none.
+func lineBreak(s string) int {
+	switch {
+	case len(s) >= 2 && s[:2] == "\r\n":
+		return 2
+	case s != "" && (s[0] == '\n' || s[0] == '\r'):
+		return 1
+	}
+	return 0
+}
+
+// toPosition returns the protocol position of text[off], whose characters are counted in UTF-16 code units.
+func toPosition(text string, off int) position {
+	off = min(max(off, 0), len(text))
+	var p position
+	for i := 0; i < off; {
+		if n := lineBreak(text[i:]); n > 0 {
+			p.Line++
+			p.Character = 0
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r >= 0x10000 {
+			p.Character += 2
+		} else {
+			p.Character++
+		}
+		i += size
+	}
+	return p
+}
+
+// toOffset returns the byte offset in text of the protocol position p, clamped to its line.
+func toOffset(text string, p position) int {
+	i := 0
+	for line := 0; line < p.Line; line++ {
+		for i < len(text) && lineBreak(text[i:]) == 0 {
+			i++
+		}
+		if i == len(text) {
+			return i
+		}
+		i += lineBreak(text[i:])
+	}
+
+	for units := 0; i < len(text) && lineBreak(text[i:]) == 0; {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+		if units > p.Character {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+func toRange(text string, start, end int) lspRange {
+	return lspRange{toPosition(text, start), toPosition(text, end)}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	confetti "github.com/Heliodex/confetti"
+)
+
+type server struct {
+	conn   *conn
+	opts   []confetti.Option
+	schema *confetti.Schema
+	docs   map[string]string // open documents by URI
+	down   bool              // whether shutdown was requested
+}
+
+// serve handles messages until the client sends exit, returning whether it shut down first.
+func (s *server) serve() (bool, error) {
+	for {
+		m, err := s.conn.read()
+		var re *rpcError
+		if errors.As(err, &re) {
+			if err = s.conn.write(message{ID: json.RawMessage("null"), Error: re}); err != nil {
+				return false, err
+			}
+			continue
+		} else if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		if m.Method == "exit" {
+			return s.down, nil
+		} else if m.ID == nil {
+			if err = s.notification(m); err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		reply := message{ID: m.ID}
+		result, err := s.request(m)
+		if errors.As(err, &re) {
+			reply.Error = re
+		} else if err != nil {
+			return false, err
+		} else if reply.Result, err = json.Marshal(result); err != nil {
+			return false, err
+		}
+		if err = s.conn.write(reply); err != nil {
+			return false, err
+		}
+	}
+}
+
+func decodeParams(m message, v any) error {
+	if err := json.Unmarshal(m.Params, v); err != nil {
+		return &rpcError{codeInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+func (s *server) request(m message) (any, error) {
+	switch m.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":           1, // full
+				"documentFormattingProvider": true,
+				"foldingRangeProvider":       true,
+				"hoverProvider":              s.schema != nil,
+			},
+			"serverInfo": map[string]string{"name": "confetti-lsp"},
+		}, nil
+
+	case "shutdown":
+		s.down = true
+		return nil, nil
+
+	case "textDocument/formatting":
+		var params documentParams
+		if err := decodeParams(m, &params); err != nil {
+			return nil, err
+		}
+		return s.format(s.docs[params.TextDocument.URI]), nil
+
+	case "textDocument/foldingRange":
+		var params documentParams
+		if err := decodeParams(m, &params); err != nil {
+			return nil, err
+		}
+		return s.folds(s.docs[params.TextDocument.URI]), nil
+
+	case "textDocument/hover":
+		var params positionParams
+		if err := decodeParams(m, &params); err != nil {
+			return nil, err
+		}
+		text := s.docs[params.TextDocument.URI]
+		return s.hover(text, toOffset(text, params.Position)), nil
+	}
+	return nil, &rpcError{codeMethodNotFound, "method not found: " + m.Method}
+}
+
+func (s *server) notification(m message) error {
+	switch m.Method {
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if decodeParams(m, &params) != nil {
+			return nil
+		}
+		s.docs[params.TextDocument.URI] = params.TextDocument.Text
+		return s.publish(params.TextDocument.URI)
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if decodeParams(m, &params) != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		s.docs[params.TextDocument.URI] = params.ContentChanges[len(params.ContentChanges)-1].Text
+		return s.publish(params.TextDocument.URI)
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if decodeParams(m, &params) != nil {
+			return nil
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{params.TextDocument.URI, []diagnostic{}})
+	}
+	return nil
+}
+
+func (s *server) parse(text string) (confetti.Document, error) {
+	return confetti.Parse(text, s.opts...)
+}
+
+// nameEnd returns the offset just after the name of d.
+func nameEnd(d confetti.Directive) int {
+	if len(d.ArgumentSources) == 0 {
+		return d.Pos.Offset
+	}
+	return d.Pos.Offset + len(d.ArgumentSources[0].Text)
+}
+
+// publish sends the syntax errors of a document, or its schema violations if it has none.
+func (s *server) publish(uri string) error {
+	text := s.docs[uri]
+	diags := []diagnostic{}
+
+	doc, err := s.parse(text)
+	var pe *confetti.ParseError
+	if errors.As(err, &pe) {
+		start := pe.Pos.Offset
+		diags = append(diags, diagnostic{toRange(text, start, start+len(pe.Token)), severityError, "confetti", pe.Err.Error()})
+	} else if err != nil {
+		diags = append(diags, diagnostic{lspRange{}, severityError, "confetti", err.Error()})
+	} else if s.schema != nil {
+		directives := map[int]confetti.Directive{}
+		confetti.Walk(doc.Directives, func(d *confetti.Directive, _ int) bool {
+			directives[d.Pos.Offset] = *d
+			return true
+		})
+
+		for _, ve := range doc.Validate(*s.schema) {
+			var r lspRange
+			if d, ok := directives[ve.Pos.Offset]; ok && ve.Pos.IsValid() {
+				r = toRange(text, d.Pos.Offset, nameEnd(d))
+			}
+			diags = append(diags, diagnostic{r, severityWarning, "confetti", strings.Join(ve.Path, ".") + ": " + ve.Err.Error()})
+		}
+	}
+
+	for _, d := range doc.Diagnostics {
+		severity := severityInformation
+		switch d.Severity {
+		case confetti.SeverityError:
+			severity = severityError
+		case confetti.SeverityWarning:
+			severity = severityWarning
+		}
+		diags = append(diags, diagnostic{toRange(text, d.Pos.Offset, d.Pos.Offset), severity, "confetti", d.Message})
+	}
+
+	return s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{uri, diags})
+}
+
+// format returns the edits formatting text, or nil if it cannot be formatted.
+func (s *server) format(text string) []textEdit {
+	out, err := confetti.Fmt([]byte(text), s.opts...)
+	if err != nil {
+		return nil
+	} else if string(out) == text {
+		return []textEdit{}
+	}
+	return []textEdit{{toRange(text, 0, len(text)), string(out)}}
+}
+
+// folds returns a folding range for each block spanning more than one line, ending before the line of its closing brace.
+func (s *server) folds(text string) []foldingRange {
+	doc, err := s.parse(text)
+	if err != nil {
+		return nil
+	}
+
+	folds := []foldingRange{}
+	confetti.Walk(doc.Directives, func(d *confetti.Directive, _ int) bool {
+		if len(d.Subdirectives) == 0 {
+			return false
+		}
+		start, end := toPosition(text, d.Pos.Offset).Line, toPosition(text, d.End.Offset-1).Line-1
+		if end > start {
+			folds = append(folds, foldingRange{start, end})
+		}
+		return true
+	})
+	return folds
+}
+
+// hover returns the schema documentation of the directive whose name is at off, or nil if there is none.
+func (s *server) hover(text string, off int) *hover {
+	if s.schema == nil {
+		return nil
+	}
+	doc, err := s.parse(text)
+	if err != nil {
+		return nil
+	}
+
+	schema := s.schema
+	p := doc.Directives
+	for schema != nil {
+		i := 0
+		for i < len(p) && p[i].End.Offset < off {
+			i++
+		}
+		if i == len(p) || p[i].Pos.Offset > off {
+			return nil
+		}
+
+		d := p[i]
+		ds, ok := schema.Directives[d.Name()]
+		if !ok {
+			return nil
+		} else if off <= nameEnd(d) {
+			if ds.Doc == "" {
+				return nil
+			}
+			return &hover{markupContent{"markdown", "**" + d.Name() + "**\n\n" + ds.Doc}, toRange(text, d.Pos.Offset, nameEnd(d))}
+		}
+		schema, p = ds.Sub, d.Subdirectives
+	}
+	return nil
+}
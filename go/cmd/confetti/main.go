@@ -0,0 +1,386 @@
+// Command confetti formats, validates, lints, and converts Confetti configuration files.
+//
+// Usage:
+//
+//	confetti fmt [-w] [flags] [file ...]
+//	confetti validate [flags] [file ...]
+//	confetti lint [-format text|json|sarif] [flags] [file ...]
+//	confetti convert [-to json|yaml|toml|dot|confetti] [flags] [file]
+//	confetti diff [flags] old new
+//	confetti query [flags] selector [file]
+//	confetti minify [flags] [file]
+//
+// Each command but diff reads standard input if no files are given, and diff reads it for a file named "-". Query prints the directives selected by a selector, as described by confetti.CompileSelector, each after a line giving its position. Validate reports every forbidden character in a source, not just the first. Lint reports the problems the rules of the lint package find, and any parse errors and warnings, failing if there are any. The extension flags -c-style-comments, -expression-arguments, and -punctuators enable the corresponding language extensions.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	confetti "github.com/Heliodex/confetti"
+	"github.com/Heliodex/confetti/lint"
+	"github.com/Heliodex/confetti/tomlconv"
+	"github.com/Heliodex/confetti/yamlconv"
+)
+
+const usage = `usage:
+	confetti fmt [-w] [flags] [file ...]
+	confetti validate [flags] [file ...]
+	confetti lint [-format text|json|sarif] [flags] [file ...]
+	confetti convert [-to json|yaml|toml|dot|confetti] [flags] [file]
+	confetti diff [flags] old new
+	confetti query [flags] selector [file]
+	confetti minify [flags] [file]
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "fmt":
+		err = runFmt(args)
+	case "validate":
+		err = runValidate(args)
+	case "lint":
+		err = runLint(args)
+	case "convert":
+		err = runConvert(args)
+	case "diff":
+		err = runDiff(args)
+	case "query":
+		err = runQuery(args)
+	case "minify":
+		err = runMinify(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "confetti: unknown command %q\n%s", cmd, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "confetti:", err)
+		os.Exit(1)
+	}
+}
+
+// newFlags returns a flag set with the extension flags, and a function returning the options they select.
+func newFlags(name string) (*flag.FlagSet, func() []confetti.Option) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	comments := fs.Bool("c-style-comments", false, "enable C-style comments")
+	expressions := fs.Bool("expression-arguments", false, "enable expression arguments")
+	punctuators := fs.String("punctuators", "", "enable punctuator arguments, separated by spaces or newlines")
+
+	return fs, func() (opts []confetti.Option) {
+		if *comments {
+			opts = append(opts, confetti.WithCStyleComments())
+		}
+		if *expressions {
+			opts = append(opts, confetti.WithExpressionArguments())
+		}
+		if *punctuators != "" {
+			opts = append(opts, confetti.WithPunctuators(strings.Fields(*punctuators)...))
+		}
+		return
+	}
+}
+
+// read returns the contents of the named file, or standard input if name is "-".
+func read(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(name)
+}
+
+// sourceName returns the name used for a file in error messages.
+func sourceName(name string) string {
+	if name == "-" {
+		return "<standard input>"
+	}
+	return name
+}
+
+// color reports whether to color output to f, which is when it is a terminal and the NO_COLOR environment variable is not set.
+func color(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func files(fs *flag.FlagSet) []string {
+	if fs.NArg() == 0 {
+		return []string{"-"}
+	}
+	return fs.Args()
+}
+
+func runFmt(args []string) error {
+	fs, opts := newFlags("fmt")
+	write := fs.Bool("w", false, "write the result to the file instead of standard output")
+	fs.Parse(args)
+
+	for _, name := range files(fs) {
+		src, err := read(name)
+		if err != nil {
+			return err
+		}
+
+		out, err := confetti.Fmt(src, append(opts(), confetti.WithName(sourceName(name)))...)
+		if err != nil {
+			return err
+		}
+
+		if !*write || name == "-" {
+			os.Stdout.Write(out)
+		} else if !bytes.Equal(src, out) {
+			if err = os.WriteFile(name, out, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runValidate(args []string) error {
+	fs, opts := newFlags("validate")
+	fs.Parse(args)
+
+	failed := false
+	for _, name := range files(fs) {
+		src, err := read(name)
+		if err != nil {
+			return err
+		}
+
+		_, err = confetti.Parse(string(src), append(opts(), confetti.WithName(sourceName(name)), confetti.WithAllIllegalCharacters())...)
+		if err == nil {
+			continue
+		}
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+		for _, err := range errs {
+			var pe *confetti.ParseError
+			if errors.As(err, &pe) {
+				fmt.Fprintln(os.Stderr, pe.Render(color(os.Stderr)))
+			} else {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		failed = true
+	}
+
+	if failed {
+		return errors.New("validation failed")
+	}
+	return nil
+}
+
+func runLint(args []string) error {
+	fs, opts := newFlags("lint")
+	format := fs.String("format", "text", "output format: text, json, or sarif")
+	fs.Parse(args)
+
+	var all []lint.Diagnostic
+	for _, name := range files(fs) {
+		src, err := read(name)
+		if err != nil {
+			return err
+		}
+
+		diags, err := lint.Lint(src, lint.DefaultRules(), append(opts(), confetti.WithName(sourceName(name)), confetti.WithWarnings())...)
+		var pe *confetti.ParseError
+		if errors.As(err, &pe) {
+			diags = []lint.Diagnostic{lint.FromError(err)}
+		} else if err != nil {
+			return err
+		}
+		all = append(all, diags...)
+	}
+
+	switch *format {
+	case "text":
+		for _, d := range all {
+			fmt.Println(d)
+		}
+	case "json":
+		if all == nil {
+			all = []lint.Diagnostic{}
+		}
+		data, err := json.MarshalIndent(all, "", "  ")
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(append(data, '\n'))
+	case "sarif":
+		if err := lint.WriteSARIF(os.Stdout, "confetti", all); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", *format)
+	}
+
+	if len(all) > 0 {
+		return errors.New("lint found problems")
+	}
+	return nil
+}
+
+func runMinify(args []string) error {
+	fs, opts := newFlags("minify")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return errors.New("minify takes at most one file")
+	}
+	name := files(fs)[0]
+
+	src, err := read(name)
+	if err != nil {
+		return err
+	}
+	out, err := confetti.Minify(src, append(opts(), confetti.WithName(sourceName(name)))...)
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(out)
+	return nil
+}
+
+func runConvert(args []string) error {
+	fs, opts := newFlags("convert")
+	to := fs.String("to", "json", "output format: json, yaml, toml, dot, or confetti")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return errors.New("convert takes at most one file")
+	}
+	name := files(fs)[0]
+
+	src, err := read(name)
+	if err != nil {
+		return err
+	}
+	doc, err := confetti.Parse(string(src), append(opts(), confetti.WithName(sourceName(name)))...)
+	if err != nil {
+		return err
+	}
+
+	switch *to {
+	case "json":
+		p := doc.Directives
+		if p == nil {
+			p = []confetti.Directive{}
+		}
+		data, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+
+	case "yaml":
+		data, err := yamlconv.ToYAML(doc)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+
+	case "toml":
+		data, err := tomlconv.ToTOML(doc)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+
+	case "dot":
+		return doc.ToDOT(os.Stdout)
+
+	case "confetti":
+		return confetti.NewEncoder(os.Stdout).Encode(doc)
+	}
+	return fmt.Errorf("unknown output format %q", *to)
+}
+
+func runDiff(args []string) error {
+	fs, opts := newFlags("diff")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return errors.New("diff takes two files")
+	}
+	docs := make([]confetti.Document, 2)
+	for i, name := range fs.Args() {
+		src, err := read(name)
+		if err != nil {
+			return err
+		}
+		if docs[i], err = confetti.Parse(string(src), append(opts(), confetti.WithName(sourceName(name)))...); err != nil {
+			return err
+		}
+	}
+
+	if len(confetti.Diff(docs[0], docs[1])) == 0 {
+		return nil
+	}
+	out, err := confetti.UnifiedDiff(docs[0], docs[1])
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.WriteString(out)
+	return err
+}
+
+func runQuery(args []string) error {
+	fs, opts := newFlags("query")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return errors.New("query takes a selector and at most one file")
+	}
+	sel, err := confetti.CompileSelector(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	name := "-"
+	if fs.NArg() == 2 {
+		name = fs.Arg(1)
+	}
+
+	src, err := read(name)
+	if err != nil {
+		return err
+	}
+	doc, err := confetti.Parse(string(src), append(opts(), confetti.WithName(sourceName(name)))...)
+	if err != nil {
+		return err
+	}
+
+	enc := confetti.NewEncoder(os.Stdout)
+	for _, m := range sel.Select(doc) {
+		fmt.Printf("# %s: %s\n", m.Directive.Pos, strings.Join(m.Names, "."))
+		d := *m.Directive
+		d.LeadingComments = nil
+		if err = enc.Encode(confetti.Document{Directives: []confetti.Directive{d}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package confetti
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// locate finds the first and last tokens of the directive at path, including its block.
+func locate(ts []Token, path []int) (first, last int, ok bool) {
+	type level struct {
+		idx  int  // index of the current (or previous) directive at this level
+		open bool // whether the current directive is still collecting arguments
+	}
+	stack := []level{{idx: -1}}
+
+	at := func() bool {
+		if len(stack) != len(path) {
+			return false
+		}
+		for i, l := range stack {
+			if l.idx != path[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	first = -1
+	for i, t := range ts {
+		top := &stack[len(stack)-1]
+
+		switch t.Kind {
+		case TokenArgument, TokenQuotedArgument, TokenTripleQuotedArgument, TokenPunctuator:
+			if !top.open {
+				top.idx++
+				top.open = true
+			}
+			if at() {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+
+		case TokenSemicolon, TokenNewline:
+			top.open = false
+
+		case TokenOpenBrace:
+			top.open = false
+			stack = append(stack, level{idx: -1})
+
+		case TokenCloseBrace:
+			if len(stack) == 1 {
+				return -1, -1, false
+			}
+			stack = stack[:len(stack)-1]
+			if at() {
+				last = i
+			}
+		}
+	}
+
+	return first, last, first >= 0
+}
+
+// lineStart returns the offset of the start of the line containing src[off].
+func lineStart(src string, off int) int {
+	for off > 0 {
+		r, size := utf8.DecodeLastRuneInString(src[:off])
+		if isLineTerminator(r) {
+			break
+		}
+		off -= size
+	}
+	return off
+}
+
+// lineEnd returns the offset of the line terminator ending the line containing src[off], or len(src).
+func lineEnd(src string, off int) int {
+	for off < len(src) {
+		r, size := utf8.DecodeRuneInString(src[off:])
+		if isLineTerminator(r) {
+			break
+		}
+		off += size
+	}
+	return off
+}
+
+// splitLines splits src into lines, keeping each line's terminator.
+func splitLines(src string) (lines []string) {
+	for start, i := 0, 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		i += size
+		if r == '\r' && strings.HasPrefix(src[i:], "\n") {
+			i++
+		} else if !isLineTerminator(r) && i < len(src) {
+			continue
+		}
+		lines = append(lines, src[start:i])
+		start = i
+	}
+	return
+}
+
+// cutTerminator splits a line into its content and its line terminator.
+func cutTerminator(line string) (content, term string) {
+	content = strings.TrimRightFunc(line, isLineTerminator)
+	return content, line[len(content):]
+}
+
+func cutIndent(line string) (indent, rest string) {
+	rest = strings.TrimLeftFunc(line, isWhitespace)
+	return line[:len(line)-len(rest)], rest
+}
+
+// CommentOut turns the directive at path, including its block, into comment lines. The comment marker is inserted at the block's shallowest indentation, so Uncomment can restore the source exactly.
+// The directive must not share a line with any other directive. Options other than WithMaxDepth and those enabling extensions are ignored.
+func CommentOut(src string, path []int, opts ...Option) (string, error) {
+	if len(path) == 0 {
+		return "", errors.New("empty directive path")
+	}
+
+	c := newConfig(opts)
+	if c.err != nil {
+		return "", c.err
+	}
+	ts, err := lex(src, c.exts)
+	if err != nil {
+		return "", fmt.Errorf("error: %w", err)
+	} else if _, err = parse(ts, c.exts, c.maxDepth); err != nil {
+		return "", fmt.Errorf("error: %w", err)
+	}
+
+	first, last, ok := locate(ts, path)
+	if !ok {
+		return "", fmt.Errorf("no directive at path %v", path)
+	}
+
+	offs := make([]int, len(ts)+1)
+	for i, t := range ts {
+		offs[i+1] = offs[i] + len(t.Text)
+	}
+
+	start, end := lineStart(src, offs[first]), lineEnd(src, offs[last+1])
+	for i, t := range ts {
+		if offs[i] < start || offs[i] >= end || i >= first && i <= last {
+			continue
+		}
+		switch t.Kind {
+		case TokenArgument, TokenQuotedArgument, TokenTripleQuotedArgument, TokenPunctuator, TokenOpenBrace, TokenCloseBrace:
+			return "", fmt.Errorf("directive at path %v shares a line with another directive", path)
+		}
+	}
+
+	lines := splitLines(src[start:end])
+
+	// the marker goes at the shallowest indentation of the block
+	base := ""
+	for i, line := range lines {
+		content, _ := cutTerminator(line)
+		if indent, rest := cutIndent(content); rest == "" {
+			continue
+		} else if i == 0 || len(indent) < len(base) {
+			base = indent
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(src[:start])
+	for _, line := range lines {
+		content, term := cutTerminator(line)
+		indent, _ := cutIndent(content)
+		if strings.HasPrefix(indent, base) {
+			indent = base
+		}
+		if rest := content[len(indent):]; rest == "" {
+			b.WriteString(indent + "#" + term)
+		} else {
+			b.WriteString(indent + "# " + rest + term)
+		}
+	}
+	b.WriteString(src[end:])
+
+	return b.String(), nil
+}
+
+// Uncomment reverses CommentOut. It takes the longest run of comment lines starting at line (counted from 1) that forms exactly one directive, and removes one comment marker from each of them.
+func Uncomment(src string, line int, opts ...Option) (string, error) {
+	lines := splitLines(src)
+	if line < 1 || line > len(lines) {
+		return "", fmt.Errorf("line %d out of range", line)
+	}
+
+	var body []string
+	var text string
+	n := 0
+	for _, l := range lines[line-1:] {
+		content, term := cutTerminator(l)
+		indent, rest := cutIndent(content)
+		if !strings.HasPrefix(rest, "#") {
+			break
+		}
+		body = append(body, indent+strings.TrimPrefix(rest[1:], " ")+term)
+
+		t := strings.Join(body, "")
+		if doc, err := Load(t, opts...); err == nil && len(doc.Directives) == 1 {
+			text, n = t, len(body)
+		}
+	}
+	if n == 0 {
+		return "", fmt.Errorf("no commented-out directive at line %d", line)
+	}
+
+	out := strings.Join(slices.Concat(lines[:line-1], []string{text}, lines[line-1+n:]), "")
+	if _, err := Load(out, opts...); err != nil {
+		return "", err
+	}
+	return out, nil
+}
@@ -0,0 +1,575 @@
+package confetti
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Unmarshal parses data and stores the result in the struct pointed to by v.
+//
+// Each directive is matched to the struct field whose `confetti:"name"` tag equals its first argument, or, without a tag, whose name equals it ignoring case. Fields tagged "-" and directives without a matching field are ignored, unless Decoder.DisallowUnknownDirectives is called. A field is decoded by its type:
+//
+//   - strings, booleans, numbers, time.Duration, url.URL, and types implementing ArgumentUnmarshaler or encoding.TextUnmarshaler take the directive's single remaining argument, the last directive winning if there are several unless Decoder.SetDuplicatePolicy says otherwise
+//   - structs take the directive's subdirectives, decoded by the same rules
+//   - slices of scalars take the remaining arguments of every matching directive
+//   - slices of structs take one element per matching directive
+//   - interfaces take the value ToValue would give the directive
+//   - pointers are allocated if they are nil, and take what the value they point to would; they stay nil if no directive matches
+//
+// The fields of an embedded struct without a tag are promoted, as encoding/json promotes them: each is matched as if it were a field of the outer struct, unless that has a field of the same name, and pointers to embedded structs are allocated when a directive matches one of their fields. Embedded structs with a tag are matched by name like other fields.
+//
+// Options may follow the name in a tag, separated by commas. With "required", decoding fails if no directive matches the field; every missing directive is reported, joined with errors.Join, as a *DecodeError at the directive it was expected in. With "default=value", a field no directive matches is decoded as if value were the remaining arguments of one, so `confetti:"port,default=8080"` gives 8080. The default runs to the end of the tag, or to a following "comment=" option, so it must come last. The "omitempty" and "comment=" options are for Marshal, and are ignored here. A struct field no directive matches still gets the defaults of its own fields.
+//
+// With "inline", the field must be a struct, decoded from the directive's remaining arguments, one scalar field each in order, rather than from subdirectives. Fields without an argument keep their values.
+//
+// With "key", the field must be a map, and each matching directive becomes the entry keyed by its second argument, decoded from the arguments after that and its subdirectives by the rules above. So "upstream app1 { ... }" and "upstream app2 { ... }" fill a map[string]Upstream tagged `confetti:"upstream,key"`. A repeated key is decoded again into the same entry, or handled as Decoder.SetDuplicatePolicy says.
+//
+// Decoder.SetDecodeHook lets an application convert arguments into values of its own, such as sizes written "4k", before these rules apply.
+func Unmarshal(data []byte, v any) error {
+	return NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Decode decodes the subdirectives of d into the struct pointed to by v, as Unmarshal decodes a document, so that a section such as a "database" block can be decoded on its own once found. The arguments of d are not decoded. Paths in errors start with the name of d.
+func (d Directive) Decode(v any) error {
+	var s decodeState
+	return s.decodeInto(d.Subdirectives, v, []string{d.Name()}, d.Pos)
+}
+
+// DecodeError is an error decoding a directive into a Go value.
+type DecodeError struct {
+	// Pos is the position of the directive, or, if it is missing, of the directive it was expected in.
+	Pos Position
+	// Path is the names of the directive and its parents.
+	Path []string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	s := strings.Join(e.Path, ".") + ": " + e.Err.Error()
+	if e.Pos.IsValid() || e.Pos.Filename != "" {
+		s = e.Pos.String() + ": " + s
+	}
+	return s
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DuplicatePolicy selects what decoding does when more than one directive matches a field taking a single value, which is any field but a slice. Slices always take every matching directive.
+type DuplicatePolicy uint8
+
+const (
+	// DuplicateLast decodes each directive in turn, so the last one wins.
+	DuplicateLast DuplicatePolicy = iota
+	// DuplicateFirst decodes the first directive and ignores the rest.
+	DuplicateFirst
+	// DuplicateError fails with a *DecodeError at the second directive, giving the position of the first.
+	DuplicateError
+	// DuplicateAppend gives interface fields a []any of the values of every directive, as ToValue does for repeated directives, and otherwise fails like DuplicateError.
+	DuplicateAppend
+)
+
+// ArgumentUnmarshaler is implemented by types that decode themselves from a single argument, such as log levels or lists of networks written in one argument. The decoder calls UnmarshalConfettiArgument on a pointer to the value, with the argument as written, in place of the usual conversions and before encoding.TextUnmarshaler, but after any decode hook.
+type ArgumentUnmarshaler interface {
+	UnmarshalConfettiArgument(arg string) error
+}
+
+// A DecodeHook converts an argument being decoded into a value of type target, in place of the usual rules. It is given each argument decoded into a scalar, a slice element, or a map key, and the single argument of a directive without a block decoded into a struct.
+// It returns nil to have the argument decoded as usual, a value assignable to target to store it, or, for a scalar target, a string to decode in place of the argument. An error fails decoding with a *DecodeError at the directive.
+type DecodeHook func(arg string, target reflect.Type) (any, error)
+
+// decodeState holds what is gathered while decoding a document.
+type decodeState struct {
+	dups    DuplicatePolicy
+	hook    DecodeHook
+	strict  bool                // whether directives without a matching field fail
+	missing []error             // required directives not found
+	keys    map[mapKey]Position // of the directive giving each map entry
+}
+
+// mapKey identifies an entry of a map being decoded.
+type mapKey struct {
+	m   uintptr
+	key string
+}
+
+// decodeInto decodes p, whose parent is at path and pos, into the struct v points to.
+func (s *decodeState) decodeInto(p []Directive, v any, path []string, pos Position) error {
+	sd, err := s.structInto(v, path, pos)
+	if err != nil {
+		return err
+	}
+	for _, d := range p {
+		if err = sd.directive(d); err != nil {
+			return err
+		}
+	}
+	return sd.finishInto()
+}
+
+// structInto returns a decoder of directives into the struct v points to, for decodeInto, whose parent is at path and pos.
+func (s *decodeState) structInto(v any, path []string, pos Position) (*structDecoder, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return nil, fmt.Errorf("cannot decode into %T", v)
+	} else if rv = rv.Elem(); rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot decode into %T", v)
+	}
+	return s.newStructDecoder(rv, path, pos), nil
+}
+
+// fieldOptions are the options following the name in a field's tag.
+type fieldOptions struct {
+	required   bool
+	key        bool
+	omitEmpty  bool
+	inline     bool
+	def        string
+	hasDefault bool
+	comment    string
+}
+
+func tagOptions(f reflect.StructField) (o fieldOptions) {
+	_, opts, _ := strings.Cut(f.Tag.Get("confetti"), ",")
+	for opts != "" {
+		if rest, ok := strings.CutPrefix(opts, "default="); ok {
+			o.def, opts = cutOptionValue(rest)
+			o.hasDefault = true
+			continue
+		} else if rest, ok := strings.CutPrefix(opts, "comment="); ok {
+			o.comment, opts = cutOptionValue(rest)
+			continue
+		}
+
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		switch opt {
+		case "required":
+			o.required = true
+		case "key":
+			o.key = true
+		case "omitempty":
+			o.omitEmpty = true
+		case "inline":
+			o.inline = true
+		}
+	}
+	return
+}
+
+// cutOptionValue splits the value of a default= or comment= option, which runs to the end of the tag or the other of the two, from the options after it.
+func cutOptionValue(s string) (value, rest string) {
+	i := -1
+	for _, sep := range [...]string{",default=", ",comment="} {
+		if j := strings.Index(s, sep); j >= 0 && (i < 0 || j < i) {
+			i = j
+		}
+	}
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
+
+// fieldByName returns the index in fields of the field a directive name maps to.
+func fieldByName(fields []structField, name string) (int, bool) {
+	for i, f := range fields {
+		if f.tagged && f.name == name || !f.tagged && strings.EqualFold(f.name, name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// decodeStruct decodes p, whose parent is at pos, into the struct rv.
+func (s *decodeState) decodeStruct(p []Directive, rv reflect.Value, path []string, pos Position) error {
+	sd := s.newStructDecoder(rv, path, pos)
+	for _, d := range p {
+		if err := sd.directive(d); err != nil {
+			return err
+		}
+	}
+	return sd.finish()
+}
+
+// structDecoder decodes the directives of a struct one at a time, so that those of a source being read can be decoded as they are parsed.
+type structDecoder struct {
+	s      *decodeState
+	rv     reflect.Value
+	path   []string
+	pos    Position
+	fields []structField
+	found  []bool
+	first  []Position // of the directive matching each field
+	values []any      // of each interface field, for DuplicateAppend
+}
+
+func (s *decodeState) newStructDecoder(rv reflect.Value, path []string, pos Position) *structDecoder {
+	fields := structFields(rv.Type())
+	return &structDecoder{s: s, rv: rv, path: path, pos: pos, fields: fields, found: make([]bool, len(fields))}
+}
+
+// directive decodes the next directive of the struct.
+func (sd *structDecoder) directive(d Directive) error {
+	s, found := sd.s, sd.found
+	if len(d.Arguments) == 0 {
+		return nil
+	}
+
+	dpath := append(sd.path[:len(sd.path):len(sd.path)], d.Arguments[0])
+	i, ok := fieldByName(sd.fields, d.Arguments[0])
+	if !ok && s.strict {
+		return &DecodeError{d.Pos, dpath, errors.New("unknown directive")}
+	} else if !ok {
+		return nil
+	}
+	f := sd.fields[i]
+	fv, _ := fieldValue(sd.rv, f.Index, true)
+	opts := tagOptions(f.StructField)
+
+	if opts.key {
+		found[i] = true
+		if err := s.decodeKeyed(d, fv, dpath); err != nil {
+			if _, ok := err.(*DecodeError); ok {
+				return err
+			}
+			return &DecodeError{d.Pos, dpath, err}
+		}
+		return nil
+	}
+
+	if found[i] && (isScalar(f.Type) || f.Type.Kind() != reflect.Slice || f.Type.Elem().Kind() == reflect.Uint8) {
+		switch {
+		case s.dups == DuplicateFirst:
+			return nil
+		case s.dups == DuplicateAppend && f.Type.Kind() == reflect.Interface && f.Type.NumMethod() == 0:
+			sd.values[i] = append(sd.values[i].([]any), toSingle(d))
+			fv.Set(reflect.ValueOf(sd.values[i]))
+			return nil
+		case s.dups == DuplicateError, s.dups == DuplicateAppend:
+			return &DecodeError{d.Pos, dpath, fmt.Errorf("repeated directive, first at %s", sd.first[i])}
+		}
+	}
+	if sd.first == nil {
+		sd.first, sd.values = make([]Position, len(found)), make([]any, len(found))
+	}
+	found[i], sd.first[i] = true, d.Pos
+	if s.dups == DuplicateAppend && f.Type.Kind() == reflect.Interface {
+		sd.values[i] = []any{toSingle(d)}
+	}
+
+	if err := s.decodeTagged(d, fv, dpath, opts); err != nil {
+		if _, ok := err.(*DecodeError); ok {
+			return err
+		}
+		return &DecodeError{d.Pos, dpath, err}
+	}
+	return nil
+}
+
+// finish fills in the fields no directive matched.
+func (sd *structDecoder) finish() error {
+	return sd.s.absent(sd.rv, sd.found, sd.path, sd.pos, true)
+}
+
+// finishInto finishes decoding the struct of decodeInto, reporting the required directives missing from it and those within it.
+func (sd *structDecoder) finishInto() error {
+	if err := sd.finish(); err != nil {
+		return err
+	}
+	return errors.Join(sd.s.missing...)
+}
+
+// decodeKeyed decodes d into the entry of the map rv keyed by its second argument.
+func (s *decodeState) decodeKeyed(d Directive, rv reflect.Value, path []string) error {
+	t := rv.Type()
+	if t.Kind() != reflect.Map || !isScalar(t.Key()) {
+		return fmt.Errorf("cannot decode keyed directives into field of type %s", t)
+	} else if len(d.Arguments) < 2 {
+		return errors.New("expected a key argument")
+	}
+
+	name := d.Arguments[1]
+	k := reflect.New(t.Key()).Elem()
+	if err := s.decodeScalar(k, name); err != nil {
+		return fmt.Errorf("key %q: %w", name, err)
+	}
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(t))
+	}
+
+	v := reflect.New(t.Elem()).Elem()
+	id := mapKey{rv.Pointer(), name}
+	if old := rv.MapIndex(k); old.IsValid() {
+		switch s.dups {
+		case DuplicateFirst:
+			return nil
+		case DuplicateError, DuplicateAppend:
+			return fmt.Errorf("repeated key %q, first at %s", name, s.keys[id])
+		}
+		v.Set(old)
+	} else {
+		if s.keys == nil {
+			s.keys = map[mapKey]Position{}
+		}
+		s.keys[id] = d.Pos
+	}
+
+	entry := d
+	entry.Arguments = append([]string{d.Arguments[0]}, d.Arguments[2:]...)
+	if err := s.decodeField(entry, v, append(path[:len(path):len(path)], name)); err != nil {
+		return err
+	}
+	rv.SetMapIndex(k, v)
+	return nil
+}
+
+// absent fills in the fields of rv no directive matched with their defaults, reporting them if they are required and require is true.
+func (s *decodeState) absent(rv reflect.Value, found []bool, path []string, pos Position, require bool) error {
+	for i, f := range structFields(rv.Type()) {
+		if found != nil && found[i] {
+			continue
+		}
+
+		fpath := append(path[:len(path):len(path)], f.name)
+		switch opts := tagOptions(f.StructField); {
+		case opts.required && require:
+			s.missing = append(s.missing, &DecodeError{pos, fpath, errMissing})
+
+		case opts.hasDefault:
+			doc, err := Parse("_ " + opts.def)
+			if err == nil && len(doc.Directives) != 1 {
+				err = errors.New("not a single directive")
+			}
+			if err == nil {
+				fv, _ := fieldValue(rv, f.Index, true)
+				err = s.decodeTagged(doc.Directives[0], fv, fpath, opts)
+			}
+			if err != nil {
+				return &DecodeError{pos, fpath, fmt.Errorf("default %q: %w", opts.def, err)}
+			}
+
+		case f.Type.Kind() == reflect.Struct && !isScalar(f.Type):
+			if fv, ok := fieldValue(rv, f.Index, false); ok {
+				if err := s.absent(fv, nil, fpath, pos, false); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	durationType        = reflect.TypeFor[time.Duration]()
+	urlType             = reflect.TypeFor[url.URL]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	argUnmarshalerType  = reflect.TypeFor[ArgumentUnmarshaler]()
+)
+
+// isScalar reports whether values of type t are decoded from a single argument.
+func isScalar(t reflect.Type) bool {
+	if pt := reflect.PointerTo(t); t == urlType || pt.Implements(argUnmarshalerType) || pt.Implements(textUnmarshalerType) {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func (s *decodeState) decodeField(d Directive, rv reflect.Value, path []string) error {
+	args := d.Arguments[1:]
+
+	switch t := rv.Type(); {
+	case isScalar(t):
+		if len(args) != 1 {
+			return fmt.Errorf("expected 1 argument, got %d", len(args))
+		}
+		return s.decodeScalar(rv, args[0])
+
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		if len(args) != 1 {
+			return fmt.Errorf("expected 1 argument, got %d", len(args))
+		}
+		rv.SetBytes([]byte(args[0]))
+		return nil
+
+	case t.Kind() == reflect.Struct:
+		if len(args) == 1 && len(d.Subdirectives) == 0 {
+			if ok, err := s.hooked(rv, args[0]); ok || err != nil {
+				return err
+			}
+		}
+		return s.decodeStruct(d.Subdirectives, rv, path, d.Pos)
+
+	case t.Kind() == reflect.Slice && isScalar(t.Elem()):
+		for _, a := range args {
+			e := reflect.New(t.Elem()).Elem()
+			if err := s.decodeScalar(e, a); err != nil {
+				return err
+			}
+			rv.Set(reflect.Append(rv, e))
+		}
+		return nil
+
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct:
+		e := reflect.New(t.Elem()).Elem()
+		if err := s.decodeStruct(d.Subdirectives, e, path, d.Pos); err != nil {
+			return err
+		}
+		rv.Set(reflect.Append(rv, e))
+		return nil
+
+	case t.Kind() == reflect.Interface && t.NumMethod() == 0:
+		if v := toSingle(d); v != nil {
+			rv.Set(reflect.ValueOf(v))
+		}
+		return nil
+
+	case t.Kind() == reflect.Pointer:
+		if rv.IsNil() {
+			rv.Set(reflect.New(t.Elem()))
+		}
+		return s.decodeField(d, rv.Elem(), path)
+	}
+
+	return fmt.Errorf("cannot decode into field of type %s", rv.Type())
+}
+
+// decodeTagged decodes d into the field rv, as its tag options say.
+func (s *decodeState) decodeTagged(d Directive, rv reflect.Value, path []string, opts fieldOptions) error {
+	if opts.inline {
+		return s.decodeInline(d.Arguments[1:], rv)
+	}
+	return s.decodeField(d, rv, path)
+}
+
+// decodeInline decodes args into the fields of the struct rv in order, as the "inline" option writes them.
+func (s *decodeState) decodeInline(args []string, rv reflect.Value) error {
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot decode inline arguments into field of type %s", rv.Type())
+	}
+
+	for _, f := range structFields(rv.Type()) {
+		if len(args) == 0 {
+			return nil
+		} else if !isScalar(f.Type) {
+			return fmt.Errorf("cannot decode inline argument into field of type %s", f.Type)
+		}
+		fv, _ := fieldValue(rv, f.Index, true)
+		if err := s.decodeScalar(fv, args[0]); err != nil {
+			return err
+		}
+		args = args[1:]
+	}
+
+	if len(args) > 0 {
+		return fmt.Errorf("%d arguments too many", len(args))
+	}
+	return nil
+}
+
+// hooked stores in rv the value the decode hook gives for arg, reporting false if there is no hook or it left arg to be decoded as usual.
+func (s *decodeState) hooked(rv reflect.Value, arg string) (bool, error) {
+	if s.hook == nil {
+		return false, nil
+	}
+	v, err := s.hook(arg, rv.Type())
+	if err != nil {
+		return true, err
+	} else if v == nil {
+		return false, nil
+	}
+
+	if hv := reflect.ValueOf(v); hv.Type().AssignableTo(rv.Type()) {
+		rv.Set(hv)
+		return true, nil
+	} else if str, ok := v.(string); ok && isScalar(rv.Type()) {
+		return true, setScalar(rv, str)
+	}
+	return true, fmt.Errorf("decode hook returned %T for %s", v, rv.Type())
+}
+
+// decodeScalar stores arg in the scalar rv, converted by the decode hook if there is one.
+func (s *decodeState) decodeScalar(rv reflect.Value, arg string) error {
+	if ok, err := s.hooked(rv, arg); ok || err != nil {
+		return err
+	}
+	return setScalar(rv, arg)
+}
+
+func setScalar(rv reflect.Value, s string) error {
+	if u, ok := rv.Addr().Interface().(ArgumentUnmarshaler); ok {
+		return u.UnmarshalConfettiArgument(s)
+	} else if u, ok := rv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	} else if rv.Type() == urlType {
+		u, err := url.Parse(s)
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(*u))
+		return nil
+	} else if rv.Type() == durationType {
+		dur, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		rv.SetInt(int64(dur))
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(s)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		rv.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, rv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		rv.SetInt(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, rv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		rv.SetUint(n)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, rv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		rv.SetFloat(f)
+	}
+	return nil
+}
@@ -0,0 +1,521 @@
+package confetti_test
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	confetti "github.com/Heliodex/confetti"
+)
+
+type testServer struct {
+	Host    string
+	Port    uint16        `confetti:"listen"`
+	Timeout time.Duration `confetti:"timeout"`
+	Debug   bool
+	Aliases []string `confetti:"alias"`
+	Ignored string   `confetti:"-"`
+}
+
+type testConfig struct {
+	Name    string
+	Ratio   float64
+	Servers []testServer `confetti:"server"`
+	Extra   any          `confetti:"extra"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	var c testConfig
+	err := confetti.Unmarshal([]byte(`name example
+ratio 0.5
+server {
+    host example.com
+    listen 443
+    timeout 1m30s
+    debug true
+    alias www.example.com
+    alias cdn.example.com static.example.com
+    ignored value
+}
+server {
+    host localhost
+    listen 8080
+}
+extra a b
+unknown directive
+`), &c)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal configuration: %v", err)
+	}
+
+	if c.Name != "example" || c.Ratio != 0.5 || len(c.Servers) != 2 {
+		t.Fatalf("Unexpected configuration: %+v", c)
+	}
+
+	s := c.Servers[0]
+	if s.Host != "example.com" || s.Port != 443 || s.Timeout != 90*time.Second || !s.Debug || s.Ignored != "" {
+		t.Fatalf("Unexpected server: %+v", s)
+	} else if !slices.Equal(s.Aliases, []string{"www.example.com", "cdn.example.com", "static.example.com"}) {
+		t.Fatalf("Unexpected aliases: %q", s.Aliases)
+	} else if extra, ok := c.Extra.([]any); !ok || len(extra) != 2 {
+		t.Fatalf("Unexpected extra value: %v", c.Extra)
+	}
+}
+
+func TestUnmarshalError(t *testing.T) {
+	var c testConfig
+	err := confetti.Unmarshal([]byte("server { listen http }\n"), &c)
+
+	var derr *confetti.DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("Expected DecodeError, got %v", err)
+	} else if !slices.Equal(derr.Path, []string{"server", "listen"}) {
+		t.Fatalf("Expected path server.listen, got %v", derr.Path)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	c := testConfig{
+		Name:  "my app",
+		Ratio: 0.5,
+		Servers: []testServer{
+			{Host: "example.com", Port: 443, Timeout: 90 * time.Second, Aliases: []string{"www.example.com", "{cdn}"}},
+			{Host: "localhost", Port: 8080},
+		},
+	}
+
+	data, err := confetti.Marshal(c)
+	if err != nil {
+		t.Fatalf("Failed to marshal configuration: %v", err)
+	}
+
+	const expected = `name "my app"
+ratio 0.5
+server {
+    host example.com
+    listen 443
+    timeout 1m30s
+    debug false
+    alias www.example.com "{cdn}"
+}
+server {
+    host localhost
+    listen 8080
+    timeout 0s
+    debug false
+    alias
+}
+extra
+`
+	if string(data) != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, data)
+	}
+
+	var back testConfig
+	if err = confetti.Unmarshal(data, &back); err != nil {
+		t.Fatalf("Failed to unmarshal configuration: %v", err)
+	} else if back.Name != c.Name || back.Servers[0].Aliases[1] != "{cdn}" {
+		t.Fatalf("Round trip mismatch: %+v", back)
+	}
+}
+
+type testLevel int
+
+func (l *testLevel) UnmarshalText(text []byte) error {
+	i := slices.Index([]string{"debug", "info", "warn"}, string(text))
+	if i < 0 {
+		return fmt.Errorf("unknown level %q", text)
+	}
+	*l = testLevel(i)
+	return nil
+}
+
+func (l testLevel) MarshalText() ([]byte, error) {
+	return []byte([]string{"debug", "info", "warn"}[l]), nil
+}
+
+type testText struct {
+	Addr    netip.Addr   `confetti:"addr"`
+	Allow   []netip.Addr `confetti:"allow"`
+	URL     url.URL      `confetti:"url"`
+	Proxy   *url.URL     `confetti:"proxy"`
+	Level   testLevel    `confetti:"level"`
+	Created time.Time    `confetti:"created"`
+}
+
+func TestUnmarshalText(t *testing.T) {
+	src := `addr 192.0.2.1
+allow 10.0.0.1 ::1
+url https://example.com/a?b=c
+proxy http://proxy:3128
+level warn
+created 2024-05-06T07:08:09Z
+`
+	var c testText
+	if err := confetti.Unmarshal([]byte(src), &c); err != nil {
+		t.Fatalf("Failed to unmarshal configuration: %v", err)
+	}
+	if c.Addr != netip.MustParseAddr("192.0.2.1") || len(c.Allow) != 2 || c.Allow[1] != netip.IPv6Loopback() {
+		t.Fatalf("Unexpected addresses: %v %v", c.Addr, c.Allow)
+	} else if c.URL.Host != "example.com" || c.URL.RawQuery != "b=c" || c.Proxy == nil || c.Proxy.Port() != "3128" {
+		t.Fatalf("Unexpected URLs: %v %v", c.URL, c.Proxy)
+	} else if c.Level != 2 || c.Created.Year() != 2024 {
+		t.Fatalf("Unexpected level or time: %v %v", c.Level, c.Created)
+	}
+
+	data, err := confetti.Marshal(c)
+	if err != nil {
+		t.Fatalf("Failed to marshal configuration: %v", err)
+	} else if string(data) != src {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", src, data)
+	}
+
+	var derr *confetti.DecodeError
+	if err = confetti.Unmarshal([]byte("level loud\n"), &c); !errors.As(err, &derr) || derr.Error() != `1:1: level: unknown level "loud"` {
+		t.Fatalf("Expected DecodeError for level, got %v", err)
+	}
+}
+
+type testDefaults struct {
+	Port   int      `confetti:"port,default=8080"`
+	Hosts  []string `confetti:"hosts,default=a \"b, c\""`
+	Listen string   `confetti:",required"`
+	TLS    struct {
+		Cert string `confetti:"cert,required"`
+		Min  string `confetti:"min,default=1.2"`
+	} `confetti:"tls"`
+	Backends []struct {
+		Addr string `confetti:"addr,required"`
+	} `confetti:"backend"`
+}
+
+func TestUnmarshalDefaults(t *testing.T) {
+	var c testDefaults
+	if err := confetti.Unmarshal([]byte("listen :80\nbackend { addr x }\n"), &c); err != nil {
+		t.Fatalf("Failed to unmarshal configuration: %v", err)
+	} else if c.Port != 8080 || !slices.Equal(c.Hosts, []string{"a", "b, c"}) || c.Listen != ":80" || c.TLS.Min != "1.2" {
+		t.Fatalf("Unexpected configuration: %+v", c)
+	}
+
+	c = testDefaults{}
+	err := confetti.NewDecoder(strings.NewReader("port 1\nbackend {\n}\nbackend { addr y }\ntls {\n}\n"), confetti.WithName("app.conf")).Decode(&c)
+	const expected = `app.conf:2:1: backend.addr: missing required directive
+app.conf:5:1: tls.cert: missing required directive
+app.conf: listen: missing required directive`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Expected errors\n%s\ngot\n%v", expected, err)
+	} else if c.Port != 1 {
+		t.Fatalf("Expected port to keep its value, got %d", c.Port)
+	}
+
+	var bad struct {
+		Port int `confetti:"port,default=eighty"`
+	}
+	if err = confetti.Unmarshal(nil, &bad); err == nil {
+		t.Fatal("Expected an error for an invalid default")
+	}
+}
+
+func TestDuplicatePolicy(t *testing.T) {
+	const src = "name a\nextra x\nname b\nextra y z\n"
+	type config struct {
+		Name  string
+		Extra any
+	}
+
+	for policy, want := range map[confetti.DuplicatePolicy]string{
+		confetti.DuplicateLast:   "b [y z]",
+		confetti.DuplicateFirst:  "a x",
+		confetti.DuplicateError:  "3:1: name: repeated directive, first at 1:1",
+		confetti.DuplicateAppend: "3:1: name: repeated directive, first at 1:1",
+	} {
+		var c config
+		dec := confetti.NewDecoder(strings.NewReader(src))
+		dec.SetDuplicatePolicy(policy)
+		var got string
+		if err := dec.Decode(&c); err != nil {
+			got = err.Error()
+		} else {
+			got = fmt.Sprintf("%s %v", c.Name, c.Extra)
+		}
+		if got != want {
+			t.Fatalf("Policy %d: expected %q, got %q", policy, want, got)
+		}
+	}
+
+	var c struct{ Extra any }
+	dec := confetti.NewDecoder(strings.NewReader(src))
+	dec.SetDuplicatePolicy(confetti.DuplicateAppend)
+	if err := dec.Decode(&c); err != nil {
+		t.Fatalf("Failed to decode configuration: %v", err)
+	} else if fmt.Sprint(c.Extra) != "[x [y z]]" {
+		t.Fatalf("Expected appended values, got %v", c.Extra)
+	}
+}
+
+type testUpstream struct {
+	Servers []string `confetti:"server"`
+	Weight  int      `confetti:"weight"`
+}
+
+type testProxy struct {
+	Upstreams map[string]testUpstream `confetti:"upstream,key"`
+	Ports     map[int]string          `confetti:"port,key"`
+}
+
+func TestUnmarshalKeyed(t *testing.T) {
+	const src = `upstream app1 {
+    server 10.0.0.1 10.0.0.2
+    weight 1
+}
+upstream app2 {
+    server 10.0.0.3
+    weight 2
+}
+port 443 https
+port 80 http
+`
+	var c testProxy
+	if err := confetti.Unmarshal([]byte(src), &c); err != nil {
+		t.Fatalf("Failed to unmarshal configuration: %v", err)
+	} else if len(c.Upstreams) != 2 || c.Upstreams["app2"].Weight != 2 || len(c.Upstreams["app1"].Servers) != 2 {
+		t.Fatalf("Unexpected upstreams: %+v", c.Upstreams)
+	} else if c.Ports[443] != "https" {
+		t.Fatalf("Unexpected ports: %v", c.Ports)
+	}
+
+	data, err := confetti.Marshal(c)
+	if err != nil {
+		t.Fatalf("Failed to marshal configuration: %v", err)
+	} else if string(data) != src {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", src, data)
+	}
+
+	dec := confetti.NewDecoder(strings.NewReader("upstream a { weight 1 }\nupstream a { weight 2 }\n"))
+	dec.SetDuplicatePolicy(confetti.DuplicateError)
+	if err = dec.Decode(&c); err == nil || err.Error() != `2:1: upstream: repeated key "a", first at 1:1` {
+		t.Fatalf("Expected a repeated key error, got %v", err)
+	} else if err = confetti.Unmarshal([]byte("upstream { weight 1 }\n"), &c); err == nil {
+		t.Fatal("Expected an error for a missing key")
+	}
+}
+
+type testSize int64
+
+type testSchedule struct {
+	Hour, Minute int
+}
+
+func TestDecodeHook(t *testing.T) {
+	hook := func(arg string, target reflect.Type) (any, error) {
+		switch target {
+		case reflect.TypeFor[testSize]():
+			n, err := strconv.ParseInt(strings.TrimSuffix(arg, "k"), 10, 64)
+			if strings.HasSuffix(arg, "k") {
+				n *= 1024
+			}
+			return testSize(n), err
+		case reflect.TypeFor[testSchedule]():
+			var s testSchedule
+			_, err := fmt.Sscanf(arg, "%d:%d", &s.Hour, &s.Minute)
+			return s, err
+		case reflect.TypeFor[int]():
+			if arg == "high" {
+				return "10", nil
+			}
+		}
+		return nil, nil
+	}
+
+	var c struct {
+		Buffers  []testSize   `confetti:"buffers"`
+		Backup   testSchedule `confetti:"backup"`
+		Priority int          `confetti:"priority"`
+		Retries  int          `confetti:"retries"`
+	}
+	dec := confetti.NewDecoder(strings.NewReader("buffers 4k 512\nbackup 3:30\npriority high\nretries 3\n"))
+	dec.SetDecodeHook(hook)
+	if err := dec.Decode(&c); err != nil {
+		t.Fatalf("Failed to decode configuration: %v", err)
+	} else if got := fmt.Sprint(c.Buffers, c.Backup, c.Priority, c.Retries); got != "[4096 512] {3 30} 10 3" {
+		t.Fatalf("Unexpected values: %s", got)
+	}
+
+	dec = confetti.NewDecoder(strings.NewReader("a 1\nbuffers 4m\n"))
+	dec.SetDecodeHook(hook)
+	var de *confetti.DecodeError
+	if err := dec.Decode(&c); !errors.As(err, &de) || de.Pos.Line != 2 || !errors.Is(err, strconv.ErrSyntax) {
+		t.Fatalf("Expected a syntax error at line 2, got %v", err)
+	}
+}
+
+type testNetworks []netip.Prefix
+
+func (n *testNetworks) UnmarshalConfettiArgument(arg string) error {
+	*n = nil
+	for s := range strings.SplitSeq(arg, ",") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return err
+		}
+		*n = append(*n, p)
+	}
+	return nil
+}
+
+// testFlag implements both interfaces, to check that UnmarshalConfettiArgument takes precedence.
+type testFlag bool
+
+func (f *testFlag) UnmarshalConfettiArgument(arg string) error {
+	*f = arg == "enabled"
+	return nil
+}
+
+func (f *testFlag) UnmarshalText(text []byte) error {
+	return errors.New("UnmarshalText called")
+}
+
+func TestArgumentUnmarshaler(t *testing.T) {
+	var c struct {
+		Allow   testNetworks  `confetti:"allow"`
+		Feature testFlag      `confetti:"feature"`
+		Flags   []testFlag    `confetti:"flags"`
+		Deny    *testNetworks `confetti:"deny"`
+	}
+	const src = "allow 10.0.0.0/8,192.168.0.0/16\nfeature enabled\nflags enabled disabled\ndeny 127.0.0.1/32\n"
+	if err := confetti.Unmarshal([]byte(src), &c); err != nil {
+		t.Fatalf("Failed to unmarshal configuration: %v", err)
+	} else if got := fmt.Sprint(c.Allow, c.Feature, c.Flags, *c.Deny); got != "[10.0.0.0/8 192.168.0.0/16] true [true false] [127.0.0.1/32]" {
+		t.Fatalf("Unexpected values: %s", got)
+	}
+
+	var de *confetti.DecodeError
+	if err := confetti.Unmarshal([]byte("allow 10.0.0.0/33\n"), &c); !errors.As(err, &de) || de.Path[0] != "allow" {
+		t.Fatalf("Expected a decode error for allow, got %v", err)
+	}
+}
+
+type testListen struct {
+	Host string
+	Port int
+}
+
+type testTagged struct {
+	Listen  testListen `confetti:"listen,inline,comment=address to listen on"`
+	Port    int        `confetti:"port,default=8080,comment=HTTP port, for plain connections"`
+	TLS     *testTLS   `confetti:"tls,omitempty"`
+	Aliases []string   `confetti:"alias,omitempty"`
+	Debug   bool       `confetti:"debug,omitempty"`
+}
+
+type testTLS struct {
+	Cert string `confetti:"cert"`
+}
+
+func TestTagFormatting(t *testing.T) {
+	c := testTagged{Listen: testListen{"localhost", 443}, Port: 80}
+	data, err := confetti.Marshal(c)
+	if err != nil {
+		t.Fatalf("Failed to marshal configuration: %v", err)
+	}
+
+	const expected = "# address to listen on\nlisten localhost 443\n# HTTP port, for plain connections\nport 80\n"
+	if string(data) != expected {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", expected, data)
+	}
+
+	var back testTagged
+	if err := confetti.Unmarshal([]byte("listen example.com\ntls {\n    cert a.pem\n}\n"), &back); err != nil {
+		t.Fatalf("Failed to unmarshal configuration: %v", err)
+	} else if back.Listen.Host != "example.com" || back.Port != 8080 || back.TLS.Cert != "a.pem" {
+		t.Fatalf("Unexpected values: %+v", back)
+	}
+
+	if err := confetti.Unmarshal([]byte("listen example.com 443 extra\n"), &back); err == nil {
+		t.Fatal("Expected an error for too many inline arguments")
+	}
+}
+
+type testBase struct {
+	Name string `confetti:"name"`
+	Port int    `confetti:"port"`
+}
+
+// Limits is exported so that a pointer to it can be embedded and allocated.
+type Limits struct {
+	Rate int `confetti:"rate"`
+}
+
+type testEmbedding struct {
+	testBase
+	*Limits
+	Port  string   `confetti:"port"` // hides testBase.Port
+	TLS   *testTLS `confetti:"tls"`
+	Extra testBase `confetti:"extra"`
+}
+
+func TestEmbedded(t *testing.T) {
+	const src = "name app\nport http\nrate 10\nextra {\n    port 80\n}\n"
+	var c testEmbedding
+	if err := confetti.Unmarshal([]byte(src), &c); err != nil {
+		t.Fatalf("Failed to unmarshal configuration: %v", err)
+	} else if c.Name != "app" || c.Port != "http" || c.testBase.Port != 0 || c.Limits == nil || c.Rate != 10 || c.TLS != nil || c.Extra.Port != 80 {
+		t.Fatalf("Unexpected values: %+v", c)
+	}
+
+	data, err := confetti.Marshal(c)
+	if err != nil {
+		t.Fatalf("Failed to marshal configuration: %v", err)
+	} else if expected := "name app\nrate 10\nport http\nextra {\n    name \"\"\n    port 80\n}\n"; string(data) != expected {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", expected, data)
+	}
+
+	c = testEmbedding{}
+	if err := confetti.Unmarshal([]byte("name app\n"), &c); err != nil {
+		t.Fatalf("Failed to unmarshal configuration: %v", err)
+	} else if c.Limits != nil {
+		t.Fatal("Expected the embedded pointer to stay nil")
+	}
+}
+
+func TestDirectiveDecode(t *testing.T) {
+	doc, err := confetti.Load("name app\ndatabase {\n    host db.local\n    listen 5432\n}\ncache {\n    listen many\n}\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	db, _ := doc.Get("database")
+	var s testServer
+	if err := db.Decode(&s); err != nil {
+		t.Fatalf("Failed to decode database: %v", err)
+	} else if s.Host != "db.local" || s.Port != 5432 {
+		t.Fatalf("Unexpected values: %+v", s)
+	}
+
+	cache, _ := doc.Get("cache")
+	var de *confetti.DecodeError
+	if err := cache.Decode(&s); !errors.As(err, &de) || strings.Join(de.Path, ".") != "cache.listen" || de.Pos.Line != 7 {
+		t.Fatalf("Expected an error at cache.listen, got %v", err)
+	}
+}
+
+func TestDisallowUnknownDirectives(t *testing.T) {
+	const src = "name example\nserver {\n    host localhost\n    lissten 8080\n}\n"
+	var c testConfig
+	if err := confetti.Unmarshal([]byte(src), &c); err != nil {
+		t.Fatalf("Failed to unmarshal configuration: %v", err)
+	}
+
+	dec := confetti.NewDecoder(strings.NewReader(src))
+	dec.DisallowUnknownDirectives()
+	if err := dec.Decode(&c); err == nil || err.Error() != "4:5: server.lissten: unknown directive" {
+		t.Fatalf("Expected an unknown directive error, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package confetti
+
+import (
+	"io"
+	"strings"
+)
+
+// A Decoder reads and parses a Confetti document from an input stream.
+type Decoder struct {
+	r      io.Reader
+	opts   []Option
+	dups   DuplicatePolicy
+	hook   DecodeHook
+	strict bool
+	done   bool
+}
+
+// NewDecoder returns a decoder that reads from r, parsing with the given options.
+func NewDecoder(r io.Reader, opts ...Option) *Decoder {
+	return &Decoder{r: r, opts: opts}
+}
+
+// SetDuplicatePolicy sets what Decode does when more than one directive matches a field taking a single value. The default is DuplicateLast.
+func (dec *Decoder) SetDuplicatePolicy(p DuplicatePolicy) {
+	dec.dups = p
+}
+
+// SetDecodeHook sets a hook converting arguments as Decode stores them. See DecodeHook.
+func (dec *Decoder) SetDecodeHook(h DecodeHook) {
+	dec.hook = h
+}
+
+// DisallowUnknownDirectives makes Decode fail with a *DecodeError at the first directive that matches no field of the struct it is decoded into, catching misspelt names.
+func (dec *Decoder) DisallowUnknownDirectives() {
+	dec.strict = true
+}
+
+// Decode reads the rest of the input and stores the result in v. If v is a *Document, it receives the parsed document; otherwise the document is decoded into v as described by Unmarshal.
+// Directives are decoded as they are read, so that a struct is decoded without holding the source in memory, unless the options are ones that ParseEvents cannot stream with, such as WithIncludes, or WithFileSet, which keeps the source, when the whole input is read first. A *Document holds its source, and all of its directives, either way. Decoding may fail having stored the directives read before the error.
+// Once the input has been decoded, further calls return io.EOF.
+func (dec *Decoder) Decode(v any) error {
+	if dec.done {
+		return io.EOF
+	}
+
+	c := newConfig(dec.opts)
+	if c.err != nil || c.streams() != nil || c.fset != nil {
+		data, err := io.ReadAll(dec.r)
+		if err != nil {
+			return err
+		}
+		dec.done = true
+
+		parsed, err := Parse(string(data), dec.opts...)
+		if err != nil {
+			return err
+		}
+		if doc, ok := v.(*Document); ok {
+			*doc = parsed
+			return nil
+		}
+		s := decodeState{dups: dec.dups, hook: dec.hook, strict: dec.strict}
+		return s.decodeInto(parsed.Directives, v, nil, Position{Filename: parsed.Name})
+	}
+	dec.done = true
+
+	p := NewParser(dec.r, dec.opts...)
+	if doc, ok := v.(*Document); ok {
+		parsed, err := readDocument(p)
+		if err != nil {
+			return err
+		}
+		*doc = parsed
+		return nil
+	}
+
+	s := decodeState{dups: dec.dups, hook: dec.hook, strict: dec.strict}
+	sd, err := s.structInto(v, nil, Position{Filename: c.name})
+	if err != nil {
+		return err
+	}
+	for {
+		d, err := p.Next()
+		if err == io.EOF {
+			return sd.finishInto()
+		} else if err != nil {
+			return err
+		} else if err = sd.directive(*d); err != nil {
+			return err
+		}
+	}
+}
+
+// readDocument reads the source of p into a document, as Parse would parse it.
+func readDocument(p *Parser) (Document, error) {
+	if p.err != nil {
+		return Document{}, p.err
+	}
+	c := p.er.p.c
+	p.keep = true
+	if bom := p.er.bom; bom.Text != "" {
+		bom.Pos.Filename, bom.End.Filename = c.name, c.name
+		p.kept = append(p.kept, bom)
+	}
+
+	var ds []Directive
+	for {
+		d, err := p.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return Document{}, err
+		}
+		ds = append(ds, *d)
+	}
+
+	var src strings.Builder
+	for _, t := range p.kept {
+		src.WriteString(t.Text)
+	}
+
+	doc := newDocument(p.kept, ds, c.exts)
+	doc.Name = c.name
+	if c.warnings {
+		doc.Diagnostics = warnings(p.kept, c.translate)
+	}
+	doc.src, doc.conf = src.String(), &c
+	return doc, nil
+}
@@ -0,0 +1,71 @@
+package confetti
+
+import "slices"
+
+// DedupePolicy selects which of a set of identical sibling directives Dedupe keeps.
+type DedupePolicy uint8
+
+const (
+	// KeepFirst keeps the earliest of each set of duplicates.
+	KeepFirst DedupePolicy = iota
+	// KeepLast keeps the latest of each set of duplicates.
+	KeepLast
+)
+
+// Duplicate describes a directive removed by Dedupe.
+type Duplicate struct {
+	// Path is the index path of the removed directive in the original tree, so [2 0] is the first subdirective of the third top-level directive.
+	Path []int
+	// KeptPath is the index path of the retained directive it duplicated.
+	KeptPath  []int
+	Directive Directive
+}
+
+// Dedupe removes sibling directives that have the same arguments and equal subtrees, at every level of the tree. Subdirectives are deduplicated before their parents are compared.
+// The input is not modified.
+func Dedupe(p []Directive, policy DedupePolicy) (out []Directive, dropped []Duplicate) {
+	return dedupe(p, policy, nil, &dropped), dropped
+}
+
+func dedupe(p []Directive, policy DedupePolicy, path []int, dropped *[]Duplicate) []Directive {
+	if p == nil {
+		return nil
+	}
+
+	ds := make([]Directive, len(p))
+	for i, d := range p {
+		d.Subdirectives = dedupe(d.Subdirectives, policy, append(slices.Clip(path), i), dropped)
+		ds[i] = d
+	}
+
+	order := make([]int, len(ds))
+	for i := range order {
+		order[i] = i
+	}
+	if policy == KeepLast {
+		slices.Reverse(order)
+	}
+
+	var kept []int
+outer:
+	for _, i := range order {
+		for _, k := range kept {
+			if ds[i].Equals(ds[k]) {
+				*dropped = append(*dropped, Duplicate{
+					Path:      append(slices.Clip(path), i),
+					KeptPath:  append(slices.Clip(path), k),
+					Directive: p[i],
+				})
+				continue outer
+			}
+		}
+		kept = append(kept, i)
+	}
+
+	slices.Sort(kept)
+	out := make([]Directive, len(kept))
+	for j, k := range kept {
+		out[j] = ds[k]
+	}
+	return out
+}
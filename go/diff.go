@@ -0,0 +1,176 @@
+package confetti
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ChangeKind is what a Change does to a directive.
+type ChangeKind uint8
+
+const (
+	ChangeAdded    ChangeKind = iota // the directive is only in the new document
+	ChangeRemoved                    // the directive is only in the old document
+	ChangeModified                   // the arguments of the directive differ
+)
+
+var changeKinds = [...]string{
+	ChangeAdded:    "added",
+	ChangeRemoved:  "removed",
+	ChangeModified: "modified",
+}
+
+func (k ChangeKind) String() string {
+	if int(k) < len(changeKinds) {
+		return changeKinds[k]
+	}
+	return fmt.Sprintf("ChangeKind(%d)", k)
+}
+
+// Change is a difference between two documents found by Diff.
+type Change struct {
+	Kind ChangeKind
+	// Path is the names of the directive and its parents.
+	Path []string
+	// Old and New are the directive in each document, or the zero Directive in the one it is absent from.
+	Old, New Directive
+}
+
+func (c Change) String() string {
+	return strings.Join(c.Path, ".") + ": " + c.Kind.String()
+}
+
+// Diff reports how the directives of b differ from those of a, ignoring comments and formatting, in the order of b with removed directives where they were.
+// Each directive of b is matched with one of the same name in a, preferring one with the same arguments. Unmatched directives are added or removed with their subdirectives, and matched ones are modified if their arguments differ, with their subdirectives compared in turn.
+func Diff(a, b Document) []Change {
+	return diffDirectives(a.Directives, b.Directives, nil)
+}
+
+func diffDirectives(a, b []Directive, path []string) (cs []Change) {
+	pair := pairDirectives(a, b)
+	matched := make([]bool, len(a))
+	for _, i := range pair {
+		if i >= 0 {
+			matched[i] = true
+		}
+	}
+
+	next := 0 // the first directive of a not yet reported
+	removed := func(to int) {
+		for ; next < to; next++ {
+			if !matched[next] {
+				d := a[next]
+				cs = append(cs, Change{ChangeRemoved, append(path[:len(path):len(path)], d.Name()), d, Directive{}})
+			}
+		}
+	}
+
+	for j, d := range b {
+		dpath := append(path[:len(path):len(path)], d.Name())
+		i := pair[j]
+		if i < 0 {
+			cs = append(cs, Change{ChangeAdded, dpath, Directive{}, d})
+			continue
+		}
+
+		if i >= next {
+			removed(i)
+			next = i + 1
+		}
+		if !slices.Equal(a[i].Arguments, d.Arguments) {
+			cs = append(cs, Change{ChangeModified, dpath, a[i], d})
+		}
+		cs = append(cs, diffDirectives(a[i].Subdirectives, d.Subdirectives, dpath)...)
+	}
+	removed(len(a))
+	return
+}
+
+// pairDirectives matches each directive of b with one of the same name in a, preferring one with the same arguments and otherwise the first left, returning the index in a of each, or -1 if there is none.
+func pairDirectives(a, b []Directive) []int {
+	named := map[string][]int{}
+	for i, d := range a {
+		named[d.Name()] = append(named[d.Name()], i)
+	}
+
+	pair := make([]int, len(b))
+	used := make([]bool, len(a))
+	for j, d := range b {
+		pair[j] = -1
+		for _, i := range named[d.Name()] {
+			if !used[i] && slices.Equal(a[i].Arguments, d.Arguments) {
+				pair[j], used[i] = i, true
+				break
+			}
+		}
+	}
+	for j, d := range b {
+		if pair[j] >= 0 {
+			continue
+		}
+		for _, i := range named[d.Name()] {
+			if !used[i] {
+				pair[j], used[i] = i, true
+				break
+			}
+		}
+	}
+	return pair
+}
+
+// UnifiedDiff returns the changes Diff finds between a and b in the style of a unified diff, headed by the names of the documents. Each change is a hunk headed by its path, with the lines of the directive removed prefixed by "-" and those added by "+". A modified directive shows only its arguments, as the changes to its subdirectives follow it.
+func UnifiedDiff(a, b Document) (string, error) {
+	aName, bName := a.Name, b.Name
+	if aName == "" {
+		aName = "a"
+	}
+	if bName == "" {
+		bName = "b"
+	}
+
+	var out strings.Builder
+	out.WriteString("--- " + aName + "\n+++ " + bName + "\n")
+	for _, c := range Diff(a, b) {
+		out.WriteString("@@ " + strings.Join(c.Path, ".") + " @@\n")
+		if c.Kind != ChangeAdded {
+			if err := writeChanged(&out, "-", a.Extensions, c.Old, c.Kind == ChangeModified); err != nil {
+				return "", err
+			}
+		}
+		if c.Kind != ChangeRemoved {
+			if err := writeChanged(&out, "+", b.Extensions, c.New, c.Kind == ChangeModified); err != nil {
+				return "", err
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+// writeChanged writes d, quoted for a source with the extensions exts, without its comments, and without its subdirectives if head is true, each line starting with prefix.
+func writeChanged(out *strings.Builder, prefix string, exts Extensions, d Directive, head bool) error {
+	d = uncommented(d)
+	if head {
+		d.Subdirectives = nil
+	}
+
+	var b strings.Builder
+	if err := writeDirectives(&b, []Directive{d}, FormatOptions{}.withExtensions(exts), ""); err != nil {
+		return err
+	}
+	for line := range strings.Lines(b.String()) {
+		out.WriteString(prefix + line)
+	}
+	return nil
+}
+
+// uncommented returns a copy of d and its subdirectives without their comments.
+func uncommented(d Directive) Directive {
+	d.LeadingComments, d.TrailingComment = nil, ""
+	subs := d.Subdirectives
+	d.Subdirectives = nil
+	for _, s := range subs {
+		d.Subdirectives = append(d.Subdirectives, uncommented(s))
+	}
+	return d
+}
@@ -0,0 +1,189 @@
+package confetti
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Severity is the importance of a diagnostic.
+type Severity uint8
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+var severities = [...]string{
+	SeverityError:   "error",
+	SeverityWarning: "warning",
+	SeverityInfo:    "info",
+}
+
+func (s Severity) String() string {
+	if int(s) < len(severities) {
+		return severities[s]
+	}
+	return fmt.Sprintf("Severity(%d)", s)
+}
+
+// Diagnostic is a message about the source reported while parsing it.
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	// Pos is the position of the problem, and End, if set, is just after it.
+	Pos, End Position
+	// Code is the code of the problem, for those with one.
+	Code ErrorCode
+}
+
+// TriviaKind is the kind of a piece of trivia.
+type TriviaKind uint8
+
+const (
+	TriviaWhitespace TriviaKind = iota
+	TriviaNewline
+	TriviaComment
+	TriviaContinuation
+)
+
+// Trivia is source text that carries no meaning for the directive tree.
+type Trivia struct {
+	Kind TriviaKind
+	// Text is the trivia as written in the source, including comment delimiters.
+	Text string
+	Pos  Position
+}
+
+// Document is a parsed Confetti source.
+type Document struct {
+	// Name identifies the source, such as its file path.
+	Name       string
+	Directives []Directive
+	// BOM and CtrlZ report whether the source started with a byte order mark or ended with a ^Z.
+	BOM, CtrlZ bool
+	// Extensions are the extensions the source was parsed with.
+	Extensions Extensions
+	// Diagnostics are the problems reported in ModeLenient and WithWarnings.
+	Diagnostics []Diagnostic
+	// Trivia holds the whitespace, line terminators, comments, and line continuations of the source, in order.
+	Trivia []Trivia
+
+	lossless bool
+	tail     string  // source text after the last directive, in lossless mode
+	src      string  // source the document was parsed from, for Reparse
+	conf     *config // options it was parsed with, or nil if it was not parsed
+}
+
+func newDocument(ts []Token, p []Directive, exts Extensions) Document {
+	doc := Document{Directives: p, Extensions: exts}
+
+	for i, t := range ts {
+		switch t.Kind {
+		case TokenUnicode:
+			if i == 0 && t.Value != "\u001a" {
+				doc.BOM = true
+			} else {
+				doc.CtrlZ = true
+			}
+		case TokenWhitespace:
+			doc.Trivia = append(doc.Trivia, Trivia{TriviaWhitespace, t.Text, t.Pos})
+		case TokenNewline:
+			doc.Trivia = append(doc.Trivia, Trivia{TriviaNewline, t.Text, t.Pos})
+		case TokenComment:
+			doc.Trivia = append(doc.Trivia, Trivia{TriviaComment, t.Text, t.Pos})
+		case TokenContinuation:
+			doc.Trivia = append(doc.Trivia, Trivia{TriviaContinuation, t.Text, t.Pos})
+		}
+	}
+
+	return doc
+}
+
+// warnings returns the warnings WithWarnings reports for a source with the tokens ts, with messages translated by t.
+func warnings(ts []Token, t Translator) (diags []Diagnostic) {
+	warn := func(tok Token, err error, detail string) {
+		code := CodeOf(err)
+		msg := t.translate(code, detail, "")
+		if msg == "" {
+			msg = strings.TrimSpace(err.Error() + " " + detail)
+		}
+		diags = append(diags, Diagnostic{Severity: SeverityWarning, Message: msg, Pos: tok.Pos, End: tok.End, Code: code})
+	}
+
+	var newline, indent string // the first line terminator and indentation character
+	mixed, tabbed := false, false
+	lineStart := true
+	for i, tok := range ts {
+		switch tok.Kind {
+		case TokenUnicode:
+			if i == 0 && tok.Value != "\u001a" {
+				warn(tok, ErrByteOrderMark, "")
+			} else {
+				warn(tok, ErrCtrlZ, "")
+			}
+			continue
+		case TokenNewline:
+			if newline == "" {
+				newline = tok.Text
+			} else if tok.Text != newline && !mixed {
+				warn(tok, ErrMixedLineTerminators, fmt.Sprintf("%q and %q", newline, tok.Text))
+				mixed = true
+			}
+		case TokenWhitespace:
+			if !lineStart || tok.Text != "\t" && tok.Text != " " {
+				break
+			} else if indent == "" {
+				indent = tok.Text
+			} else if tok.Text != indent && !tabbed {
+				if indent == " " {
+					warn(tok, ErrTabsAfterSpaces, "")
+				} else {
+					warn(tok, ErrSpacesAfterTabs, "")
+				}
+				tabbed = true
+			}
+			continue
+		}
+		lineStart = tok.Kind == TokenNewline
+	}
+	return
+}
+
+func cloneDirectives(p []Directive) []Directive {
+	if p == nil {
+		return nil
+	}
+
+	cp := make([]Directive, len(p))
+	for i, d := range p {
+		cp[i] = d.Clone()
+	}
+	return cp
+}
+
+// CloneDocument returns a deep copy of a document, sharing no memory with the original.
+func CloneDocument(doc Document) Document {
+	cp := doc
+	cp.Directives = cloneDirectives(doc.Directives)
+	cp.Extensions = maps.Clone(doc.Extensions)
+	if doc.Diagnostics != nil {
+		cp.Diagnostics = append([]Diagnostic(nil), doc.Diagnostics...)
+	}
+	if doc.Trivia != nil {
+		cp.Trivia = append([]Trivia(nil), doc.Trivia...)
+	}
+	return cp
+}
+
+// Clone returns a deep copy of the document, sharing no memory with the original, as CloneDocument does.
+func (doc Document) Clone() Document {
+	return CloneDocument(doc)
+}
+
+// Equals reports whether doc and other have equal directives, as Directive.Equals compares them, ignoring how and from where they were parsed.
+func (doc Document) Equals(other Document) bool {
+	return slices.EqualFunc(doc.Directives, other.Directives, Directive.Equals)
+}
@@ -0,0 +1,50 @@
+package confetti
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// dotLabel returns the arguments of d as they would be written in a source with the extensions exts, escaped for a GraphViz label.
+func dotLabel(d Directive, exts Extensions) string {
+	args := make([]string, len(d.Arguments))
+	for i, a := range d.Arguments {
+		q, err := quoteArgument(a, exts)
+		if err != nil {
+			q = strconv.Quote(a)
+		}
+		args[i] = q
+	}
+	return dotEscaper.Replace(strings.Join(args, " "))
+}
+
+// ToDOT writes the directive tree of doc to w as a GraphViz graph, with a node for the document, named by its Name, and one for each directive, labelled with its arguments and linked from its parent.
+func (doc Document) ToDOT(w io.Writer) error {
+	name := doc.Name
+	if name == "" {
+		name = "document"
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph confetti {\n\tnode [shape=box];\n")
+	fmt.Fprintf(&b, "\tn0 [label=\"%s\", shape=ellipse];\n", dotEscaper.Replace(name))
+	n := 0
+	var write func(p []Directive, parent int)
+	write = func(p []Directive, parent int) {
+		for _, d := range p {
+			n++
+			id := n
+			fmt.Fprintf(&b, "\tn%d [label=\"%s\"];\n\tn%d -> n%d;\n", id, dotLabel(d, doc.Extensions), parent, id)
+			write(d.Subdirectives, id)
+		}
+	}
+	write(doc.Directives, 0)
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,48 @@
+package confetti
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DumpOptions selects what Dump shows besides the arguments of each directive.
+type DumpOptions struct {
+	// ShowQuoting shows how each argument was written: unquoted, quoted, or triple quoted.
+	ShowQuoting bool
+	// ShowPositions shows where each directive starts and ends in the source.
+	ShowPositions bool
+}
+
+var quotings = [...]string{
+	QuotingNone:   "unquoted",
+	QuotingSingle: "quoted",
+	QuotingTriple: "triple quoted",
+}
+
+// Dump writes the directives of doc to w as an indented tree, each argument quoted as a Go string on a line of its own, for debugging.
+func Dump(w io.Writer, doc Document, opts DumpOptions) error {
+	var b strings.Builder
+	dumpDirectives(&b, doc.Directives, opts, "")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dumpDirectives(b *strings.Builder, p []Directive, opts DumpOptions, prefix string) {
+	for _, d := range p {
+		b.WriteString(prefix + "Directive")
+		if opts.ShowPositions && d.Pos.IsValid() {
+			fmt.Fprintf(b, " %s-%d:%d", d.Pos, d.End.Line, d.End.Column)
+		}
+		b.WriteString(":\n")
+
+		for i, a := range d.Arguments {
+			fmt.Fprintf(b, "%s  %q", prefix, a)
+			if opts.ShowQuoting && i < len(d.ArgumentSources) && int(d.ArgumentSources[i].Quoting) < len(quotings) {
+				b.WriteString(" (" + quotings[d.ArgumentSources[i].Quoting] + ")")
+			}
+			b.WriteByte('\n')
+		}
+		dumpDirectives(b, d.Subdirectives, opts, prefix+"  ")
+	}
+}
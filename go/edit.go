@@ -0,0 +1,337 @@
+package confetti
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// An Editor changes directives in a source while keeping the rest of it, including comments, indentation, and quoting, exactly as written.
+// Paths given to its methods are indexes into the directives of the original source, as in Snapshot, and are not affected by earlier edits.
+type Editor struct {
+	src   string
+	ts    []Token
+	p     []Directive
+	c     config
+	edits []edit
+}
+
+// edit replaces src[start:end] with text.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// NewEditor returns an editor for src. Options other than WithMaxDepth and those enabling extensions are ignored.
+func NewEditor(src string, opts ...Option) (*Editor, error) {
+	c := newConfig(opts)
+	if c.err != nil {
+		return nil, c.err
+	}
+	ts, err := lex(src, c.exts)
+	if err != nil {
+		return nil, withSource(err, src, c.name)
+	}
+	p, err := parse(ts, c.exts, c.maxDepth)
+	if err != nil {
+		return nil, withSource(err, src, c.name)
+	}
+	return &Editor{src: src, ts: ts, p: p, c: c}, nil
+}
+
+func (e *Editor) add(start, end int, text string) error {
+	for _, o := range e.edits {
+		if max(start, o.start) < min(end, o.end) ||
+			start == end && start > o.start && start < o.end ||
+			o.start == o.end && o.start > start && o.start < end {
+			return errors.New("edit overlaps an earlier edit")
+		}
+	}
+	e.edits = append(e.edits, edit{start, end, text})
+	return nil
+}
+
+// directiveAt returns the parsed directive at path, or nil if there is none.
+func directiveAt(p []Directive, path []int) *Directive {
+	var d *Directive
+	for _, i := range path {
+		if i < 0 || i >= len(p) {
+			return nil
+		}
+		d = &p[i]
+		p = d.Subdirectives
+	}
+	return d
+}
+
+// lineIndent returns the white space at the start of the line containing src[off].
+func lineIndent(src string, off int) string {
+	start := lineStart(src, off)
+	indent, _ := cutIndent(src[start:lineEnd(src, start)])
+	return indent
+}
+
+// indentUnit guesses the string used to indent each level of src from its first indented directive.
+func (e *Editor) indentUnit() string {
+	var find func(p []Directive) string
+	find = func(p []Directive) string {
+		for _, d := range p {
+			for _, sub := range d.Subdirectives {
+				outer, inner := lineIndent(e.src, d.Pos.Offset), lineIndent(e.src, sub.Pos.Offset)
+				if len(inner) > len(outer) && strings.HasPrefix(inner, outer) {
+					return inner[len(outer):]
+				}
+			}
+			if unit := find(d.Subdirectives); unit != "" {
+				return unit
+			}
+		}
+		return ""
+	}
+
+	if unit := find(e.p); unit != "" {
+		return unit
+	}
+	return defaultIndent
+}
+
+// format returns d formatted with each line starting with prefix and ending as the lines of the source do, without a final line terminator.
+func (e *Editor) format(d Directive, prefix string) (string, error) {
+	opts := FormatOptions{Indent: e.indentUnit(), LineEnding: LineEndingPreserve, preserved: e.newline(0)}.withExtensions(e.c.exts)
+	var b strings.Builder
+	if err := writeDirectives(&b, []Directive{d}, opts, prefix); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), opts.newline()), nil
+}
+
+// SetArgument replaces argument i of the directive at path with value. The argument keeps its quoting where the value allows it.
+func (e *Editor) SetArgument(path []int, i int, value string) error {
+	first, _, ok := locate(e.ts, path)
+	if !ok {
+		return fmt.Errorf("%w %v", errPath, path)
+	} else if i < 0 {
+		return fmt.Errorf("directive at path %v has no argument %d", path, i)
+	}
+
+	n := 0
+	for _, t := range e.ts[first:] {
+		switch t.Kind {
+		case TokenSemicolon, TokenNewline, TokenOpenBrace, TokenCloseBrace:
+			return fmt.Errorf("directive at path %v has no argument %d", path, i)
+		case TokenArgument, TokenQuotedArgument, TokenTripleQuotedArgument, TokenPunctuator:
+			if n++; n <= i {
+				continue
+			}
+
+			q, err := quoteArgument(value, e.c.exts)
+			if err != nil {
+				return err
+			}
+			switch {
+			case t.Kind == TokenTripleQuotedArgument:
+				q = `"""` + quoteEscaper.Replace(value) + `"""`
+			case t.Kind == TokenQuotedArgument && !strings.ContainsFunc(value, isLineTerminator):
+				q = `"` + quoteEscaper.Replace(value) + `"`
+			}
+			return e.add(t.Pos.Offset, t.End.Offset, q)
+		}
+	}
+	return fmt.Errorf("directive at path %v has no argument %d", path, i)
+}
+
+// Delete removes the directive at path, including its block and the comment at the end of its last line. If the directive is alone on its lines, the lines are removed.
+func (e *Editor) Delete(path []int) error {
+	first, last, ok := locate(e.ts, path)
+	if !ok {
+		return fmt.Errorf("%w %v", errPath, path)
+	}
+
+	// take the white space and semicolon after the directive
+	j := last + 1
+	skip := func(types ...TokenKind) {
+		for j < len(e.ts) && slices.Contains(types, e.ts[j].Kind) {
+			j++
+		}
+	}
+	skip(TokenWhitespace)
+	if j < len(e.ts) && e.ts[j].Kind == TokenSemicolon {
+		j++
+		skip(TokenWhitespace)
+	}
+
+	start, end := e.ts[first].Pos.Offset, e.ts[j-1].End.Offset
+	endsLine := func() bool {
+		return j == len(e.ts) || e.ts[j].Kind == TokenNewline || e.ts[j].Kind == TokenUnicode
+	}
+
+	if ls := lineStart(e.src, start); strings.TrimFunc(e.src[ls:start], isWhitespace) == "" {
+		skip(TokenComment, TokenWhitespace)
+		if endsLine() {
+			// remove the whole lines
+			start, end = ls, e.ts[j-1].End.Offset
+			if j < len(e.ts) && e.ts[j].Kind == TokenNewline {
+				end = e.ts[j].End.Offset
+			}
+		}
+	} else if endsLine() || e.ts[j].Kind == TokenComment || e.ts[j].Kind == TokenCloseBrace {
+		// take the semicolon separating the directive from the one before it
+		k := first - 1
+		for k >= 0 && e.ts[k].Kind == TokenWhitespace {
+			k--
+		}
+		if k >= 0 && e.ts[k].Kind == TokenSemicolon {
+			start = e.ts[k].Pos.Offset
+			if !endsLine() {
+				// keep the space before the comment or brace
+				end = e.ts[last].End.Offset
+			}
+		}
+	}
+	return e.add(start, end, "")
+}
+
+// Insert adds d at path, before the directive currently there. The last index of path may equal the number of siblings to append d, so Insert can add the first subdirective of a directive without a block.
+func (e *Editor) Insert(path []int, d Directive) error {
+	if len(path) == 0 {
+		return fmt.Errorf("%w %v", errPath, path)
+	}
+
+	siblings := e.p
+	parentPath, idx := path[:len(path)-1], path[len(path)-1]
+	if len(parentPath) > 0 {
+		parent := directiveAt(e.p, parentPath)
+		if parent == nil {
+			return fmt.Errorf("%w %v", errPath, path)
+		}
+		siblings = parent.Subdirectives
+	}
+	if idx < 0 || idx > len(siblings) {
+		return fmt.Errorf("%w %v", errPath, path)
+	}
+
+	switch {
+	case idx < len(siblings):
+		// before the sibling, on its own line if the sibling starts one
+		first, _, _ := locate(e.ts, path)
+		off := e.ts[first].Pos.Offset
+		ls := lineStart(e.src, off)
+		if indent := e.src[ls:off]; strings.TrimFunc(indent, isWhitespace) == "" {
+			text, err := e.format(d, indent)
+			if err != nil {
+				return err
+			}
+			return e.add(ls, ls, text+e.newline(off))
+		}
+		indent := lineIndent(e.src, off)
+		text, err := e.format(d, indent)
+		if err != nil {
+			return err
+		}
+		return e.add(off, off, text[len(indent):]+"; ")
+
+	case len(siblings) > 0:
+		// after the last sibling's line
+		_, last, _ := locate(e.ts, append(slices.Clone(parentPath), idx-1))
+		off := lineEnd(e.src, e.ts[last].End.Offset)
+		indent := lineIndent(e.src, e.ts[last].Pos.Offset)
+		text, err := e.format(d, indent)
+		if err != nil {
+			return err
+		}
+		if len(parentPath) > 0 {
+			// or after the sibling itself, if the parent's block closes on that line
+			if _, end, _ := locate(e.ts, parentPath); e.ts[end].Pos.Offset < off {
+				return e.add(e.ts[last].End.Offset, e.ts[last].End.Offset, "; "+text[len(indent):])
+			}
+		}
+		return e.add(off, off, e.newline(off)+text)
+
+	case len(parentPath) > 0:
+		// the first subdirective
+		first, last, _ := locate(e.ts, parentPath)
+		indent := lineIndent(e.src, e.ts[first].Pos.Offset)
+		text, err := e.format(d, indent+e.indentUnit())
+		if err != nil {
+			return err
+		}
+
+		nl := e.newline(e.ts[last].End.Offset)
+		if e.ts[last].Kind != TokenCloseBrace {
+			// no block yet
+			off := e.ts[last].End.Offset
+			return e.add(off, off, " {"+nl+text+nl+indent+"}")
+		}
+
+		open := slices.IndexFunc(e.ts[first:last], func(t Token) bool { return t.Kind == TokenOpenBrace }) + first
+		inside := e.src[e.ts[open].End.Offset:e.ts[last].Pos.Offset]
+		if strings.TrimFunc(inside, isWhitespace) == "" {
+			// an empty block such as "{ }"
+			return e.add(e.ts[open].End.Offset, e.ts[last].Pos.Offset, nl+text+nl+indent)
+		}
+		off := e.ts[last].Pos.Offset
+		if ls := lineStart(e.src, off); strings.TrimFunc(e.src[ls:off], isWhitespace) == "" {
+			return e.add(ls, ls, text+nl)
+		}
+		return e.add(off, off, nl+text+nl+indent)
+
+	default:
+		// the first directive of the source
+		off := len(e.src)
+		if strings.HasSuffix(e.src, "\u001a") {
+			off--
+		}
+		text, err := e.format(d, "")
+		if err != nil {
+			return err
+		}
+		if ls := lineStart(e.src, off); ls == off {
+			return e.add(off, off, text+e.newline(off))
+		}
+		return e.add(off, off, e.newline(off)+text)
+	}
+}
+
+// newline returns the line terminator ending the line containing src[off], or else the first line, or "\n".
+func (e *Editor) newline(off int) string {
+	for _, i := range []int{lineEnd(e.src, off), lineEnd(e.src, 0)} {
+		if rest := e.src[i:]; strings.HasPrefix(rest, "\r\n") {
+			return "\r\n"
+		} else if rest != "" {
+			_, size := utf8.DecodeRuneInString(rest)
+			return rest[:size]
+		}
+	}
+	return "\n"
+}
+
+// Apply returns the source with the edits applied, or an error if the result is not a valid source. The editor is unchanged, so more edits may follow.
+func (e *Editor) Apply() (string, error) {
+	edits := slices.Clone(e.edits)
+	// insertions go before a replacement starting at the same offset
+	slices.SortStableFunc(edits, func(a, b edit) int {
+		return cmp.Or(a.start-b.start, a.end-b.end)
+	})
+
+	var b strings.Builder
+	at := 0
+	for _, ed := range edits {
+		b.WriteString(e.src[at:ed.start])
+		b.WriteString(ed.text)
+		at = ed.end
+	}
+	b.WriteString(e.src[at:])
+
+	out := b.String()
+	ts, err := lex(out, e.c.exts)
+	if err == nil {
+		_, err = parse(ts, e.c.exts, e.c.maxDepth)
+	}
+	if err != nil {
+		return "", fmt.Errorf("edited source is invalid: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,482 @@
+package confetti
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// needsQuotes reports whether a must be quoted or escaped to be read as a single argument in a source with the punctuators puncts.
+func needsQuotes(a string, puncts []string) bool {
+	// a leading byte order mark would be removed if the argument started the source
+	if a == "" || strings.HasPrefix(a, "//") || strings.HasPrefix(a, "/*") ||
+		strings.HasPrefix(a, "\ufeff") || strings.HasPrefix(a, "\ufffe") {
+		return true
+	}
+	for _, r := range a {
+		if !argumentOk(r, nil) || r == '\\' || r == '(' {
+			return true
+		}
+	}
+	// an argument that is a punctuator is read as one, but one containing a punctuator is split
+	if slices.Contains(puncts, a) {
+		return false
+	}
+	for i := range a {
+		if punctuatorPrefix(a[i:], puncts) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// checkArgument reports whether a contains a character that cannot be written in any argument.
+func checkArgument(a string) error {
+	for _, r := range a {
+		if isForbidden(r) {
+			if r < 0x10000 {
+				return fmt.Errorf("%w U+%04X", ErrIllegalCharacter, r)
+			}
+			return fmt.Errorf("%w U+%X", ErrIllegalCharacter, r)
+		}
+	}
+	return nil
+}
+
+// quoteArgument returns the argument as Confetti source for a source with the extensions exts, quoting it only where needed.
+func quoteArgument(a string, exts Extensions) (string, error) {
+	return FormatOptions{}.withExtensions(exts).quote(a)
+}
+
+// QuotingPolicy selects when Format and an Encoder quote arguments. Arguments spanning several lines are always triple quoted, as line terminators cannot be written otherwise.
+type QuotingPolicy uint8
+
+const (
+	// QuoteMinimal quotes only the arguments that cannot be written unquoted.
+	QuoteMinimal QuotingPolicy = iota
+	// QuoteAlways quotes every argument.
+	QuoteAlways
+	// QuoteEscaped writes arguments unquoted where it can, escaping characters such as quotes and braces with backslashes, and quotes only the arguments that are empty or contain white space, which cannot be escaped outside quotes.
+	QuoteEscaped
+)
+
+// quote returns the argument as Confetti source, quoted as opts say.
+func (opts FormatOptions) quote(a string) (string, error) {
+	if err := checkArgument(a); err != nil {
+		return "", err
+	}
+
+	switch {
+	case strings.ContainsFunc(a, isLineTerminator):
+		return `"""` + quoteEscaper.Replace(a) + `"""`, nil
+	case opts.PreferTripleQuotes && strings.Contains(a, `"`) && !strings.Contains(a, `"""`) && !strings.HasSuffix(a, `"`):
+		return `"""` + strings.ReplaceAll(a, `\`, `\\`) + `"""`, nil
+	case opts.Quoting == QuoteAlways,
+		needsQuotes(a, opts.puncts) && (opts.Quoting != QuoteEscaped || a == "" || strings.ContainsFunc(a, isWhitespace)):
+		return `"` + quoteEscaper.Replace(a) + `"`, nil
+	case opts.Quoting == QuoteEscaped && needsQuotes(a, opts.puncts):
+		var b strings.Builder
+		for i, r := range a {
+			// a leading byte order mark or comment start must not be read as one, nor a punctuator within the argument
+			if !argumentOk(r, nil) || r == '\\' || r == '(' || i == 0 && (r == '/' || r == '\ufeff' || r == '\ufffe') ||
+				punctuatorPrefix(a[i:], opts.puncts) > 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		return b.String(), nil
+	}
+	return a, nil
+}
+
+// keepsSources reports whether arguments are written as in their ArgumentSources, rather than as opts say.
+func (opts FormatOptions) keepsSources() bool {
+	return opts.Quoting == QuoteMinimal && !opts.PreferTripleQuotes
+}
+
+// checkComment reports whether c is a single comment that can be written as it is.
+func checkComment(c string) error {
+	if i := strings.IndexFunc(c, isForbidden); i >= 0 {
+		r, _ := utf8.DecodeRuneInString(c[i:])
+		return fmt.Errorf("%w U+%04X", ErrIllegalCharacter, r)
+	}
+
+	switch {
+	case strings.HasPrefix(c, "#"), strings.HasPrefix(c, "//"):
+		if !strings.ContainsFunc(c, isLineTerminator) {
+			return nil
+		}
+	case strings.HasPrefix(c, "/*"):
+		if i := strings.Index(c[2:], "*/"); i >= 0 && i+4 == len(c) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid comment %q", c)
+}
+
+// BraceStyle selects where a formatted block's opening brace goes.
+type BraceStyle uint8
+
+const (
+	// SameLine puts the opening brace at the end of the directive's line.
+	SameLine BraceStyle = iota
+	// NextLine puts the opening brace on a line of its own, indented like the directive.
+	NextLine
+)
+
+// LineEnding selects the line terminator written at the end of each line.
+type LineEnding uint8
+
+const (
+	// LineEndingLF ends lines with a line feed, as on Unix.
+	LineEndingLF LineEnding = iota
+	// LineEndingCRLF ends lines with a carriage return and a line feed, as on Windows.
+	LineEndingCRLF
+	// LineEndingPreserve ends lines with the first line terminator of the source the document was parsed from, or a line feed if it has none.
+	LineEndingPreserve
+)
+
+// CommentStyle selects how Format and an Encoder write the comments attached to directives.
+type CommentStyle uint8
+
+const (
+	// CommentsAsWritten writes comments as they are.
+	CommentsAsWritten CommentStyle = iota
+	// CommentsHash writes every comment as # comments, which can be read without the C-style comments extension. A /* */ comment spanning several lines becomes a comment for each of its lines.
+	CommentsHash
+	// CommentsSlash writes every comment as // comments, which can only be read with the C-style comments extension.
+	CommentsSlash
+)
+
+// comment returns the marker and text of each line of comment c written as opts say. With CommentsAsWritten, the marker is empty and the only line is c.
+func (opts FormatOptions) comment(c string) (marker string, lines []string) {
+	switch opts.Comments {
+	case CommentsHash:
+		marker = "#"
+	case CommentsSlash:
+		marker = "//"
+	default:
+		return "", []string{c}
+	}
+
+	switch {
+	case strings.HasPrefix(c, "#"):
+		return marker, []string{c[1:]}
+	case strings.HasPrefix(c, "//"):
+		return marker, []string{c[2:]}
+	}
+
+	body := strings.ReplaceAll(c[2:len(c)-2], "\r\n", "\n")
+	for {
+		i := strings.IndexFunc(body, isLineTerminator)
+		if i < 0 {
+			lines = append(lines, strings.TrimRightFunc(body, isWhitespace))
+			break
+		}
+		_, size := utf8.DecodeRuneInString(body[i:])
+		lines = append(lines, strings.TrimRightFunc(body[:i], isWhitespace))
+		body = body[i+size:]
+	}
+	// drop the asterisks often written at the start of each line after the first
+	stars := len(lines) > 1
+	for _, l := range lines[1:] {
+		stars = stars && (l == "" || strings.HasPrefix(strings.TrimLeftFunc(l, isWhitespace), "*"))
+	}
+	if stars {
+		for i, l := range lines[1:] {
+			if l != "" {
+				lines[i+1] = strings.TrimLeftFunc(l, isWhitespace)[1:]
+			}
+		}
+	}
+
+	for len(lines) > 1 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for len(lines) > 1 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	return marker, lines
+}
+
+// firstTerminator returns the first line terminator in src, or "" if there is none.
+func firstTerminator(src string) string {
+	i := strings.IndexFunc(src, isLineTerminator)
+	if i < 0 {
+		return ""
+	} else if strings.HasPrefix(src[i:], "\r\n") {
+		return "\r\n"
+	}
+	_, size := utf8.DecodeRuneInString(src[i:])
+	return src[i : i+size]
+}
+
+// FormatOptions control how directives are formatted.
+type FormatOptions struct {
+	// Indent is the string used to indent each level of subdirectives, or four spaces if it is empty.
+	Indent     string
+	BraceStyle BraceStyle
+	// MaxLineWidth is the number of characters after which a directive's arguments continue on the next line, or 0 for no limit. Arguments longer than that are not split.
+	MaxLineWidth int
+	// Quoting selects when arguments are quoted. With a policy other than QuoteMinimal, arguments are quoted as it says rather than as in their ArgumentSources.
+	Quoting QuotingPolicy
+	// PreferTripleQuotes triple quotes arguments containing double quotes, so that they need not be escaped, where the triple quotes can hold them. Like a Quoting policy, it overrides ArgumentSources.
+	PreferTripleQuotes bool
+	// LineEnding selects the line terminator written between lines. Line terminators within triple quoted arguments are part of the argument, and are kept.
+	LineEnding LineEnding
+	// Comments selects the style of the comments attached to directives. Documents written as they were in the source keep its comments as they are.
+	Comments CommentStyle
+
+	preserved string   // the first line terminator of the document's source, for LineEndingPreserve
+	puncts    []string // the punctuators of the document's source, which arguments must not be split at
+}
+
+// withExtensions returns opts quoting arguments so that they are read back as they are in a source with the extensions exts.
+func (opts FormatOptions) withExtensions(exts Extensions) FormatOptions {
+	opts.puncts = nil
+	if exts.Has(ExtPunctuatorArguments) {
+		opts.puncts = punctuators(exts[ExtPunctuatorArguments])
+	}
+	return opts
+}
+
+func (opts FormatOptions) indent() string {
+	if opts.Indent == "" {
+		return defaultIndent
+	}
+	return opts.Indent
+}
+
+// newline returns the line terminator to write.
+func (opts FormatOptions) newline() string {
+	switch {
+	case opts.LineEnding == LineEndingCRLF:
+		return "\r\n"
+	case opts.LineEnding == LineEndingPreserve && opts.preserved != "":
+		return opts.preserved
+	}
+	return "\n"
+}
+
+// Format returns the document's directives formatted according to opts. Arguments are written as in their ArgumentSources, or otherwise quoted only where needed, using triple quotes for arguments spanning multiple lines. Comments attached to directives are kept, but other comments and the source formatting of documents parsed WithLossless are not.
+func Format(doc Document, opts FormatOptions) (string, error) {
+	opts.preserved = firstTerminator(doc.src)
+	opts = opts.withExtensions(doc.Extensions)
+	var b strings.Builder
+	if err := writeDirectives(&b, doc.Directives, opts, ""); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// writeDirectives writes directives formatted according to opts, each line starting with prefix.
+func writeDirectives(b *strings.Builder, p []Directive, opts FormatOptions, prefix string) error {
+	nl := opts.newline()
+	for _, d := range p {
+		if len(d.Arguments) == 0 {
+			return errors.New("directive has no arguments")
+		}
+
+		for _, c := range d.LeadingComments {
+			if err := checkComment(c); err != nil {
+				return err
+			}
+			marker, lines := opts.comment(c)
+			for _, l := range lines {
+				b.WriteString(prefix + marker + l + nl)
+			}
+		}
+
+		b.WriteString(prefix)
+		col := utf8.RuneCountInString(prefix)
+		for i, a := range d.Arguments {
+			q, err := opts.quote(a)
+			if err != nil {
+				return err
+			} else if i < len(d.ArgumentSources) && d.ArgumentSources[i].decodes(a, opts.puncts) && opts.keepsSources() {
+				q = d.ArgumentSources[i].Text
+			}
+
+			width, _, _ := strings.Cut(q, "\n")
+			if n := utf8.RuneCountInString(width); i > 0 && opts.MaxLineWidth > 0 && col+1+n > opts.MaxLineWidth {
+				b.WriteString(" \\" + nl + prefix + opts.indent())
+				col = utf8.RuneCountInString(prefix + opts.indent())
+			} else if i > 0 {
+				b.WriteByte(' ')
+				col++
+			}
+			b.WriteString(q)
+
+			if j := strings.LastIndexAny(q, "\n"); j >= 0 {
+				col = utf8.RuneCountInString(q[j+1:])
+			} else {
+				col += utf8.RuneCountInString(q)
+			}
+		}
+
+		trail := nl
+		if c := d.TrailingComment; c != "" {
+			if err := checkComment(c); err != nil {
+				return err
+			}
+			marker, lines := opts.comment(c)
+			for i, l := range lines[1:] {
+				lines[i+1] = strings.TrimSpace(l)
+			}
+			trail = " " + marker + strings.Join(lines, " ") + trail
+		}
+
+		if len(d.Subdirectives) == 0 {
+			b.WriteString(trail)
+			continue
+		}
+
+		if opts.BraceStyle == NextLine {
+			b.WriteString(nl + prefix + "{" + nl)
+		} else {
+			b.WriteString(" {" + nl)
+		}
+		if err := writeDirectives(b, d.Subdirectives, opts, prefix+opts.indent()); err != nil {
+			return err
+		}
+		b.WriteString(prefix + "}" + trail)
+	}
+
+	return nil
+}
+
+// Marshal returns the Confetti encoding of v, which must be a struct or a map with string keys, converted to directives as described by FromValue.
+// Struct fields are named by their `confetti:"name"` tag, or otherwise by their lowercased field name. Fields tagged "-" and nil pointers are skipped, the fields of embedded structs without a tag are promoted as Unmarshal reads them, and map fields with the "key" option become one directive per entry, with the key as the second argument, as Unmarshal reads them. Options after the name in the tag also control the output:
+//
+//   - "omitempty" skips the field if it holds the zero value or an empty slice or map
+//   - "inline" writes a struct field as one directive with an argument for each of its fields, in order, rather than with subdirectives
+//   - "comment=text" writes "# text" on the line before the field's directive; like "default=", it runs to the end of the tag or to the other of the two
+func Marshal(v any) ([]byte, error) {
+	p, err := FromValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	if err = writeDirectives(&b, p, FormatOptions{}, ""); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+const defaultIndent = "    "
+
+// An Encoder writes documents as Confetti source to an output stream.
+type Encoder struct {
+	w       io.Writer
+	opts    FormatOptions
+	partial bool // whether the last line written has no terminator
+}
+
+// NewEncoder returns an encoder that writes to w, indenting subdirectives by four spaces.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// SetIndent sets the string used to indent each level of subdirectives.
+func (enc *Encoder) SetIndent(indent string) {
+	enc.opts.Indent = indent
+}
+
+// SetMaxLineWidth sets the number of characters after which a directive's arguments continue on the next line, after a backslash, or 0 for no limit. See FormatOptions.MaxLineWidth.
+func (enc *Encoder) SetMaxLineWidth(width int) {
+	enc.opts.MaxLineWidth = width
+}
+
+// SetLineEnding sets the line terminator written at the end of each line. With LineEndingPreserve, it is that of the source of the last document encoded.
+func (enc *Encoder) SetLineEnding(e LineEnding) {
+	enc.opts.LineEnding = e
+}
+
+// SetCommentStyle sets the style of the comments attached to directives. See CommentStyle.
+func (enc *Encoder) SetCommentStyle(s CommentStyle) {
+	enc.opts.Comments = s
+}
+
+// SetExtensions sets the extensions of the source that appended directives are written for, so that they are quoted to be read back as they are. Encode quotes each document for its own Extensions.
+func (enc *Encoder) SetExtensions(exts Extensions) {
+	enc.opts = enc.opts.withExtensions(exts)
+}
+
+// SetQuoting sets when arguments are quoted. See QuotingPolicy.
+func (enc *Encoder) SetQuoting(p QuotingPolicy) {
+	enc.opts.Quoting = p
+}
+
+// SetPreferTripleQuotes sets whether arguments containing double quotes are triple quoted where they can be, rather than escaped. See FormatOptions.PreferTripleQuotes.
+func (enc *Encoder) SetPreferTripleQuotes(prefer bool) {
+	enc.opts.PreferTripleQuotes = prefer
+}
+
+// Encode writes the document to the stream. Arguments are written as in their ArgumentSources, or otherwise quoted only where needed, using triple quotes for arguments spanning multiple lines, and each block of subdirectives is enclosed in braces on its own lines.
+// Documents parsed WithLossless are written as they were in the source, except for the arguments and directives that have since changed.
+func (enc *Encoder) Encode(doc Document) error {
+	if t := firstTerminator(doc.src); t != "" {
+		enc.opts.preserved = t
+	}
+	opts := enc.opts.withExtensions(doc.Extensions)
+
+	var b strings.Builder
+	if doc.lossless {
+		if err := writeLossless(&b, doc.Directives, opts, ""); err != nil {
+			return err
+		}
+		b.WriteString(doc.tail)
+	} else if err := writeDirectives(&b, doc.Directives, opts, ""); err != nil {
+		return err
+	}
+
+	return enc.write(b.String())
+}
+
+func (enc *Encoder) write(s string) error {
+	if s == "" {
+		return nil
+	}
+	_, err := io.WriteString(enc.w, s)
+	r, _ := utf8.DecodeLastRuneInString(s)
+	enc.partial = !isLineTerminator(r)
+	return err
+}
+
+// Append writes a single directive to the stream, with its subdirectives, on lines of its own, so that a program can write records one at a time, as a log or export, and readers can parse what has been written so far. Each directive is written with one call to the stream's Write method, after which the stream is flushed if it has a Flush method, as a bufio.Writer or an http.ResponseWriter does.
+// Directives appended after a document Encode wrote without a final line terminator start on a new line.
+func (enc *Encoder) Append(d Directive) error {
+	var b strings.Builder
+	if enc.partial {
+		b.WriteString(enc.opts.newline())
+	}
+	if err := writeDirectives(&b, []Directive{d}, enc.opts, ""); err != nil {
+		return err
+	}
+	if err := enc.write(b.String()); err != nil {
+		return err
+	}
+
+	switch f := enc.w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return nil
+}
+
+// String returns the document encoded as Confetti source, or the error that prevented encoding it.
+func (doc Document) String() string {
+	var b strings.Builder
+	if err := NewEncoder(&b).Encode(doc); err != nil {
+		return "error: " + err.Error()
+	}
+	return b.String()
+}
@@ -0,0 +1,446 @@
+package confetti_test
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	confetti "github.com/Heliodex/confetti"
+)
+
+func TestEncoder(t *testing.T) {
+	doc := confetti.Document{Directives: []confetti.Directive{
+		{Arguments: []string{"message", "Hello, World!", "multi\nline", `quote"`, "#hash"}},
+		{
+			Arguments: []string{"server"},
+			Subdirectives: []confetti.Directive{
+				{Arguments: []string{"listen", "80"}},
+			},
+		},
+	}}
+
+	var b strings.Builder
+	enc := confetti.NewEncoder(&b)
+	enc.SetIndent("\t")
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Failed to encode document: %v", err)
+	}
+
+	const expected = "message \"Hello, World!\" \"\"\"multi\nline\"\"\" \"quote\\\"\" \"#hash\"\nserver {\n\tlisten 80\n}\n"
+	if b.String() != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, b.String())
+	}
+
+	back, err := confetti.Parse(doc.String())
+	if err != nil {
+		t.Fatalf("Failed to parse encoded document: %v", err)
+	}
+	for i, d := range back.Directives {
+		if !d.Equals(doc.Directives[i]) {
+			t.Fatalf("Directive mismatch at index %d\nExpected:\n%v\nGot:\n%v", i, doc.Directives[i], d)
+		}
+	}
+
+	b.Reset()
+	enc.SetMaxLineWidth(20)
+	wide := confetti.Document{Directives: []confetti.Directive{{
+		Arguments:     []string{"allow", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"},
+		Subdirectives: []confetti.Directive{{Arguments: []string{"log", "on"}}},
+	}}}
+	if err := enc.Encode(wide); err != nil {
+		t.Fatalf("Failed to encode document: %v", err)
+	} else if expected := "allow 10.0.0.0/8 \\\n\t172.16.0.0/12 \\\n\t192.168.0.0/16 {\n\tlog on\n}\n"; b.String() != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, b.String())
+	} else if back, err := confetti.Parse(b.String()); err != nil || !back.Equals(wide) {
+		t.Fatalf("Wrapped document did not parse back: %v", err)
+	}
+
+	if err = enc.Encode(confetti.Document{Directives: []confetti.Directive{{Arguments: []string{"bell\a"}}}}); err == nil {
+		t.Fatal("Expected error encoding a forbidden character")
+	}
+}
+
+// flushCounter counts the flushes of a bufio.Writer.
+type flushCounter struct {
+	*bufio.Writer
+	flushes int
+}
+
+func (f *flushCounter) Flush() error {
+	f.flushes++
+	return f.Writer.Flush()
+}
+
+func TestEncoderAppend(t *testing.T) {
+	doc, err := confetti.Parse("start  now", confetti.WithLossless())
+	if err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	}
+
+	var b strings.Builder
+	w := &flushCounter{Writer: bufio.NewWriter(&b)}
+	enc := confetti.NewEncoder(w)
+	if err = enc.Encode(doc); err != nil {
+		t.Fatalf("Failed to encode document: %v", err)
+	}
+	for i, msg := range []string{"started", "failed: disk full"} {
+		d := confetti.Directive{Arguments: []string{"event", strconv.Itoa(i), msg}}
+		if i == 1 {
+			d.Subdirectives = []confetti.Directive{{Arguments: []string{"retry", "true"}}}
+		}
+		if err = enc.Append(d); err != nil {
+			t.Fatalf("Failed to append directive: %v", err)
+		}
+	}
+
+	const expected = "start  now\nevent 0 started\nevent 1 \"failed: disk full\" {\n    retry true\n}\n"
+	if b.String() != expected || w.flushes != 2 {
+		t.Fatalf("Output mismatch after %d flushes\n-- Expected:\n%s\n-- Got:\n%s", w.flushes, expected, b.String())
+	}
+
+	if err = enc.Append(confetti.Directive{}); err == nil {
+		t.Fatal("Expected an error appending a directive without arguments")
+	}
+}
+
+func TestQuotingPolicy(t *testing.T) {
+	args := []string{"plain", "", "two words", `say "hi" now`, "{cdn}", "//path", "a\\b", "multi\nline", `ends"`, "\ufeffbom", "(x)"}
+	doc := confetti.Document{Directives: []confetti.Directive{{Arguments: args}}}
+
+	for _, test := range []struct {
+		opts     confetti.FormatOptions
+		expected string
+	}{
+		{confetti.FormatOptions{}, `plain "" "two words" "say \"hi\" now" "{cdn}" "//path" "a\\b" """multi` + "\n" + `line""" "ends\"" "` + "\ufeff" + `bom" "(x)"`},
+		{confetti.FormatOptions{Quoting: confetti.QuoteAlways}, `"plain" "" "two words" "say \"hi\" now" "{cdn}" "//path" "a\\b" """multi` + "\n" + `line""" "ends\"" "` + "\ufeff" + `bom" "(x)"`},
+		{confetti.FormatOptions{Quoting: confetti.QuoteEscaped}, `plain "" "two words" "say \"hi\" now" \{cdn\} \//path a\\b """multi` + "\n" + `line""" ends\" \` + "\ufeff" + `bom \(x)`},
+		{confetti.FormatOptions{PreferTripleQuotes: true}, `plain "" "two words" """say "hi" now""" "{cdn}" "//path" "a\\b" """multi` + "\n" + `line""" "ends\"" "` + "\ufeff" + `bom" "(x)"`},
+	} {
+		out, err := confetti.Format(doc, test.opts)
+		if err != nil {
+			t.Fatalf("Failed to format document: %v", err)
+		} else if out != test.expected+"\n" {
+			t.Fatalf("Output mismatch with %+v\n-- Expected:\n%s\n-- Got:\n%s", test.opts, test.expected, out)
+		}
+
+		back, err := confetti.Parse(out, confetti.WithExtensions(confetti.Extensions{confetti.ExtExpressionArguments: ""}))
+		if err != nil {
+			t.Fatalf("Failed to parse formatted document: %v", err)
+		} else if !back.Directives[0].Equals(doc.Directives[0]) {
+			t.Fatalf("Arguments changed with %+v: %q", test.opts, back.Directives[0].Arguments)
+		}
+	}
+
+	punct := confetti.WithPunctuators("=", ":=")
+	doc = confetti.Document{
+		Directives: []confetti.Directive{{Arguments: []string{"a=b", "=", "x:=y", `="q"!`}}},
+		Extensions: confetti.Extensions{confetti.ExtPunctuatorArguments: "=\n:="},
+	}
+	for _, test := range []struct {
+		opts     confetti.FormatOptions
+		expected string
+	}{
+		{confetti.FormatOptions{}, `"a=b" = "x:=y" "=\"q\"!"`},
+		{confetti.FormatOptions{Quoting: confetti.QuoteAlways}, `"a=b" "=" "x:=y" "=\"q\"!"`},
+		{confetti.FormatOptions{Quoting: confetti.QuoteEscaped}, `a\=b = x\:\=y \=\"q\"!`},
+		{confetti.FormatOptions{PreferTripleQuotes: true}, `"a=b" = "x:=y" """="q"!"""`},
+	} {
+		out, err := confetti.Format(doc, test.opts)
+		if err != nil {
+			t.Fatalf("Failed to format document: %v", err)
+		} else if out != test.expected+"\n" {
+			t.Fatalf("Output mismatch with %+v and punctuators\n-- Expected:\n%s\n-- Got:\n%s", test.opts, test.expected, out)
+		}
+
+		back, err := confetti.Parse(out, punct)
+		if err != nil {
+			t.Fatalf("Failed to parse formatted document: %v", err)
+		} else if !back.Directives[0].Equals(doc.Directives[0]) {
+			t.Fatalf("Arguments changed with %+v and punctuators: %q", test.opts, back.Directives[0].Arguments)
+		}
+	}
+}
+
+func TestLineEnding(t *testing.T) {
+	doc, err := confetti.Parse("# web\r\nserver {\r\n    root \"\"\"/srv\n/www\"\"\"\r\n}\r\n")
+	if err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	} else if len(doc.Directives[0].LeadingComments) != 1 {
+		t.Fatal("Expected CR LF to end the comment line once")
+	}
+	for _, src := range []string{"a \"x\\\ny\"\n", "a \"x\\\ry\"\r", "a \"x\\\r\ny\"\r\n"} {
+		if quoted, err := confetti.Parse(src); err != nil {
+			t.Fatalf("Failed to parse %q: %v", src, err)
+		} else if arg := quoted.Directives[0].Arguments[1]; arg != "xy" {
+			t.Fatalf("Expected the escaped line terminator of %q to be removed, got %q", src, arg)
+		}
+	}
+
+	const crlf = "# web\r\nserver {\r\n    root \"\"\"/srv\n/www\"\"\"\r\n}\r\n"
+	for _, e := range []confetti.LineEnding{confetti.LineEndingCRLF, confetti.LineEndingPreserve} {
+		if out, err := confetti.Format(doc, confetti.FormatOptions{LineEnding: e}); err != nil {
+			t.Fatalf("Failed to format document: %v", err)
+		} else if out != crlf {
+			t.Fatalf("Output mismatch with line ending %d\n-- Expected:\n%q\n-- Got:\n%q", e, crlf, out)
+		}
+	}
+	if out, _ := confetti.Format(doc, confetti.FormatOptions{}); out != strings.ReplaceAll(crlf, "\r\n", "\n") {
+		t.Fatalf("Expected line feeds, got %q", out)
+	}
+
+	var b strings.Builder
+	enc := confetti.NewEncoder(&b)
+	enc.SetLineEnding(confetti.LineEndingCRLF)
+	enc.SetMaxLineWidth(16)
+	if err = enc.Append(confetti.Directive{Arguments: []string{"allow", "a.example", "b.example"}}); err != nil {
+		t.Fatalf("Failed to append directive: %v", err)
+	} else if b.String() != "allow a.example \\\r\n    b.example\r\n" {
+		t.Fatalf("Unexpected output %q", b.String())
+	} else if back, err := confetti.Parse(b.String()); err != nil || len(back.Directives) != 1 || len(back.Directives[0].Arguments) != 3 {
+		t.Fatalf("Expected the continuation to join the lines, got %v", err)
+	}
+}
+
+func TestCommentStyle(t *testing.T) {
+	const src = "// listens on\n/*\n * every\n * address\n */\nlisten 80 /* for\n now */\n# web\nroot /srv # static\n"
+	doc, err := confetti.Parse(src, confetti.WithCStyleComments())
+	if err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	}
+
+	tests := []struct {
+		style    confetti.CommentStyle
+		expected string
+	}{
+		{confetti.CommentsAsWritten, "// listens on\n/*\n * every\n * address\n */\nlisten 80 /* for\n now */\n# web\nroot /srv # static\n"},
+		{confetti.CommentsHash, "# listens on\n# every\n# address\nlisten 80 # for now\n# web\nroot /srv # static\n"},
+		{confetti.CommentsSlash, "// listens on\n// every\n// address\nlisten 80 // for now\n// web\nroot /srv // static\n"},
+	}
+	for _, test := range tests {
+		out, err := confetti.Format(doc, confetti.FormatOptions{Comments: test.style})
+		if err != nil {
+			t.Fatalf("Failed to format document: %v", err)
+		} else if out != test.expected {
+			t.Fatalf("Output mismatch with comment style %d\n-- Expected:\n%s\n-- Got:\n%s", test.style, test.expected, out)
+		}
+	}
+
+	// without the extension, only # comments can be read
+	var b strings.Builder
+	enc := confetti.NewEncoder(&b)
+	enc.SetCommentStyle(confetti.CommentsHash)
+	if err = enc.Encode(doc); err != nil {
+		t.Fatalf("Failed to encode document: %v", err)
+	} else if back, err := confetti.Parse(b.String()); err != nil {
+		t.Fatalf("Failed to parse the output without the extension: %v", err)
+	} else if !doc.Equals(back) {
+		t.Fatal("Expected the output to parse to the same directives")
+	}
+}
+
+func TestLossless(t *testing.T) {
+	const src = "# settings\nserver  {\n\tlisten \"80\"   # http\n\n\thost x ; port 1\n}\r\nname   \"\"\"a\nb\"\"\"\n"
+
+	doc, err := confetti.Parse(src, confetti.WithLossless())
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if out := doc.String(); out != src {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", src, out)
+	}
+
+	server := &doc.Directives[0]
+	server.Subdirectives[0].Arguments[1] = "8080"
+	server.Subdirectives = append(server.Subdirectives, confetti.Directive{Arguments: []string{"tls", "on"}})
+
+	const expected = "# settings\nserver  {\n\tlisten 8080   # http\n\n\thost x ; port 1\n\ttls on\n}\r\nname   \"\"\"a\nb\"\"\"\n"
+	if out := doc.String(); out != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, out)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	doc, err := confetti.Parse("server { allow 10.0.0.1 10.0.0.2 10.0.0.3 10.0.0.4; root /srv }\n")
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	out, err := confetti.Format(doc, confetti.FormatOptions{Indent: "  ", BraceStyle: confetti.NextLine, MaxLineWidth: 30})
+	if err != nil {
+		t.Fatalf("Failed to format document: %v", err)
+	}
+
+	const expected = "server\n{\n  allow 10.0.0.1 10.0.0.2 \\\n    10.0.0.3 10.0.0.4\n  root /srv\n}\n"
+	if out != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, out)
+	}
+
+	back, err := confetti.Parse(out)
+	if err != nil {
+		t.Fatalf("Failed to parse formatted document: %v", err)
+	} else if !back.Directives[0].Equals(doc.Directives[0]) {
+		t.Fatalf("Formatted document does not match\nExpected:\n%v\nGot:\n%v", doc.Directives[0], back.Directives[0])
+	}
+}
+
+func TestFmt(t *testing.T) {
+	const src = "# servers\n\n\n  server   {listen 80; listen 443 # both\n\n\n root \"/srv\" \\\n   /var\n}\nempty\n{\n}\n"
+	const expected = "# servers\n\nserver {\n    listen 80\n    listen 443 # both\n\n    root \"/srv\" /var\n}\nempty {\n}\n"
+
+	out, err := confetti.Fmt([]byte(src))
+	if err != nil {
+		t.Fatalf("Failed to format source: %v", err)
+	} else if string(out) != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, out)
+	}
+
+	if again, err := confetti.Fmt(out); err != nil {
+		t.Fatalf("Failed to format formatted source: %v", err)
+	} else if string(again) != expected {
+		t.Fatalf("Formatting is not idempotent\n-- Expected:\n%s\n-- Got:\n%s", expected, again)
+	}
+
+	if _, err = confetti.Fmt([]byte("a\n"), confetti.WithPunctuators("")); err == nil {
+		t.Fatal("Expected an error for an invalid option")
+	}
+}
+
+func TestMinify(t *testing.T) {
+	const src = "# servers\nserver example.com {\n    listen 80 # http\n    root \"/srv/my site\"\n    match \"a;b\" \"{x}\"\n    empty {\n    }\n}\nname \"\"\"multi\nline\"\"\"\nlog on\n"
+	const expected = "server example.com{listen 80;root \"/srv/my site\";match a\\;b \"{x}\";empty}name \"\"\"multi\nline\"\"\";log on"
+
+	out, err := confetti.Minify([]byte(src))
+	if err != nil {
+		t.Fatalf("Failed to minify source: %v", err)
+	} else if string(out) != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, out)
+	}
+
+	if _, err = confetti.Minify([]byte("a {\n")); !errors.Is(err, confetti.ErrExpectedCloseBrace) {
+		t.Fatalf("Expected a parse error, got %v", err)
+	}
+
+	punct := confetti.WithPunctuators("=")
+	if out, err = confetti.Minify([]byte("k \"a=b\" = \"c = d\"\n"), punct); err != nil {
+		t.Fatalf("Failed to minify source with punctuators: %v", err)
+	} else if expected := `k a\=b = "c = d"`; string(out) != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, out)
+	}
+
+	if _, err = confetti.Minify([]byte("a\n"), confetti.WithPunctuators("")); err == nil {
+		t.Fatal("Expected an error for an invalid option")
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	doc, err := confetti.BuildDocument(
+		confetti.NewDirective("server").Arg("example.com").Comment("# main site").Sub(
+			confetti.NewDirective("listen").Arg("443", "ssl"),
+			confetti.NewDirective("root").Arg("/srv/my site"),
+		),
+	)
+	if err != nil {
+		t.Fatalf("Failed to build document: %v", err)
+	}
+
+	const expected = "# main site\nserver example.com {\n    listen 443 ssl\n    root \"/srv/my site\"\n}\n"
+	if out := doc.String(); out != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, out)
+	}
+
+	_, err = confetti.NewDirective("server").Sub(confetti.NewDirective("bell\a")).Build()
+	if !errors.Is(err, confetti.ErrIllegalCharacter) {
+		t.Fatalf("Expected ErrIllegalCharacter, got %v", err)
+	}
+}
+
+func TestEditor(t *testing.T) {
+	const src = "# web\nserver {\n\tlisten \"80\"   # http\n\troot /srv; index x\n}\nempty {}\n"
+
+	e, err := confetti.NewEditor(src)
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+	if err = e.SetArgument([]int{0, 0}, 1, "8080"); err != nil {
+		t.Fatalf("Failed to set argument: %v", err)
+	} else if err = e.SetArgument([]int{0, 1}, -1, "z"); err == nil {
+		t.Fatal("Expected an error setting a negative argument")
+	} else if err = e.SetArgument([]int{0, 1}, 2, "z"); err == nil {
+		t.Fatal("Expected an error setting a missing argument")
+	} else if err = e.Delete([]int{0, 1, 1}); err == nil {
+		t.Fatal("Expected an error deleting a missing directive")
+	} else if err = e.Delete([]int{0, 2}); err != nil {
+		t.Fatalf("Failed to delete directive: %v", err)
+	} else if err = e.Insert([]int{1, 0}, confetti.Directive{Arguments: []string{"note", "a b"}}); err != nil {
+		t.Fatalf("Failed to insert directive: %v", err)
+	} else if err = e.Insert([]int{2}, confetti.Directive{Arguments: []string{"last"}}); err != nil {
+		t.Fatalf("Failed to insert directive: %v", err)
+	} else if err = e.SetArgument([]int{0, 0}, 1, "443"); err == nil {
+		t.Fatal("Expected an error for overlapping edits")
+	}
+
+	out, err := e.Apply()
+	if err != nil {
+		t.Fatalf("Failed to apply edits: %v", err)
+	}
+
+	const expected = "# web\nserver {\n\tlisten \"8080\"   # http\n\troot /srv\n}\nempty {\n\tnote \"a b\"\n}\nlast\n"
+	if out != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, out)
+	}
+
+	for _, test := range []struct {
+		src, expected string
+		opts          []confetti.Option
+		edit          func(e *confetti.Editor) error
+	}{
+		{"p { q }\n", "p { q; r }\n", nil, func(e *confetti.Editor) error {
+			return e.Insert([]int{0, 1}, confetti.Directive{Arguments: []string{"r"}})
+		}},
+		{"a {\r\n    b\r\n}\r\n", "a {\r\n    b\r\n    c {\r\n        d\r\n    }\r\n}\r\n", nil, func(e *confetti.Editor) error {
+			return e.Insert([]int{0, 1}, confetti.Directive{Arguments: []string{"c"}, Subdirectives: []confetti.Directive{{Arguments: []string{"d"}}}})
+		}},
+		{"k = v\n", "k = \"a=b\"\n", []confetti.Option{confetti.WithPunctuators("=")}, func(e *confetti.Editor) error {
+			return e.SetArgument([]int{0}, 2, "a=b")
+		}},
+	} {
+		e, err := confetti.NewEditor(test.src, test.opts...)
+		if err != nil {
+			t.Fatalf("Failed to parse configuration: %v", err)
+		} else if err = test.edit(e); err != nil {
+			t.Fatalf("Failed to edit %q: %v", test.src, err)
+		}
+		if out, err := e.Apply(); err != nil {
+			t.Fatalf("Failed to apply edits: %v", err)
+		} else if out != test.expected {
+			t.Fatalf("Output mismatch\n-- Expected:\n%q\n-- Got:\n%q", test.expected, out)
+		}
+	}
+
+	if _, err = confetti.NewEditor("a\n", confetti.WithPunctuators("")); err == nil {
+		t.Fatal("Expected an error for an invalid option")
+	}
+}
+
+func TestArgumentSources(t *testing.T) {
+	doc, err := confetti.Parse("say \"hi\" \"\"\"there\"\"\" a\\\"b plain\n")
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	srcs := doc.Directives[0].ArgumentSources
+	if len(srcs) != 5 {
+		t.Fatalf("Expected 5 argument sources, got %d", len(srcs))
+	} else if srcs[1] != (confetti.ArgumentSource{Quoting: confetti.QuotingSingle, Text: `"hi"`}) {
+		t.Fatalf("Unexpected source %+v", srcs[1])
+	} else if srcs[2].Quoting != confetti.QuotingTriple || srcs[3] != (confetti.ArgumentSource{Text: `a\"b`}) {
+		t.Fatalf("Unexpected sources %+v", srcs)
+	}
+
+	doc.Directives[0].Arguments[4] = "changed value"
+	const expected = "say \"hi\" \"\"\"there\"\"\" \"a\\\"b\" \"changed value\"\n"
+	if out := doc.String(); out != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, out)
+	}
+}
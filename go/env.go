@@ -0,0 +1,72 @@
+package confetti
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// envKey returns the environment variable name part for a directive name, upper case with characters other than letters and digits replaced by underscores.
+func envKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+// EnvOverlay returns a copy of doc with directives overridden by environment variables, so that a deployment can change any setting without editing files. environ holds "key=value" pairs, as returned by os.Environ.
+// A variable named prefix, an underscore, and the names of a directive and its parents joined by underscores, such as APP_SERVER_PORT for the port directive in the server block with prefix APP, replaces the arguments after the name of every directive at that path. Names are matched in upper case, with characters other than letters and digits as underscores. The value is read as the arguments of a directive, so it may hold several, quoted as in a source.
+// Variables with the prefix that match no directive are returned by name, sorted, so they can be reported; no directives are added for them.
+func EnvOverlay(doc Document, prefix string, environ []string) (Document, []string, error) {
+	vars := map[string][]string{}
+	names := map[string]string{}
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		key, ok := strings.CutPrefix(k, prefix+"_")
+		if !ok || key == "" {
+			continue
+		}
+
+		parsed, err := Parse("_ " + v)
+		if err == nil && (len(parsed.Directives) != 1 || len(parsed.Directives[0].Subdirectives) > 0) {
+			err = errors.New("not a list of arguments")
+		}
+		if err != nil {
+			return Document{}, nil, fmt.Errorf("%s: %w", k, err)
+		}
+		vars[key], names[key] = parsed.Directives[0].Arguments[1:], k
+	}
+
+	out := CloneDocument(doc)
+	used := map[string]bool{}
+	overlayEnv(out.Directives, "", vars, used)
+
+	var unused []string
+	for key, k := range names {
+		if !used[key] {
+			unused = append(unused, k)
+		}
+	}
+	slices.Sort(unused)
+	return out, unused, nil
+}
+
+func overlayEnv(p []Directive, prefix string, vars map[string][]string, used map[string]bool) {
+	for i := range p {
+		d := &p[i]
+		if len(d.Arguments) == 0 {
+			continue
+		}
+
+		key := prefix + envKey(d.Arguments[0])
+		if args, ok := vars[key]; ok {
+			d.Arguments = append(d.Arguments[:1], args...)
+			used[key] = true
+		}
+		overlayEnv(d.Subdirectives, key+"_", vars, used)
+	}
+}
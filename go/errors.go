@@ -0,0 +1,218 @@
+package confetti
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors reported while parsing. A ParseError wraps one of these, so they can be matched with errors.Is.
+var (
+	ErrMalformedUTF8          = errors.New("malformed UTF-8")
+	ErrIllegalCharacter       = errors.New("illegal character")
+	ErrIncompleteEscape       = errors.New("incomplete escape sequence")
+	ErrIllegalEscape          = errors.New("illegal escape character")
+	ErrUnclosedQuoted         = errors.New("unclosed quoted")
+	ErrUnterminatedComment    = errors.New("unterminated multi-line comment")
+	ErrIncompleteExpression   = errors.New("incomplete expression")
+	ErrUnexpectedSemicolon    = errors.New("unexpected ';'")
+	ErrUnexpectedOpenBrace    = errors.New("unexpected '{'")
+	ErrExpectedCloseBrace     = errors.New("expected '}'")
+	ErrUnmatchedCloseBrace    = errors.New("found '}' without matching '{'")
+	ErrUnexpectedContinuation = errors.New("unexpected line continuation")
+	ErrIncludeCycle           = errors.New("include cycle")
+	ErrIncludeDepth           = errors.New("includes nested too deeply")
+	ErrUndefinedVariable      = errors.New("undefined variable")
+	ErrTooDeep                = errors.New("blocks nested too deeply")
+	ErrNotNormalized          = errors.New("argument not in Unicode Normalization Form C")
+	ErrUnclosedReference      = errors.New("unclosed variable reference")
+	ErrMalformedDefinition    = errors.New("variable definition expects a name and a value")
+	ErrMalformedInclude       = errors.New("include expects a single path")
+)
+
+// What WithWarnings reports. Its diagnostics have the codes of these errors, though they are not errors.
+var (
+	ErrByteOrderMark        = errors.New("byte order mark")
+	ErrCtrlZ                = errors.New("^Z at the end of the source")
+	ErrMixedLineTerminators = errors.New("mixed line terminators")
+	ErrTabsAfterSpaces      = errors.New("indented with tabs after lines indented with spaces")
+	ErrSpacesAfterTabs      = errors.New("indented with spaces after lines indented with tabs")
+)
+
+// ErrorCode identifies the kind of a parse error or warning, as one of the errors above does, by a number that stays the same across versions, so that documentation and translations can refer to it. It is written like "E0005".
+type ErrorCode uint16
+
+// codedErrors are the errors with codes, indexed by their code. Errors are only ever added to the end.
+var codedErrors = [...]error{
+	1:  ErrMalformedUTF8,
+	2:  ErrIllegalCharacter,
+	3:  ErrIncompleteEscape,
+	4:  ErrIllegalEscape,
+	5:  ErrUnclosedQuoted,
+	6:  ErrUnterminatedComment,
+	7:  ErrIncompleteExpression,
+	8:  ErrUnexpectedSemicolon,
+	9:  ErrUnexpectedOpenBrace,
+	10: ErrExpectedCloseBrace,
+	11: ErrUnmatchedCloseBrace,
+	12: ErrUnexpectedContinuation,
+	13: ErrIncludeCycle,
+	14: ErrIncludeDepth,
+	15: ErrUndefinedVariable,
+	16: ErrTooDeep,
+	17: ErrNotNormalized,
+	18: ErrUnclosedReference,
+	19: ErrByteOrderMark,
+	20: ErrCtrlZ,
+	21: ErrMixedLineTerminators,
+	22: ErrTabsAfterSpaces,
+	23: ErrSpacesAfterTabs,
+	24: ErrMalformedDefinition,
+	25: ErrMalformedInclude,
+}
+
+// CodeOf returns the code of the first of the errors above that err matches with errors.Is, or 0 if it matches none.
+func CodeOf(err error) ErrorCode {
+	for code, e := range codedErrors {
+		if e != nil && errors.Is(err, e) {
+			return ErrorCode(code)
+		}
+	}
+	return 0
+}
+
+// Err returns the error with the code, or nil if there is none.
+func (c ErrorCode) Err() error {
+	if int(c) < len(codedErrors) {
+		return codedErrors[c]
+	}
+	return nil
+}
+
+func (c ErrorCode) String() string {
+	return fmt.Sprintf("E%04d", uint16(c))
+}
+
+// Translator returns the message for a parse error or diagnostic with a code in the operator's language, or "" to leave it in English.
+// detail is the part of the English message after the text of the code's error, such as "U+0007" in "illegal character U+0007", which needs no translating, and start is, for unclosed arguments, comments, and expressions, the line and column they started at, such as "3:5", or "".
+type Translator func(code ErrorCode, detail, start string) string
+
+// translate returns the message for code in the operator's language, or "" if there is none.
+func (t Translator) translate(code ErrorCode, detail, start string) string {
+	if t == nil || code == 0 {
+		return ""
+	}
+	return t(code, detail, start)
+}
+
+// Catalog holds messages in a language by their code, with "{detail}" and "{start}" standing for the details a Translator is given. Its Translate method is a Translator.
+type Catalog map[ErrorCode]string
+
+// Translate returns the message for code with its details filled in, or "" if the catalog has none.
+func (c Catalog) Translate(code ErrorCode, detail, start string) string {
+	return strings.NewReplacer("{detail}", detail, "{start}", start).Replace(c[code])
+}
+
+// ParseError is an error in a Confetti source, with its location.
+type ParseError struct {
+	Pos Position
+	// Token is the source text of the offending token or character, if any.
+	Token string
+	// Line is the source line containing Pos, without its terminator.
+	Line string
+	// Start is the position of the start of the quoted argument, comment, or expression left unclosed, for errors such as ErrUnclosedQuoted, whose Pos is where the input ran out.
+	Start Position
+	Err   error
+
+	translate Translator
+}
+
+func (e *ParseError) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Err.Error()
+	}
+	return e.Pos.String() + ": " + e.message()
+}
+
+// message returns the error without its position, translated if it was parsed WithTranslator.
+func (e *ParseError) message() string {
+	start := ""
+	if e.Start.IsValid() {
+		start = fmt.Sprintf("%d:%d", e.Start.Line, e.Start.Column)
+	}
+	code := e.Code()
+	detail := ""
+	if base := code.Err(); base != nil {
+		if rest, ok := strings.CutPrefix(e.Err.Error(), base.Error()); ok {
+			detail = strings.TrimSpace(rest)
+		}
+	}
+	if m := e.translate.translate(code, detail, start); m != "" {
+		return m
+	} else if start == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ", started at " + start
+}
+
+// setTranslator makes the parse errors in err translate their messages with t.
+func setTranslator(err error, t Translator) {
+	var pe *ParseError
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			setTranslator(err, t)
+		}
+	} else if errors.As(err, &pe) {
+		pe.translate = t
+	}
+}
+
+// Code returns the code of the error, or 0 if it has none.
+func (e *ParseError) Code() ErrorCode {
+	return CodeOf(e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Snippet renders the source line containing the error with a caret under the offending column.
+func (e *ParseError) Snippet() string {
+	if !e.Pos.IsValid() {
+		return ""
+	}
+
+	// keep tabs so the caret lines up
+	var pad strings.Builder
+	for i, r := range []rune(e.Line) {
+		if i >= e.Pos.Column-1 {
+			break
+		} else if r == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+	return e.Line + "\n" + pad.String() + "^"
+}
+
+func tokenError(t Token, err error) *ParseError {
+	return &ParseError{Pos: t.Pos, Token: t.Text, Err: err}
+}
+
+// withSource fills in the file name and source line of a ParseError.
+func withSource(err error, src, name string) error {
+	var pe *ParseError
+	if !errors.As(err, &pe) || !pe.Pos.IsValid() {
+		return err
+	}
+
+	pe.Pos.Filename = name
+	if pe.Start.IsValid() {
+		pe.Start.Filename = name
+	}
+	if off := pe.Pos.Offset; off <= len(src) {
+		pe.Line = src[lineStart(src, off):lineEnd(src, off)]
+	}
+	return err
+}
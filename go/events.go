@@ -0,0 +1,295 @@
+package confetti
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"unicode/utf8"
+)
+
+// EventHandler receives the structure of a source from ParseEvents as it is read. Returning an error from any method stops parsing with that error.
+type EventHandler interface {
+	// OnDirective is called with the arguments of each directive once they have all been read.
+	OnDirective(args []string) error
+	// OnBlockStart is called at the start of the block of subdirectives of the directive last passed to OnDirective.
+	OnBlockStart() error
+	// OnBlockEnd is called at the end of a block.
+	OnBlockEnd() error
+	// OnComment is called with each comment as written in the source, after the directive it is on the same line as, if any.
+	OnComment(text string) error
+}
+
+// ParseEvents reads a Confetti source from r and reports its directives, blocks, and comments to h as they are read, without building a document, so sources of any size can be processed in memory proportional to their longest line and deepest nesting.
+// It fails with a *ParseError where Parse would, though, having read only part of the source, it may report an earlier error than Parse for a source with several. Handlers may have been called for the directives before the error. Includes, lossless parsing, modes other than ModeStandard, and extensions that transform the document, such as ExtVariables, cannot be used.
+func ParseEvents(r io.Reader, h EventHandler, opts ...Option) error {
+	er, err := newEventReader(r, h, opts)
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err = er.read(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// eventReader reads a source a few lines at a time, passing its tokens to an eventParser.
+type eventReader struct {
+	p     eventParser
+	br    *bufio.Reader
+	start Position // of the next line
+	bom   Token    // the byte order mark skipped at the start, if there was one
+	done  bool
+}
+
+func newEventReader(r io.Reader, h EventHandler, opts []Option) (*eventReader, error) {
+	c := newConfig(opts)
+	if c.err != nil {
+		return nil, c.err
+	} else if err := c.streams(); err != nil {
+		return nil, err
+	}
+
+	er := &eventReader{p: eventParser{h: h, c: c, first: true}, br: bufio.NewReader(r), start: Position{Line: 1, Column: 1}}
+	if bom, _ := er.br.Peek(3); string(bom) == "\ufeff" || string(bom) == "\ufffe" {
+		er.bom = Token{Kind: TokenUnicode, Text: string(bom), Value: string(bom), Pos: er.start, End: Position{Offset: 3, Line: 1, Column: 1}}
+		er.br.Discard(3)
+		er.start.Offset = 3
+		er.p.first = false // parse looks back to the first token after the byte order mark
+	}
+	return er, nil
+}
+
+// streams reports why a source cannot be read a few lines at a time with the options c, if it cannot.
+func (c config) streams() error {
+	if c.includeDepth > 0 || c.lossless || c.mode != ModeStandard {
+		return errors.New("includes, lossless parsing, and modes other than ModeStandard cannot be used when streaming")
+	}
+	hooks, _ := c.exts.hooks()
+	for _, hk := range hooks {
+		if hk.Transform != nil {
+			return errors.New("transforming extensions cannot be used when streaming")
+		}
+	}
+	return nil
+}
+
+// read reads whole lines, more of them while a token runs past the end of those read, and returns their tokens once they have been passed to the eventParser. It returns io.EOF once the source has been read.
+func (er *eventReader) read() ([]Token, error) {
+	if er.done {
+		return nil, io.EOF
+	}
+
+	var chunk string
+	for {
+		line, err := er.readLine()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		eof := err == io.EOF
+		if chunk += line; chunk == "" {
+			er.done = true
+			if err = er.p.end(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		if !utf8.ValidString(chunk) {
+			return nil, &ParseError{Err: ErrMalformedUTF8}
+		}
+
+		var ts []Token
+		end, err := lexAt(chunk, er.start, er.p.c.exts, func(t Token) bool {
+			ts = append(ts, t)
+			return true
+		})
+		var pe *ParseError
+		if errors.As(err, &pe) && !eof && pe.Pos.Offset == er.start.Offset+len(chunk) {
+			continue
+		} else if err != nil {
+			return nil, er.p.withSource(err, chunk, er.start)
+		}
+
+		if name := er.p.c.name; name != "" {
+			for i := range ts {
+				ts[i].Pos.Filename = name
+				ts[i].End.Filename = name
+			}
+		}
+		if err = normalize(ts, er.p.c.norm); err != nil {
+			return nil, er.p.withSource(err, chunk, er.start)
+		}
+		for _, t := range ts {
+			if err = er.p.token(t); err != nil {
+				return nil, er.p.withSource(err, chunk, er.start)
+			}
+		}
+
+		if er.start, er.done = end, eof; eof {
+			if err = er.p.end(); err != nil {
+				return nil, err
+			}
+		}
+		return ts, nil
+	}
+}
+
+// readLine reads up to and including the next line terminator, taking CR LF as one, or to the end of the source.
+func (er *eventReader) readLine() (string, error) {
+	var line []byte
+	for {
+		c, err := er.br.ReadByte()
+		if err != nil {
+			return string(line), err
+		}
+		line = append(line, c)
+		if r, _ := utf8.DecodeLastRune(line); r == '\r' {
+			if next, err := er.br.Peek(1); err == nil && next[0] == '\n' {
+				er.br.Discard(1)
+				line = append(line, '\n')
+			}
+			return string(line), nil
+		} else if isLineTerminator(r) {
+			return string(line), nil
+		}
+	}
+}
+
+// eventParser follows the structure of a source token by token, as parse does for a whole source.
+type eventParser struct {
+	h EventHandler
+	c config
+
+	args     []string  // of the directive being read
+	comments []string  // on the line of the directive being read
+	prev     TokenKind // the last token other than white space and comments, as parse's prevSignificant sees it
+	first    bool      // whether the next token is the first of its block, which parse does not look back to
+	last     Token     // the last token read
+	pushed   bool      // whether a directive has been read in the current block
+	block    bool      // whether the last directive read has a block
+	depth    int
+	outer    Token // the open brace of the outermost unclosed block
+}
+
+// push reports the directive being read, if any.
+func (p *eventParser) push() error {
+	if p.args == nil {
+		return nil
+	}
+
+	args := p.args
+	p.args, p.pushed = nil, true
+	if err := p.h.OnDirective(args); err != nil {
+		return err
+	}
+	return p.flushComments()
+}
+
+func (p *eventParser) flushComments() error {
+	for _, c := range p.comments {
+		if err := p.h.OnComment(c); err != nil {
+			return err
+		}
+	}
+	p.comments = nil
+	return nil
+}
+
+func (p *eventParser) token(t Token) error {
+	prev := p.prev
+	if p.first {
+		p.first = false
+	} else if t.Kind != TokenWhitespace && t.Kind != TokenComment {
+		p.prev = t.Kind
+	}
+	p.last = t
+
+	switch t.Kind {
+	case TokenArgument, TokenQuotedArgument, TokenTripleQuotedArgument, TokenPunctuator:
+		if p.args == nil {
+			p.block = false
+		}
+		p.args = append(p.args, t.Value)
+
+	case TokenComment:
+		if p.args != nil {
+			p.comments = append(p.comments, t.Text)
+			return nil
+		}
+		return p.h.OnComment(t.Text)
+
+	case TokenSemicolon:
+		if prev == TokenSemicolon || prev == TokenNewline || prev == TokenContinuation {
+			return tokenError(t, ErrUnexpectedSemicolon)
+		}
+		return p.push()
+
+	case TokenNewline:
+		return p.push()
+
+	case TokenOpenBrace:
+		if prev == TokenSemicolon || p.args == nil && (!p.pushed || p.block) {
+			return tokenError(t, ErrUnexpectedOpenBrace)
+		} else if p.depth == p.c.maxDepth {
+			return tokenError(t, ErrTooDeep)
+		}
+
+		if err := p.push(); err != nil {
+			return err
+		}
+		if p.depth++; p.depth == 1 {
+			p.outer = t
+		}
+		p.pushed, p.block, p.prev, p.first = false, false, TokenUnicode, true
+		return p.h.OnBlockStart()
+
+	case TokenCloseBrace:
+		if p.depth == 0 {
+			return tokenError(t, ErrUnmatchedCloseBrace)
+		} else if err := p.push(); err != nil {
+			return err
+		}
+		p.depth--
+		p.pushed, p.block = true, true
+		return p.h.OnBlockEnd()
+
+	case TokenContinuation:
+		if p.args == nil {
+			return tokenError(t, ErrUnexpectedContinuation)
+		}
+	}
+	return nil
+}
+
+// end finishes the source once every token has been read.
+func (p *eventParser) end() error {
+	if p.depth > 0 {
+		if p.depth == 1 && p.last.Kind == TokenOpenBrace {
+			return tokenError(p.last, ErrUnexpectedOpenBrace) // as parse reports a brace ending the source
+		}
+		return tokenError(p.outer, ErrExpectedCloseBrace)
+	}
+	if err := p.push(); err != nil {
+		return err
+	}
+	return p.flushComments()
+}
+
+// withSource fills in the file name and source line of a ParseError in chunk, which starts at start.
+func (p *eventParser) withSource(err error, chunk string, start Position) error {
+	var pe *ParseError
+	if !errors.As(err, &pe) || !pe.Pos.IsValid() {
+		return err
+	}
+
+	pe.Pos.Filename, pe.translate = p.c.name, p.c.translate
+	if pe.Start.IsValid() {
+		pe.Start.Filename = p.c.name
+	}
+	if off := pe.Pos.Offset - start.Offset; off >= 0 && off <= len(chunk) {
+		pe.Line = chunk[lineStart(chunk, off):lineEnd(chunk, off)]
+	}
+	return err
+}
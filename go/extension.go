@@ -0,0 +1,89 @@
+package confetti
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ExtensionHooks are the ways an extension changes how a source is read. Each hook is passed the value the extension was enabled with.
+type ExtensionHooks struct {
+	// Argument is called where a token may start, with the rest of the source. If it returns n > 0, the next n bytes, less any of a character they end within, are read as an unquoted argument.
+	Argument func(rest, value string) (n int)
+	// Transform is called with the directives of the document once it has been parsed and any includes have been spliced in, and returns the directives to use instead.
+	Transform func(p []Directive, value string) ([]Directive, error)
+}
+
+type registered struct {
+	name  string
+	hooks ExtensionHooks
+}
+
+// registry holds the extensions by their value, so index 0 is unused.
+var registry = struct {
+	sync.RWMutex
+	exts []registered
+}{exts: []registered{
+	{},
+	{name: "c_style_comments"},
+	{name: "expression_arguments"},
+	{name: "punctuator_arguments"},
+	{name: "variables", hooks: ExtensionHooks{Transform: func(p []Directive, value string) ([]Directive, error) {
+		return substitute(p, value, nil)
+	}}},
+}}
+
+// RegisterExtension makes an extension available under a name, returning its key for use in Extensions. It panics if the name is already registered.
+func RegisterExtension(name string, hooks ExtensionHooks) extension {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, r := range registry.exts {
+		if r.name == name && name != "" {
+			panic("confetti: extension " + name + " registered twice")
+		}
+	}
+	registry.exts = append(registry.exts, registered{name, hooks})
+	return extension(len(registry.exts) - 1)
+}
+
+// LookupExtension returns the key of the extension registered under a name. The built-in extensions are named c_style_comments, expression_arguments, punctuator_arguments, and variables.
+func LookupExtension(name string) (extension, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	for i, r := range registry.exts {
+		if i > 0 && r.name == name {
+			return extension(i), true
+		}
+	}
+	return 0, false
+}
+
+// String returns the name the extension is registered under.
+func (e extension) String() string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	if e == 0 || int(e) >= len(registry.exts) {
+		return fmt.Sprintf("extension(%d)", e)
+	}
+	return registry.exts[e].name
+}
+
+// hooks returns the hooks of the enabled extensions, in the order they were registered.
+func (e Extensions) hooks() (hs []ExtensionHooks, values []string) {
+	if len(e) == 0 {
+		return
+	}
+
+	registry.RLock()
+	defer registry.RUnlock()
+
+	for i, r := range registry.exts {
+		if v, ok := e[extension(i)]; ok && i > 0 && (r.hooks.Argument != nil || r.hooks.Transform != nil) {
+			hs = append(hs, r.hooks)
+			values = append(values, v)
+		}
+	}
+	return
+}
@@ -0,0 +1,77 @@
+package confetti
+
+import (
+	"io/fs"
+	"maps"
+	"os"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	size    int64
+	modTime time.Time
+	conf    config
+	doc     Document
+}
+
+var fileCache = struct {
+	sync.Mutex
+	entries map[string]cacheEntry
+}{entries: map[string]cacheEntry{}}
+
+// CachedParseFile loads the file at path, named by path unless WithName is given, reusing the result of a previous call if the file's size and modification time are unchanged and the options are the same. Files it includes are not checked for changes.
+// Each call returns its own copy of the document, which the caller may modify.
+func CachedParseFile(path string, opts ...Option) (Document, error) {
+	c := newConfig(append([]Option{WithName(path)}, opts...))
+	if c.err != nil {
+		return Document{}, c.err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Document{}, err
+	}
+
+	fileCache.Lock()
+	e, ok := fileCache.entries[path]
+	fileCache.Unlock()
+	if ok && e.size == info.Size() && e.modTime.Equal(info.ModTime()) && e.conf.same(c) {
+		return CloneDocument(e.doc), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Document{}, err
+	}
+
+	doc, err := parseSource(string(data), c)
+	if err != nil {
+		fileCache.Lock()
+		delete(fileCache.entries, path)
+		fileCache.Unlock()
+		return Document{}, err
+	}
+
+	c.exts = maps.Clone(c.exts) // the caller may change its map
+	fileCache.Lock()
+	fileCache.entries[path] = cacheEntry{info.Size(), info.ModTime(), c, doc}
+	fileCache.Unlock()
+
+	return CloneDocument(doc), nil
+}
+
+// ParseFS parses the file at name in fsys, such as an embed.FS, naming the document name. Includes enabled by WithIncludes are read from fsys too, relative to the directory of the including file, and fail if they name a path outside fsys.
+func ParseFS(fsys fs.FS, name string, opts ...Option) (Document, error) {
+	c := newConfig(opts)
+	if c.err != nil {
+		return Document{}, c.err
+	}
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return Document{}, err
+	}
+
+	c.name, c.fsys = name, fsys
+	return parseSource(string(data), c)
+}
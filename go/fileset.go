@@ -0,0 +1,117 @@
+package confetti
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+// FileSet records the sources of a set of files, such as a configuration and the files it includes, so that positions in any of them can be turned into the lines they are on, and compactly numbered, as go/token's FileSet does.
+// Parse adds each named source it reads WithFileSet, including included files. A FileSet is safe for concurrent use.
+type FileSet struct {
+	mu    sync.Mutex
+	files []*SourceFile
+	base  int
+}
+
+// SourceFile is a file in a FileSet.
+type SourceFile struct {
+	Name string
+	// Base is the number of the first byte of the file in the FileSet, after which each byte is numbered in turn.
+	Base int
+	Src  string
+}
+
+// NewFileSet returns an empty file set.
+func NewFileSet() *FileSet {
+	return &FileSet{base: 1}
+}
+
+// AddFile adds the source src, named name, to the set, numbering its bytes after those of the files before it. A file added with the name of another, as when it is parsed again, replaces it, so that the numbers of the old file's bytes are no longer in the set, unless its source is unchanged, when the file already in the set is returned.
+func (s *FileSet) AddFile(name, src string) *SourceFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := slices.IndexFunc(s.files, func(f *SourceFile) bool { return f.Name == name }); i >= 0 {
+		if s.files[i].Src == src {
+			return s.files[i]
+		}
+		s.files = slices.Delete(s.files, i, i+1)
+	}
+
+	f := &SourceFile{name, s.base, src}
+	s.files = append(s.files, f)
+	s.base += len(src) + 1 // so the end of each file has a number of its own
+	return f
+}
+
+// Files returns the files in the set, in the order they were added.
+func (s *FileSet) Files() []*SourceFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.files)
+}
+
+// File returns the file with the given name, or nil if there is none.
+func (s *FileSet) File(name string) *SourceFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := slices.IndexFunc(s.files, func(f *SourceFile) bool { return f.Name == name }); i >= 0 {
+		return s.files[i]
+	}
+	return nil
+}
+
+// Pos returns the number of the byte at p in the file named by p.Filename, or 0 if the set has no such file or p is not in it.
+func (s *FileSet) Pos(p Position) int {
+	if f := s.File(p.Filename); f != nil && p.IsValid() && p.Offset <= len(f.Src) {
+		return f.Base + p.Offset
+	}
+	return 0
+}
+
+// Position returns the position of the byte numbered pos, or an invalid position if it is in no file of the set.
+func (s *FileSet) Position(pos int) Position {
+	s.mu.Lock()
+	i, found := slices.BinarySearchFunc(s.files, pos, func(f *SourceFile, pos int) int {
+		return f.Base - pos
+	})
+	if !found {
+		i--
+	}
+	var f *SourceFile
+	if i >= 0 && pos-s.files[i].Base <= len(s.files[i].Src) {
+		f = s.files[i]
+	}
+	s.mu.Unlock()
+
+	if f == nil {
+		return Position{}
+	}
+	return f.Position(pos - f.Base)
+}
+
+// Position returns the position of the byte at offset in the file.
+func (f *SourceFile) Position(offset int) Position {
+	st := stream{src: f.Src, pos: offset, here: Position{Line: 1, Column: 1}}
+	if strings.HasPrefix(f.Src, "\ufeff") && offset >= 3 {
+		st.src, st.pos, st.here.Offset = f.Src[3:], offset-3, 3
+	}
+	p := st.position()
+	p.Filename = f.Name
+	return p
+}
+
+// Line returns the line of the file containing p, without its terminator, or "" if the set has no file named by p.Filename.
+func (s *FileSet) Line(p Position) string {
+	f := s.File(p.Filename)
+	if f == nil || !p.IsValid() || p.Offset > len(f.Src) {
+		return ""
+	}
+	return f.Src[lineStart(f.Src, p.Offset):lineEnd(f.Src, p.Offset)]
+}
+
+// Render renders the diagnostic as Diagnostic.Render does, with the line from the file it is in.
+func (s *FileSet) Render(d Diagnostic, color bool) string {
+	return render(d, s.Line(d.Pos), color)
+}
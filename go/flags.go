@@ -0,0 +1,56 @@
+package confetti
+
+import (
+	"errors"
+	"flag"
+	"strings"
+)
+
+// BindFlags sets the flags in fs named after directives of doc to their values, and makes those values the flags' defaults, so that flags given on the command line, parsed after BindFlags, override the configuration. With EnvOverlay applied to doc first, flags take precedence over the environment, which takes precedence over files.
+// A flag is named after the directive whose name and those of its parents join with dots or hyphens to give the flag's name, such as "server.port" or "server-port". Its value is set to each argument after the name in turn, so flags taking a list gather every argument, and a boolean flag with no argument is set to true. Repeated directives set the flag again, so the last wins for flags taking one value.
+// BindFlags fails with a *DecodeError if a flag does not accept a value.
+func BindFlags(fs *flag.FlagSet, doc Document) error {
+	return bindFlags(fs, doc.Directives, nil)
+}
+
+func bindFlags(fs *flag.FlagSet, p []Directive, path []string) error {
+	for _, d := range p {
+		if len(d.Arguments) == 0 {
+			continue
+		}
+
+		dpath := append(path[:len(path):len(path)], d.Arguments[0])
+		f := fs.Lookup(strings.Join(dpath, "."))
+		if f == nil {
+			f = fs.Lookup(strings.Join(dpath, "-"))
+		}
+		if f != nil && (len(d.Arguments) > 1 || len(d.Subdirectives) == 0) {
+			if err := setFlag(f, d.Arguments[1:]); err != nil {
+				return &DecodeError{d.Pos, dpath, err}
+			}
+		}
+
+		if err := bindFlags(fs, d.Subdirectives, dpath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFlag sets f to each of args, and its default to the result.
+func setFlag(f *flag.Flag, args []string) error {
+	if len(args) == 0 {
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+			return errors.New("expected an argument for flag -" + f.Name)
+		}
+		args = []string{"true"}
+	}
+
+	for _, a := range args {
+		if err := f.Value.Set(a); err != nil {
+			return err
+		}
+	}
+	f.DefValue = f.Value.String()
+	return nil
+}
@@ -0,0 +1,130 @@
+package confetti
+
+import (
+	"errors"
+	"strings"
+)
+
+// Fmt formats Confetti source in canonical style, like gofmt: each directive on its own line, indented by four spaces per level, with single spaces between arguments and opening braces at the end of the directive's line. Comments are kept, arguments are written as in the source, and runs of blank lines are reduced to one.
+// Semicolons and line continuations are removed. Formatting already formatted source leaves it unchanged. Options other than WithMaxDepth and those enabling extensions are ignored.
+func Fmt(src []byte, opts ...Option) ([]byte, error) {
+	c := newConfig(opts)
+	if c.err != nil {
+		return nil, c.err
+	}
+	s := string(src)
+
+	ts, err := lex(s, c.exts)
+	if err != nil {
+		return nil, withSource(err, s, c.name)
+	}
+	p, err := parse(ts, c.exts, c.maxDepth)
+	if err != nil {
+		return nil, withSource(err, s, c.name)
+	}
+
+	f := formatter{}
+	for _, t := range ts {
+		f.token(t)
+	}
+	out := f.String()
+
+	// make sure nothing but the formatting changed
+	if !reparses(out, p, c) {
+		return nil, errors.New("formatting changed the document")
+	}
+
+	return []byte(out), nil
+}
+
+type formatter struct {
+	prefix, suffix string   // byte order mark and ^Z
+	lines          []string // the last is the current line if inLine
+	inLine         bool
+	last           TokenKind // last token written to the current line
+	depth          int
+	newlines       int  // line terminators since the last line ended
+	opened         bool // whether the last line opened a block, so a blank line is not needed
+}
+
+// begin starts a new line, keeping a blank line before it if the source had one.
+func (f *formatter) begin() {
+	if f.newlines >= 2 && len(f.lines) > 0 && !f.opened {
+		f.lines = append(f.lines, "")
+	}
+	f.lines = append(f.lines, strings.Repeat(defaultIndent, f.depth))
+	f.inLine, f.newlines, f.opened = true, 0, false
+}
+
+func (f *formatter) end() {
+	if f.inLine {
+		f.inLine, f.newlines = false, 1
+	}
+}
+
+func (f *formatter) write(t Token) {
+	if !f.inLine {
+		f.begin()
+	} else {
+		f.lines[len(f.lines)-1] += " "
+	}
+	f.lines[len(f.lines)-1] += t.Text
+	f.last = t.Kind
+}
+
+func (f *formatter) token(t Token) {
+	switch t.Kind {
+	case TokenUnicode:
+		if len(f.lines) == 0 && t.Value != "\u001a" {
+			f.prefix = t.Value
+		} else {
+			f.suffix = t.Value
+		}
+
+	case TokenNewline:
+		if f.inLine {
+			f.end()
+		} else {
+			f.newlines++
+		}
+
+	case TokenSemicolon:
+		f.end()
+
+	case TokenArgument, TokenQuotedArgument, TokenTripleQuotedArgument, TokenPunctuator:
+		if f.last == TokenOpenBrace || f.last == TokenCloseBrace {
+			// a directive after a brace on the same line
+			f.end()
+		}
+		f.write(t)
+
+	case TokenComment:
+		f.write(t)
+
+	case TokenOpenBrace:
+		if !f.inLine && f.last != TokenComment && len(f.lines) > 0 {
+			// move the brace up to the directive's line
+			f.inLine = true
+		}
+		f.write(t)
+		f.depth++
+		f.opened = true
+
+	case TokenCloseBrace:
+		f.depth--
+		f.end()
+		f.newlines = 0
+		f.write(t)
+	}
+}
+
+func (f *formatter) String() string {
+	prefix := f.prefix
+	if len(f.lines) == 0 {
+		return prefix + f.suffix
+	} else if prefix == "" && (strings.HasPrefix(f.lines[0], "\ufeff") || strings.HasPrefix(f.lines[0], "\ufffe")) {
+		// an argument starting with a byte order mark needs one before it to keep it from being removed
+		prefix = "\ufeff"
+	}
+	return prefix + strings.Join(f.lines, "\n") + "\n" + f.suffix
+}
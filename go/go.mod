@@ -1,3 +1,9 @@
 module github.com/Heliodex/confetti
 
 go 1.24.2
+
+require (
+	github.com/BurntSushi/toml v1.6.0
+	golang.org/x/text v0.34.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,126 @@
+package confetti
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// flatten calls f for each directive with its key, the names of it and its parents joined by dots. Directives with only a name and subdirectives are skipped.
+func flatten(p []Directive, prefix string, f func(key string, d Directive)) {
+	for _, d := range p {
+		if len(d.Arguments) == 0 {
+			continue
+		}
+
+		key := prefix + d.Arguments[0]
+		if len(d.Arguments) > 1 || len(d.Subdirectives) == 0 {
+			f(key, d)
+		}
+		flatten(d.Subdirectives, key+".", f)
+	}
+}
+
+// Redacted replaces the values of directives hidden by HandlerOptions.Redact.
+const Redacted = "REDACTED"
+
+func redact(p []Directive, prefix string, hide func(key string) bool) []Directive {
+	if p == nil {
+		return nil
+	}
+
+	rp := make([]Directive, len(p))
+	for i, d := range p {
+		if len(d.Arguments) == 0 {
+			rp[i] = d
+			continue
+		}
+
+		key := prefix + d.Arguments[0]
+		if hide(key) && len(d.Arguments) > 1 {
+			d.Arguments = slices.Concat(d.Arguments[:1], slices.Repeat([]string{Redacted}, len(d.Arguments)-1))
+		}
+		d.Subdirectives = redact(d.Subdirectives, key+".", hide)
+		rp[i] = d
+	}
+	return rp
+}
+
+// HandlerOptions configures the handler returned by NewHandler.
+type HandlerOptions struct {
+	// Redact reports whether the values of the directive at key, such as "database.password", should be hidden.
+	Redact func(key string) bool
+}
+
+type handler struct {
+	load func() Document
+	opts HandlerOptions
+}
+
+// NewHandler returns a handler serving the document returned by load, for use as a /debug/config endpoint.
+// The format query parameter selects the output: "json" (the default), "confetti", or "flat" for one "key = value" line per directive.
+func NewHandler(load func() Document, opts HandlerOptions) http.Handler {
+	return handler{load, opts}
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	doc := h.load()
+	p := doc.Directives
+	if h.opts.Redact != nil {
+		p = redact(p, "", h.opts.Redact)
+	}
+
+	var b strings.Builder
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		if p == nil {
+			p = []Directive{}
+		}
+		data, err := json.Marshal(p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		b.Write(data)
+
+	case "confetti":
+		if err := writeDirectives(&b, p, FormatOptions{}.withExtensions(doc.Extensions), ""); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	case "flat":
+		var err error
+		flatten(p, "", func(key string, d Directive) {
+			b.WriteString(key + " =")
+			for _, a := range d.Arguments[1:] {
+				q, qerr := quoteArgument(a, doc.Extensions)
+				if qerr != nil {
+					err = qerr
+				}
+				b.WriteString(" " + q)
+			}
+			b.WriteByte('\n')
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	default:
+		http.Error(w, "unknown format "+format, http.StatusBadRequest)
+		return
+	}
+
+	w.Write([]byte(b.String()))
+}
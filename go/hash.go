@@ -0,0 +1,31 @@
+package confetti
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+)
+
+// Hash returns a SHA-256 digest of the directives of the document, which is the same for documents that are equal by Equals, whatever their formatting, comments, and name.
+// It only changes when the directives do, so it can tell whether a configuration file was changed in substance before reloading it.
+func (doc Document) Hash() [sha256.Size]byte {
+	h := sha256.New()
+	hashDirectives(h, doc.Directives)
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// hashDirectives writes the directives to h, each argument and list prefixed by its length so that different trees never write the same bytes.
+func hashDirectives(h hash.Hash, p []Directive) {
+	h.Write(binary.AppendUvarint(nil, uint64(len(p))))
+	for _, d := range p {
+		h.Write(binary.AppendUvarint(nil, uint64(len(d.Arguments))))
+		for _, a := range d.Arguments {
+			h.Write(binary.AppendUvarint(nil, uint64(len(a))))
+			h.Write([]byte(a))
+		}
+		hashDirectives(h, d.Subdirectives)
+	}
+}
@@ -0,0 +1,166 @@
+// Package httploader loads Confetti documents over HTTP, refreshing them with conditional requests so unchanged documents are not downloaded again.
+package httploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	confetti "github.com/Heliodex/confetti"
+)
+
+// Loader fetches the document at a URL, remembering the ETag and Last-Modified headers of the response so later loads only download it again if it changed.
+// It is safe for concurrent use.
+type Loader struct {
+	url    string
+	client *http.Client
+	opts   []confetti.Option
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	doc          *confetti.Document // the last document fetched
+}
+
+// New returns a loader for the document at url, parsed with the given options and named by url unless WithName is given. Requests are made with client, or http.DefaultClient if it is nil.
+func New(url string, client *http.Client, opts ...confetti.Option) *Loader {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Loader{url: url, client: client, opts: append([]confetti.Option{confetti.WithName(url)}, opts...)}
+}
+
+// Load fetches the document, or, if the server reports it unchanged since the last load, returns the document loaded then. Each call returns its own copy of the document, which the caller may modify.
+// Responses other than 200 OK and 304 Not Modified fail, as do documents that do not parse, leaving the last document loaded in place.
+func (l *Loader) Load(ctx context.Context) (confetti.Document, error) {
+	doc, _, err := l.load(ctx)
+	return doc, err
+}
+
+// load fetches the document, reporting whether it was downloaded.
+func (l *Loader) load(ctx context.Context) (confetti.Document, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.url, nil)
+	if err != nil {
+		return confetti.Document{}, false, err
+	}
+	if l.doc != nil {
+		if l.etag != "" {
+			req.Header.Set("If-None-Match", l.etag)
+		}
+		if l.lastModified != "" {
+			req.Header.Set("If-Modified-Since", l.lastModified)
+		}
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return confetti.Document{}, false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && l.doc != nil:
+		return confetti.CloneDocument(*l.doc), false, nil
+	case resp.StatusCode != http.StatusOK:
+		return confetti.Document{}, false, fmt.Errorf("GET %s: %s", l.url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return confetti.Document{}, false, err
+	}
+	doc, err := confetti.Parse(string(data), l.opts...)
+	if err != nil {
+		return confetti.Document{}, false, err
+	}
+
+	l.doc, l.etag, l.lastModified = &doc, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	return confetti.CloneDocument(doc), true, nil
+}
+
+// Watcher reloads a document from a URL when it changes, as confetti.Watcher does for files.
+type Watcher struct {
+	l      *Loader
+	reload func(confetti.Document)
+	opts   confetti.WatcherOptions
+
+	hash   [32]byte // of the last document passed on
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWatcher loads the document from l, calling reload with it, and then polls for changes until Close is called. The options are those of confetti.NewWatcher, but for Options, which l is given instead.
+// When the document is downloaded again, and it matches the schema and has changed other than in formatting or comments, as Document.Hash tells, reload is called with it from the watcher's goroutine.
+// NewWatcher fails without watching if the document cannot be loaded at first, or does not match the schema, even if l already held it.
+func NewWatcher(l *Loader, reload func(confetti.Document), opts confetti.WatcherOptions) (*Watcher, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	w := &Watcher{l: l, reload: reload, opts: opts, done: make(chan struct{})}
+
+	doc, _, err := w.check(context.Background(), true)
+	if err != nil {
+		return nil, err
+	}
+	w.hash = doc.Hash()
+	reload(doc)
+
+	var ctx context.Context
+	ctx, w.cancel = context.WithCancel(context.Background())
+	go w.watch(ctx)
+	return w, nil
+}
+
+// check loads the document, reporting whether it was downloaded, and validates it if it was or if it is the first.
+func (w *Watcher) check(ctx context.Context, first bool) (confetti.Document, bool, error) {
+	doc, changed, err := w.l.load(ctx)
+	if err != nil || !changed && !first || w.opts.Schema == nil {
+		return doc, changed, err
+	}
+
+	var errs []error
+	for _, ve := range doc.Validate(*w.opts.Schema) {
+		errs = append(errs, ve)
+	}
+	return doc, changed, errors.Join(errs...)
+}
+
+func (w *Watcher) watch(ctx context.Context) {
+	defer close(w.done)
+	t := time.NewTicker(w.opts.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		doc, changed, err := w.check(ctx, false)
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			if w.opts.OnError != nil {
+				w.opts.OnError(w.l.url, err)
+			}
+		} else if h := doc.Hash(); changed && h != w.hash {
+			w.hash = h
+			w.reload(doc)
+		}
+	}
+}
+
+// Close stops watching the document, cancelling a request in progress and waiting for a reload in progress to return. It must not be called from reload.
+func (w *Watcher) Close() error {
+	w.cancel()
+	<-w.done
+	return nil
+}
@@ -0,0 +1,120 @@
+package httploader_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	confetti "github.com/Heliodex/confetti"
+	"github.com/Heliodex/confetti/httploader"
+)
+
+// server serves a document, answering conditional requests for its current version with 304 Not Modified.
+type server struct {
+	mu      sync.Mutex
+	body    string
+	version int
+	full    int // responses with the document
+}
+
+func (s *server) set(body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.body = body
+	s.version++
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	etag := strconv.Quote(strconv.Itoa(s.version))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.full++
+	w.Header().Set("ETag", etag)
+	w.Write([]byte(s.body))
+}
+
+func TestLoader(t *testing.T) {
+	s := &server{}
+	s.set("port 80\n")
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	l := httploader.New(ts.URL, nil)
+	for range 3 {
+		doc, err := l.Load(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to load document: %v", err)
+		} else if doc.Name != ts.URL || doc.Directives[0].Arguments[1] != "80" {
+			t.Fatalf("Unexpected document %s:\n%s", doc.Name, doc)
+		}
+		doc.Directives[0].Arguments[1] = "mutated"
+	}
+	if s.full != 1 {
+		t.Fatalf("Expected the document to be downloaded once, got %d", s.full)
+	}
+
+	s.set("port {\n")
+	if _, err := l.Load(context.Background()); err == nil {
+		t.Fatal("Expected an error loading an invalid document")
+	}
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	if _, err := httploader.New(missing.URL, nil).Load(context.Background()); err == nil || err.Error() != "GET "+missing.URL+": 404 Not Found" {
+		t.Fatalf("Expected a 404 error, got %v", err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	s := &server{}
+	s.set("port 80\n")
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	reloads := make(chan string, 10)
+	w, err := httploader.NewWatcher(httploader.New(ts.URL, nil), func(doc confetti.Document) {
+		reloads <- doc.Directives[0].Arguments[1]
+	}, confetti.WatcherOptions{Interval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to watch document: %v", err)
+	}
+	defer w.Close()
+
+	wait := func() string {
+		select {
+		case port := <-reloads:
+			return port
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the watcher")
+			return ""
+		}
+	}
+	if port := wait(); port != "80" {
+		t.Fatalf("Expected the initial document, got port %s", port)
+	}
+	s.set("# reformatted\nport   80\n")
+	s.set("port 8080\n")
+	if port := wait(); port != "8080" {
+		t.Fatalf("Expected the changed document, got port %s", port)
+	}
+
+	// a document the loader already holds is validated too
+	l := httploader.New(ts.URL, nil)
+	if _, err = l.Load(context.Background()); err != nil {
+		t.Fatalf("Failed to load document: %v", err)
+	}
+	schema := &confetti.Schema{Directives: map[string]confetti.DirectiveSchema{
+		"port": {MinArgs: 1, MaxArgs: 1, Args: []confetti.ArgType{confetti.ArgBool}},
+	}}
+	if _, err = httploader.NewWatcher(l, func(confetti.Document) {}, confetti.WatcherOptions{Schema: schema}); err == nil {
+		t.Fatal("Expected a validation error for a cached document")
+	}
+}
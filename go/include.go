@@ -0,0 +1,112 @@
+package confetti
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+)
+
+// include replaces the include directives in p and their subdirectives with the directives of the files they name.
+func (c config) include(p []Directive) ([]Directive, error) {
+	if c.including == nil {
+		// an unnamed source cannot be included again, but still counts towards the depth
+		top := ""
+		if c.fsys != nil {
+			top = path.Clean(c.name)
+		} else if c.name != "" {
+			abs, err := filepath.Abs(c.name)
+			if err != nil {
+				return nil, err
+			}
+			top = abs
+		}
+		c.including = []string{top}
+	}
+
+	var out []Directive
+	for _, d := range p {
+		if d.Name() != "include" {
+			subs, err := c.include(d.Subdirectives)
+			if err != nil {
+				return nil, err
+			}
+			d.Subdirectives = subs
+			out = append(out, d)
+			continue
+		}
+
+		ds, err := c.includeFile(d)
+		if err != nil {
+			return nil, &ParseError{Pos: d.Pos, Token: d.Name(), Err: err}
+		}
+		out = append(out, ds...)
+	}
+	return out, nil
+}
+
+func (c config) includeFile(d Directive) ([]Directive, error) {
+	if len(d.Arguments) != 2 || len(d.Subdirectives) > 0 {
+		return nil, ErrMalformedInclude
+	}
+
+	if c.fsys != nil {
+		return c.includeFS(d.Arguments[1])
+	}
+
+	path := d.Arguments[1]
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(c.name), path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.Contains(c.including, abs) {
+		return nil, fmt.Errorf("%w including %s", ErrIncludeCycle, path)
+	} else if len(c.including) > c.includeDepth {
+		return nil, fmt.Errorf("%w including %s", ErrIncludeDepth, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.parseIncluded(path, abs, data)
+}
+
+// includeFS reads the file at name, relative to the directory of the including source, from c.fsys.
+func (c config) includeFS(name string) ([]Directive, error) {
+	if !path.IsAbs(name) {
+		name = path.Join(path.Dir(c.name), name)
+	}
+	if !fs.ValidPath(name) {
+		return nil, fmt.Errorf("cannot include %s from outside the file system", name)
+	}
+
+	if slices.Contains(c.including, name) {
+		return nil, fmt.Errorf("%w including %s", ErrIncludeCycle, name)
+	} else if len(c.including) > c.includeDepth {
+		return nil, fmt.Errorf("%w including %s", ErrIncludeDepth, name)
+	}
+
+	data, err := fs.ReadFile(c.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return c.parseIncluded(name, name, data)
+}
+
+// parseIncluded parses the included file data, named name, whose absolute path is abs.
+func (c config) parseIncluded(name, abs string, data []byte) ([]Directive, error) {
+	c.name = name
+	c.including = append(c.including[:len(c.including):len(c.including)], abs)
+	doc, err := parseSource(string(data), c)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Directives, nil
+}
@@ -7,6 +7,8 @@ import (
 	"strings"
 	"unicode"
 	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 var lineTerminators = []rune{0x0a, 0x0b, 0x0c, 0x0d, 0x85, 0x2028, 0x2029}
@@ -47,61 +49,144 @@ func isReserved(r rune, exts Extensions) bool {
 		exts.Has(ExtExpressionArguments) && r == '('
 }
 
+// stream reads a source one character at a time, without copying it.
 type stream struct {
-	src []rune
-	pos int
+	src string
+	pos int // byte offset of the current character
+
+	// position of src[at]
+	at   int
+	here Position
+}
+
+// position returns the position of the current character.
+func (s *stream) position() Position {
+	for s.at < s.pos && s.at < len(s.src) {
+		r, size := utf8.DecodeRuneInString(s.src[s.at:])
+		if s.at += size; isLineTerminator(r) && (r != '\r' || !strings.HasPrefix(s.src[s.at:], "\n")) {
+			s.here.Line++
+			s.here.Column = 1
+		} else {
+			s.here.Column++
+		}
+		s.here.Offset += size
+	}
+	return s.here
 }
 
 func (s *stream) reading() bool {
 	return s.pos < len(s.src)
 }
 
-var errForbidden = errors.New("illegal character")
-
 func (s *stream) current() (c rune, err error) {
 	if s.pos >= len(s.src) {
 		return 0, errors.New("EOF")
-	} else if c = s.src[s.pos]; isForbidden(c) {
+	} else if c, _ = utf8.DecodeRuneInString(s.src[s.pos:]); isForbidden(c) {
 		// get illegal character as U+XXXX
 		if c < 0x10000 {
-			return 0, fmt.Errorf("%w U+%04X", errForbidden, c)
+			return 0, fmt.Errorf("%w U+%04X", ErrIllegalCharacter, c)
 		}
-		return 0, fmt.Errorf("%w U+%X", errForbidden, c)
+		return 0, fmt.Errorf("%w U+%X", ErrIllegalCharacter, c)
 	}
 
 	return
 }
 
+// increment moves forward n characters.
 func (s *stream) increment(n int) {
-	s.pos += n
+	for range n {
+		_, size := utf8.DecodeRuneInString(s.src[s.pos:])
+		s.pos += max(size, 1)
+	}
 }
 
+// next returns the character n characters ahead, or 0 if there is none.
 func (s *stream) next(n int) rune {
-	if i := s.pos + n; i < len(s.src) {
-		return s.src[i]
+	i := s.pos
+	for range n {
+		if i >= len(s.src) {
+			return 0
+		}
+		_, size := utf8.DecodeRuneInString(s.src[i:])
+		i += size
 	}
-	return 0
+	if i >= len(s.src) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(s.src[i:])
+	return r
 }
 
-type tokenType uint8
+// TokenKind identifies the lexical class of a Token.
+type TokenKind uint8
 
 const (
-	tokUnicode tokenType = iota
-	tok0qArgument
-	tok1qArgument
-	tok3qArgument
-	tokNewline
-	tokLineContinuation
-	tokWhitespace
-	tokComment
-	tokSemicolon
-	tokOpenBrace
-	tokCloseBrace
+	TokenUnicode              TokenKind = iota // byte order mark or trailing ^Z
+	TokenArgument                              // unquoted argument
+	TokenQuotedArgument                        // "quoted" argument
+	TokenTripleQuotedArgument                  // """triple quoted""" argument
+	TokenPunctuator                            // punctuator argument
+	TokenNewline
+	TokenContinuation
+	TokenWhitespace
+	TokenComment
+	TokenSemicolon
+	TokenOpenBrace
+	TokenCloseBrace
 )
 
-type token struct {
-	Type        tokenType
-	Content, Og string
+var tokenKinds = [...]string{
+	TokenUnicode:              "unicode",
+	TokenArgument:             "argument",
+	TokenQuotedArgument:       "quoted argument",
+	TokenTripleQuotedArgument: "triple quoted argument",
+	TokenPunctuator:           "punctuator",
+	TokenNewline:              "newline",
+	TokenContinuation:         "line continuation",
+	TokenWhitespace:           "whitespace",
+	TokenComment:              "comment",
+	TokenSemicolon:            "semicolon",
+	TokenOpenBrace:            "open brace",
+	TokenCloseBrace:           "close brace",
+}
+
+func (k TokenKind) String() string {
+	if int(k) < len(tokenKinds) {
+		return tokenKinds[k]
+	}
+	return fmt.Sprintf("TokenKind(%d)", k)
+}
+
+// Token is a lexical token read from a source.
+type Token struct {
+	Kind TokenKind
+	// Text is the token as written in the source, and Value is its content, such as an argument with quotes and escapes removed or a comment without its delimiters.
+	Text, Value string
+	Pos, End    Position
+}
+
+func (t Token) String() string {
+	return fmt.Sprintf("%s: %s %q", t.Pos, t.Kind, t.Text)
+}
+
+// normalize applies the normalization n to the content of the argument tokens in ts.
+func normalize(ts []Token, n Normalization) error {
+	if n == NormalizeNone {
+		return nil
+	}
+
+	for i, t := range ts {
+		switch t.Kind {
+		case TokenArgument, TokenQuotedArgument, TokenTripleQuotedArgument, TokenPunctuator:
+			if norm.NFC.IsNormalString(t.Value) {
+				continue
+			} else if n == RequireNFC {
+				return tokenError(t, ErrNotNormalized)
+			}
+			ts[i].Value = norm.NFC.String(t.Value)
+		}
+	}
+	return nil
 }
 
 // A directive “argument” shall be a sequence of one or more characters from the argument character set. The argument character set shall consist of any Unicode scalar value excluding characters from the white space, line terminator, reserved punctuator, and forbidden character sets.
@@ -117,12 +202,6 @@ func tripleQuotedArgumentOk(r rune) bool {
 	return r != '"'
 }
 
-var (
-	errIncompleteEscape = errors.New("incomplete escape sequence")
-	errIllegalEscape    = errors.New("illegal escape character")
-	errUnclosedQuoted   = errors.New("unclosed quoted")
-)
-
 func checkEscape(s *stream, c rune, quoted uint8) (r rune, escaped bool, err error) {
 	if c != '\\' {
 		return c, false, nil
@@ -130,182 +209,310 @@ func checkEscape(s *stream, c rune, quoted uint8) (r rune, escaped bool, err err
 
 	s.increment(1)
 	if c, err = s.current(); err != nil {
-		if errors.Is(err, errForbidden) || quoted == 0 {
-			return 0, false, errIllegalEscape
+		if errors.Is(err, ErrIllegalCharacter) || quoted == 0 {
+			return 0, false, ErrIllegalEscape
 		}
-		return 0, false, errIncompleteEscape
+		return 0, false, ErrIncompleteEscape
 	} else if isWhitespace(c) || isLineTerminator(c) {
 		if quoted == 3 {
 			if isLineTerminator(c) {
-				return 0, false, errIncompleteEscape
+				return 0, false, ErrIncompleteEscape
 			}
-			return 0, false, errIllegalEscape
+			return 0, false, ErrIllegalEscape
 		} else if quoted == 0 || (quoted == 1 && !isLineTerminator(c)) {
-			return 0, false, errIllegalEscape
+			return 0, false, ErrIllegalEscape
+		}
+		if c == '\r' && s.next(1) == '\n' {
+			s.increment(1) // CR LF is a single line terminator
 		}
 		return 0, true, nil // r = 0 used to signify line terminator
 	}
 	return c, true, nil
 }
 
-func getPunctuator(s *stream, ps string) (l int) {
-	ps = strings.ReplaceAll(ps, "\r\n", "\n")
-	ps = strings.ReplaceAll(ps, "\r", "\n")
-	ps = strings.TrimSpace(ps)
+// punctuators parses the value of ExtPunctuatorArguments, one punctuator per line, into a list with the longest first.
+func punctuators(spec string) (puncts []string) {
+	spec = strings.ReplaceAll(spec, "\r\n", "\n")
+	spec = strings.ReplaceAll(spec, "\r", "\n")
+	spec = strings.TrimSpace(spec)
 
-	puncts := strings.Split(ps, "\n")
-	// sort puncts by length descending
-	slices.SortFunc(puncts, func(a, b string) int {
-		return len([]rune(b)) - len([]rune(a)) // footgun: len() counts bytes for strings
+	for p := range strings.SplitSeq(spec, "\n") {
+		if p != "" {
+			puncts = append(puncts, p)
+		}
+	}
+	slices.SortStableFunc(puncts, func(a, b string) int {
+		return utf8.RuneCountInString(b) - utf8.RuneCountInString(a)
 	})
+	return
+}
 
+// getPunctuator returns the length in bytes of the punctuator at the current position, or 0.
+func getPunctuator(s *stream, puncts []string) int {
+	return punctuatorPrefix(s.src[s.pos:], puncts)
+}
+
+// punctuatorPrefix returns the length in bytes of the punctuator at the start of s, or 0.
+func punctuatorPrefix(s string, puncts []string) int {
 	for _, p := range puncts {
-		rest := s.src[s.pos:]
-		if l = len([]rune(p)); l <= len(rest) && string(rest[:l]) == p {
-			return
+		if strings.HasPrefix(s, p) {
+			return len(p)
 		}
 	}
+	return 0
+}
 
+// hookedArgument returns the length in bytes of an argument read by an extension's hook at the current position, or 0.
+func hookedArgument(s *stream, hooks []ExtensionHooks, values []string) int {
+	for i, h := range hooks {
+		if h.Argument == nil {
+			continue
+		}
+
+		rest := s.src[s.pos:]
+		n := min(h.Argument(rest, values[i]), len(rest))
+		for n > 0 && n < len(rest) && !utf8.RuneStart(rest[n]) {
+			n-- // an argument cannot end within a character
+		}
+		if n > 0 {
+			return n
+		}
+	}
 	return 0
 }
 
-func lex0qArgument(s *stream, exts Extensions) (arg, ogarg []rune, err error) {
+// unescape returns the content of a quoted argument from its source text, which must be well formed. Escaped line terminators are removed.
+func unescape(og string) string {
+	if !strings.Contains(og, "\\") {
+		return og
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(og); {
+		r, size := utf8.DecodeRuneInString(og[i:])
+		if i += size; r == '\\' && i < len(og) {
+			r, size = utf8.DecodeRuneInString(og[i:])
+			if i += size; isLineTerminator(r) {
+				if r == '\r' && strings.HasPrefix(og[i:], "\n") {
+					i++
+				}
+				continue
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// lex0qArgument reads an unquoted argument, returning its content.
+func lex0qArgument(s *stream, exts Extensions, puncts []string) (string, error) {
+	// the content is only copied once an escape is found
+	var b strings.Builder
+	start, from := s.pos, s.pos
 	for s.reading() {
 		c, err := s.current()
 		if err != nil {
-			return nil, nil, err
-		} else if !argumentOk(c, exts) ||
-			(exts.Has(ExtPunctuatorArguments) &&
-				getPunctuator(s, exts[ExtPunctuatorArguments]) != 0) {
-			return arg, ogarg, nil
+			return "", err
+		} else if !argumentOk(c, exts) || getPunctuator(s, puncts) != 0 {
+			break
 		}
 
+		if exts.Has(ExtVariables) && c == '$' && s.next(1) == '{' {
+			// variable references may contain braces
+			if err := lexVariableReference(s); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		at := s.pos
 		ec, escd, err := checkEscape(s, c, 0)
 		if err != nil {
-			return nil, nil, err
-		} else if escd {
-			ogarg = append(ogarg, '\\')
+			return "", err
 		}
-
-		arg = append(arg, ec)
-		ogarg = append(ogarg, ec)
 		s.increment(1)
+		if escd {
+			b.WriteString(s.src[from:at])
+			b.WriteRune(ec)
+			from = s.pos
+		}
 	}
 
-	return
+	if from == start {
+		return s.src[start:s.pos], nil
+	}
+	b.WriteString(s.src[from:s.pos])
+	return b.String(), nil
 }
 
-func lex1qArgument(s *stream) (arg, ogarg []rune, err error) {
+func lexVariableReference(s *stream) error {
 	for ; s.reading(); s.increment(1) {
 		c, err := s.current()
-		if errors.Is(err, errForbidden) {
-			return nil, nil, errForbidden
+		if errors.Is(err, ErrIllegalCharacter) {
+			return ErrIllegalCharacter
+		} else if err != nil || isLineTerminator(c) {
+			break
+		} else if c == '}' {
+			s.increment(1)
+			return nil
+		}
+	}
+	return ErrUnclosedReference
+}
+
+// lex1qArgument reads a quoted argument after its opening quote, returning its source text without quotes.
+func lex1qArgument(s *stream) (og string, err error) {
+	for start := s.pos; s.reading(); s.increment(1) {
+		c, err := s.current()
+		if errors.Is(err, ErrIllegalCharacter) {
+			return "", ErrIllegalCharacter
 		} else if !quotedArgumentOk(c) {
 			if c != '"' {
-				return nil, nil, errUnclosedQuoted
+				return "", ErrUnclosedQuoted
 			}
 
+			og = s.src[start:s.pos]
 			s.increment(1)
-			return arg, ogarg, nil
-		}
-
-		ec, escd, err := checkEscape(s, c, 1)
-		if err != nil {
-			return nil, nil, err
-		} else if escd {
-			ogarg = append(ogarg, '\\')
+			return og, nil
 		}
 
-		if ec == 0 {
-			// escaped line terminators allowed in quoted arguments
-			nc, _ := s.current()
-			ogarg = append(ogarg, nc)
-			continue
+		if _, _, err = checkEscape(s, c, 1); err != nil {
+			return "", err
 		}
-		arg = append(arg, ec)
-		ogarg = append(ogarg, ec)
 	}
 
-	return nil, nil, errUnclosedQuoted
+	return "", ErrUnclosedQuoted
 }
 
-func lex3qArgument(s *stream) (arg, ogarg []rune, err error) {
-	for endsMatched := 0; s.reading(); {
+// lex3qArgument reads a triple quoted argument after its opening quotes, returning its source text without quotes.
+func lex3qArgument(s *stream) (og string, err error) {
+	for start, endsMatched := s.pos, 0; s.reading(); {
 		c, err := s.current()
-		if errors.Is(err, errForbidden) {
-			return nil, nil, errForbidden
+		if errors.Is(err, ErrIllegalCharacter) {
+			return "", ErrIllegalCharacter
 		} else if !tripleQuotedArgumentOk(c) {
 			if c != '"' {
-				return nil, nil, errUnclosedQuoted
+				return "", ErrUnclosedQuoted
 			}
 
-			ogarg = append(ogarg, c)
 			s.increment(1)
-
 			if endsMatched == 2 {
-				return arg, ogarg[:len(ogarg)-3], nil
+				return s.src[start : s.pos-3], nil
 			}
 			endsMatched++
 			continue
 		} else if endsMatched > 0 {
-			arg = append(arg, slices.Repeat([]rune{'"'}, endsMatched)...)
 			endsMatched = 0
 			continue
 		}
 
-		ec, escd, err := checkEscape(s, c, 3)
-		if err != nil {
-			return nil, nil, err
-		} else if escd {
-			ogarg = append(ogarg, '\\')
+		if _, _, err = checkEscape(s, c, 3); err != nil {
+			return "", err
 		}
-
-		arg = append(arg, ec)
-		ogarg = append(ogarg, ec)
 		s.increment(1)
 	}
 
-	return nil, nil, errUnclosedQuoted
+	return "", ErrUnclosedQuoted
+}
+
+func lex(src string, exts Extensions) (ts []Token, err error) {
+	if err = lexEach(src, exts, func(t Token) bool {
+		ts = append(ts, t)
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	return
 }
 
-func lex(src string, exts Extensions) (ts []token, err error) {
+// lexEach passes each token of src to yield as it is read, stopping early if yield returns false.
+func lexEach(src string, exts Extensions, yield func(Token) bool) (err error) {
 	if !utf8.ValidString(src) {
-		return nil, errors.New("malformed UTF-8")
+		return &ParseError{Err: ErrMalformedUTF8}
 	}
 
+	start := Position{Line: 1, Column: 1}
+
 	// remove BOMs
-	if strings.HasPrefix(src, "\ufeff") {
-		ts = append(ts, token{Type: tokUnicode, Content: "\ufeff"})
-		src = src[3:]
-	} else if strings.HasPrefix(src, "\ufffe") {
-		ts = append(ts, token{Type: tokUnicode, Content: "\ufffe"})
-		src = src[3:]
+	if strings.HasPrefix(src, "\ufeff") || strings.HasPrefix(src, "\ufffe") {
+		end := Position{Offset: 3, Line: 1, Column: 1}
+		if !yield(Token{Kind: TokenUnicode, Text: src[:3], Value: src[:3], Pos: start, End: end}) {
+			return nil
+		}
+		src, start = src[3:], end
 	}
+	_, err = lexAt(src, start, exts, yield)
+	return
+}
+
+// lexAt lexes src as if it began at start, with no byte order mark, returning the position at its end.
+func lexAt(src string, start Position, exts Extensions, yield func(Token) bool) (end Position, err error) {
+	s := stream{here: start}
+	var tok Position // of the start of the token being read
+	defer func() {
+		if err != nil {
+			pe := &ParseError{Pos: s.position(), Err: err}
+			if errors.Is(err, ErrUnclosedQuoted) || errors.Is(err, ErrUnterminatedComment) || errors.Is(err, ErrIncompleteExpression) {
+				pe.Start = tok
+			}
+			if s.reading() {
+				r, _ := utf8.DecodeRuneInString(s.src[s.pos:])
+				pe.Token = string(r)
+			}
+			err = pe
+		}
+	}()
 
 	// remove ^Z
-	if strings.HasSuffix(src, "\u001a") {
-		defer func() {
-			ts = append(ts, token{Type: tokUnicode, Content: "\u001a"})
-		}()
+	eof := strings.HasSuffix(src, "\u001a")
+	if eof {
 		src = src[:len(src)-1]
 	}
 
 	// check for forbidden characters must be done based on token/location
 
-	for s := (stream{src: []rune(src)}); s.reading(); {
+	hooks, values := exts.hooks()
+
+	var puncts []string
+	if exts.Has(ExtPunctuatorArguments) {
+		puncts = punctuators(exts[ExtPunctuatorArguments])
+	}
+
+	for s.src = src; s.reading(); {
 		c, err := s.current()
 		if err != nil {
-			break
+			return end, err
 		}
 
-		switch op := s.pos; {
+		pos := s.position()
+		tok = pos
+		hooked := hookedArgument(&s, hooks, values)
+
+		var t Token
+		op := s.pos
+		switch {
 		case isLineTerminator(c):
+			// CR LF is a single line terminator
+			if c == '\r' && s.next(1) == '\n' {
+				s.increment(1)
+			}
 			s.increment(1)
-			ts = append(ts, token{Type: tokNewline, Content: string(c)})
+			t = Token{Kind: TokenNewline, Value: s.src[op:s.pos]}
 
 		case isWhitespace(c):
 			s.increment(1)
-			ts = append(ts, token{Type: tokWhitespace, Content: string(c)})
+			t = Token{Kind: TokenWhitespace, Value: s.src[op:s.pos]}
+
+		case hooked > 0:
+			// argument read by a registered extension
+			content := s.src[op : op+hooked]
+			if i := strings.IndexFunc(content, isForbidden); i >= 0 {
+				s.pos += i
+				_, err = s.current()
+				return end, err
+			}
+			s.pos += hooked
+			t = Token{Kind: TokenArgument, Value: content}
 
 		case
 			exts.Has(ExtCStyleComments) &&
@@ -314,27 +521,25 @@ func lex(src string, exts Extensions) (ts []token, err error) {
 			// C-style comment
 			for s.increment(1); ; {
 				s.increment(1)
-				if c, err = s.current(); errors.Is(err, errForbidden) {
-					return nil, errForbidden
+				if c, err = s.current(); errors.Is(err, ErrIllegalCharacter) {
+					return end, ErrIllegalCharacter
 				} else if err != nil || isLineTerminator(c) {
 					break
 				}
 			}
-			content := string(s.src[op+2 : s.pos])
-			ts = append(ts, token{Type: tokComment, Content: content, Og: "//" + content})
+			t = Token{Kind: TokenComment, Value: s.src[op+2 : s.pos]}
 
 		case c == '#':
 			// comment until end of line
 			for {
 				s.increment(1)
-				if c, err = s.current(); errors.Is(err, errForbidden) {
-					return nil, errForbidden
+				if c, err = s.current(); errors.Is(err, ErrIllegalCharacter) {
+					return end, ErrIllegalCharacter
 				} else if err != nil || isLineTerminator(c) {
 					break
 				}
 			}
-			content := string(s.src[op+1 : s.pos])
-			ts = append(ts, token{Type: tokComment, Content: content, Og: "#" + content})
+			t = Token{Kind: TokenComment, Value: s.src[op+1 : s.pos]}
 
 		case
 			exts.Has(ExtCStyleComments) &&
@@ -343,42 +548,44 @@ func lex(src string, exts Extensions) (ts []token, err error) {
 			// block comment
 			for s.increment(1); ; {
 				s.increment(1)
-				if c, err = s.current(); errors.Is(err, errForbidden) {
-					return nil, errForbidden
+				if c, err = s.current(); errors.Is(err, ErrIllegalCharacter) {
+					return end, ErrIllegalCharacter
 				} else if err != nil {
-					return nil, errors.New("unterminated multi-line comment")
+					return end, ErrUnterminatedComment
 				} else if c == '*' && s.next(1) == '/' {
 					break
 				}
 			}
-			content := string(s.src[op+2 : s.pos])
-			ts = append(ts, token{Type: tokComment, Content: content, Og: "/*" + content + "*/"})
 			s.increment(2) // */
+			t = Token{Kind: TokenComment, Value: s.src[op+2 : s.pos-2]}
 
 		case c == ';':
 			s.increment(1)
-			ts = append(ts, token{Type: tokSemicolon})
+			t = Token{Kind: TokenSemicolon, Value: ";"}
 
 		case c == '{':
 			s.increment(1)
-			ts = append(ts, token{Type: tokOpenBrace})
+			t = Token{Kind: TokenOpenBrace, Value: "{"}
 
 		case c == '}':
 			s.increment(1)
-			ts = append(ts, token{Type: tokCloseBrace})
+			t = Token{Kind: TokenCloseBrace, Value: "}"}
 
 		case c == '\\' && isLineTerminator(s.next(1)):
+			if s.next(1) == '\r' && s.next(2) == '\n' {
+				s.increment(1)
+			}
 			s.increment(2)
-			ts = append(ts, token{Type: tokLineContinuation})
+			t = Token{Kind: TokenContinuation, Value: s.src[op+1 : s.pos]}
 
 		case exts.Has(ExtExpressionArguments) && c == '(':
 			// read until corresponding closing parenthesis
 			for depth := 0; ; {
 				s.increment(1)
-				if c, err = s.current(); errors.Is(err, errForbidden) {
-					return nil, errForbidden
+				if c, err = s.current(); errors.Is(err, ErrIllegalCharacter) {
+					return end, ErrIllegalCharacter
 				} else if err != nil || isLineTerminator(c) {
-					return nil, errors.New("incomplete expression")
+					return end, ErrIncompleteExpression
 				} else if c == '(' {
 					depth++
 				} else if c == ')' {
@@ -388,43 +595,54 @@ func lex(src string, exts Extensions) (ts []token, err error) {
 					depth--
 				}
 			}
-			content := string(s.src[op+1 : s.pos])
-			ts = append(ts, token{Type: tok0qArgument, Content: content, Og: "(" + content + ")"})
 			s.increment(1) // )
+			t = Token{Kind: TokenArgument, Value: s.src[op+1 : s.pos-1]}
 
-		case exts.Has(ExtPunctuatorArguments) && getPunctuator(&s, exts[ExtPunctuatorArguments]) != 0:
+		case getPunctuator(&s, puncts) != 0:
 			// read punctuator as argument
-			s.increment(getPunctuator(&s, exts[ExtPunctuatorArguments]))
-			content := string(s.src[op:s.pos])
-			ts = append(ts, token{Type: tok0qArgument, Content: content, Og: content})
+			s.pos += getPunctuator(&s, puncts)
+			content := s.src[op:s.pos]
+			t = Token{Kind: TokenPunctuator, Value: content}
 
 		case c == '"' && s.next(1) == '"' && s.next(2) == '"':
 			// triple quoted argument
 			s.increment(3)
-			arg, ogarg, err := lex3qArgument(&s)
+			og, err := lex3qArgument(&s)
 			if err != nil {
-				return nil, err
+				return end, err
 			}
-			ts = append(ts, token{Type: tok3qArgument, Content: string(arg), Og: string(ogarg)})
+			t = Token{Kind: TokenTripleQuotedArgument, Value: unescape(og)}
 
 		case c == '"':
 			// quoted argument
 			s.increment(1)
-			arg, ogarg, err := lex1qArgument(&s)
+			og, err := lex1qArgument(&s)
 			if err != nil {
-				return nil, err
+				return end, err
 			}
-			ts = append(ts, token{Type: tok1qArgument, Content: string(arg), Og: string(ogarg)})
+			t = Token{Kind: TokenQuotedArgument, Value: unescape(og)}
 
 		default:
 			// unquoted argument
-			arg, ogarg, err := lex0qArgument(&s, exts)
+			arg, err := lex0qArgument(&s, exts, puncts)
 			if err != nil {
-				return nil, err
+				return end, err
 			}
-			ts = append(ts, token{Type: tok0qArgument, Content: string(arg), Og: string(ogarg)})
+			t = Token{Kind: TokenArgument, Value: arg}
+		}
+
+		t.Text, t.Pos, t.End = s.src[op:s.pos], pos, s.position()
+		if !yield(t) {
+			return end, nil
 		}
 	}
 
+	end = s.position()
+	if eof {
+		pos := end
+		end.Offset++
+		end.Column++
+		yield(Token{Kind: TokenUnicode, Text: "\u001a", Value: "\u001a", Pos: pos, End: end})
+	}
 	return
 }
@@ -1,25 +1,29 @@
 package confetti_test
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
 
 	confetti "github.com/Heliodex/confetti"
 )
 
-func printDirective(d confetti.Directive, depth int) {
-	prefix := strings.Repeat("  ", depth)
-
-	fmt.Println(prefix + "Directive:")
-	for _, arg := range d.Arguments {
-		fmt.Printf(prefix+"  %q\n", arg)
-	}
-	for _, sub := range d.Subdirectives {
-		printDirective(sub, depth+1)
-	}
-}
-
 type LibraryTest struct {
 	Input      string
 	Extensions confetti.Extensions
@@ -61,18 +65,2166 @@ echo msg
 
 func TestLibrary(t *testing.T) {
 	for _, test := range tests {
-		dirs, err := confetti.Load(test.Input, test.Extensions)
+		doc, err := confetti.Load(test.Input, confetti.WithExtensions(test.Extensions))
 		if err != nil {
 			t.Fatalf("Failed to load configuration: %v", err)
 		}
 
-		for i, d := range dirs {
-			fmt.Printf("Directive %d:\n", i)
-			printDirective(d, 0)
-
+		if err = confetti.Dump(os.Stdout, doc, confetti.DumpOptions{}); err != nil {
+			t.Fatalf("Failed to dump configuration: %v", err)
+		}
+		for i, d := range doc.Directives {
 			if !d.Equals(test.Output[i]) {
 				t.Fatalf("Directive mismatch at index %d\nExpected:\n%v\nGot:\n%v", i, test.Output[i], d)
 			}
 		}
 	}
 }
+
+func TestDump(t *testing.T) {
+	doc, err := confetti.Load("name \"my app\"\nserver {\n    root \"\"\"/srv\"\"\"\n}\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	var b strings.Builder
+	if err = confetti.Dump(&b, doc, confetti.DumpOptions{}); err != nil {
+		t.Fatalf("Failed to dump configuration: %v", err)
+	}
+	const plain = `Directive:
+  "name"
+  "my app"
+Directive:
+  "server"
+  Directive:
+    "root"
+    "/srv"
+`
+	if b.String() != plain {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", plain, b.String())
+	}
+
+	b.Reset()
+	if err = confetti.Dump(&b, doc, confetti.DumpOptions{ShowQuoting: true, ShowPositions: true}); err != nil {
+		t.Fatalf("Failed to dump configuration: %v", err)
+	}
+	const detailed = `Directive 1:1-1:14:
+  "name" (unquoted)
+  "my app" (quoted)
+Directive 2:1-4:2:
+  "server" (unquoted)
+  Directive 3:5-3:20:
+    "root" (unquoted)
+    "/srv" (triple quoted)
+`
+	if b.String() != detailed {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", detailed, b.String())
+	}
+}
+
+func TestToDOT(t *testing.T) {
+	doc, err := confetti.Parse("name \"my app\"\nserver {\n    root /srv\n}\n", confetti.WithName("app.conf"))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	var b strings.Builder
+	if err = doc.ToDOT(&b); err != nil {
+		t.Fatalf("Failed to write graph: %v", err)
+	}
+	const expected = `digraph confetti {
+	node [shape=box];
+	n0 [label="app.conf", shape=ellipse];
+	n1 [label="name \"my app\""];
+	n0 -> n1;
+	n2 [label="server"];
+	n0 -> n2;
+	n3 [label="root /srv"];
+	n2 -> n3;
+}
+`
+	if b.String() != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, b.String())
+	}
+}
+
+func TestStats(t *testing.T) {
+	const src = "name app\nserver {\n    listen 80 443\n    tls { cert a.pem }\n}\n"
+	doc, err := confetti.Load(src)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	s := doc.Stats()
+	if s.Directives != 5 || s.Depth != 3 || s.Arguments != 9 || s.Bytes != len(src) {
+		t.Fatalf("Unexpected statistics %+v", s)
+	} else if !maps.Equal(s.ArgumentCounts, map[int]int{1: 2, 2: 2, 3: 1}) {
+		t.Fatalf("Unexpected argument counts %v", s.ArgumentCounts)
+	}
+
+	doc = confetti.Document{Directives: doc.Directives[:1]}
+	if s = doc.Stats(); s.Bytes != len("name app\n") || s.Depth != 1 {
+		t.Fatalf("Unexpected statistics %+v", s)
+	} else if s = (confetti.Document{}).Stats(); s.Directives != 0 || s.Depth != 0 {
+		t.Fatalf("Unexpected statistics %+v", s)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	doc, err := confetti.Load(`a 1
+b { c; c; d }
+a 1
+b { c; d }
+`)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	out, dropped := confetti.Dedupe(doc.Directives, confetti.KeepFirst)
+	if len(out) != 2 {
+		t.Fatalf("Expected 2 directives, got %d", len(out))
+	} else if len(out[1].Subdirectives) != 2 {
+		t.Fatalf("Expected 2 subdirectives, got %d", len(out[1].Subdirectives))
+	} else if len(dropped) != 3 {
+		t.Fatalf("Expected 3 dropped directives, got %d", len(dropped))
+	}
+
+	if _, dropped = confetti.Dedupe(doc.Directives, confetti.KeepLast); !slices.Equal(dropped[len(dropped)-1].KeptPath, []int{2}) {
+		t.Fatalf("Expected duplicate of [2], got %v", dropped[len(dropped)-1].KeptPath)
+	}
+}
+
+func TestCommentOut(t *testing.T) {
+	const src = "a 1\nserver {\n    listen 80\n}\nb 2\n"
+	const commented = "a 1\n# server {\n#     listen 80\n# }\nb 2\n"
+
+	out, err := confetti.CommentOut(src, []int{1})
+	if err != nil {
+		t.Fatalf("Failed to comment out directive: %v", err)
+	} else if out != commented {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", commented, out)
+	}
+
+	if out, err = confetti.Uncomment(out, 2); err != nil {
+		t.Fatalf("Failed to uncomment directive: %v", err)
+	} else if out != src {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", src, out)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	doc, err := confetti.Load("database {\n    user admin\n    password hunter2\n}\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	h := confetti.NewHandler(func() confetti.Document { return doc }, confetti.HandlerOptions{
+		Redact: func(key string) bool { return key == "database.password" },
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/config?format=flat", nil))
+
+	const expected = "database.user = admin\ndatabase.password = REDACTED\n"
+	if out := rec.Body.String(); out != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, out)
+	}
+}
+
+func TestCachedParseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.conf")
+	write := func(src string, mtime time.Time) {
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		} else if err = os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	write("port 80\n", now)
+	doc, err := confetti.CachedParseFile(path)
+	if err != nil {
+		t.Fatalf("Failed to parse file: %v", err)
+	}
+	doc.Directives[0].Arguments[1] = "mutated"
+
+	if doc, err = confetti.CachedParseFile(path); err != nil {
+		t.Fatalf("Failed to parse file: %v", err)
+	} else if doc.Directives[0].Arguments[1] != "80" {
+		t.Fatalf("Cached result was modified by the caller: %q", doc.Directives[0].Arguments[1])
+	}
+
+	write("port 8080\n", now.Add(time.Second))
+	if doc, err = confetti.CachedParseFile(path); err != nil {
+		t.Fatalf("Failed to parse file: %v", err)
+	} else if doc.Directives[0].Arguments[1] != "8080" {
+		t.Fatalf("Cache was not invalidated: %q", doc.Directives[0].Arguments[1])
+	} else if doc.Name != path {
+		t.Fatalf("Expected document name %q, got %q", path, doc.Name)
+	}
+}
+
+func TestFromValue(t *testing.T) {
+	dirs, err := confetti.FromValue(map[string]any{
+		"name":    "example",
+		"debug":   true,
+		"ports":   []int{80, 443},
+		"server":  map[string]any{"host": "localhost"},
+		"backend": []any{map[string]any{"weight": 1.5}, map[string]any{"weight": 2}},
+		"empty":   nil,
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert value: %v", err)
+	}
+
+	expected, err := confetti.Load(`backend { weight 1.5 }
+backend { weight 2 }
+debug true
+empty
+name example
+ports 80 443
+server { host localhost }
+`)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	} else if len(dirs) != len(expected.Directives) {
+		t.Fatalf("Expected %d directives, got %d", len(expected.Directives), len(dirs))
+	}
+
+	for i, d := range dirs {
+		if !d.Equals(expected.Directives[i]) {
+			t.Fatalf("Directive mismatch at index %d\nExpected:\n%v\nGot:\n%v", i, expected.Directives[i], d)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.conf")
+	now := time.Now()
+	write := func(src string, age int) {
+		mtime := now.Add(time.Duration(age) * time.Second)
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		} else if err = os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait := func(c <-chan string) string {
+		select {
+		case v := <-c:
+			return v
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the watcher")
+			return ""
+		}
+	}
+
+	reloads, errs := make(chan string, 10), make(chan string, 10)
+	schema := &confetti.Schema{Directives: map[string]confetti.DirectiveSchema{
+		"port": {MinArgs: 1, MaxArgs: 1, Args: []confetti.ArgType{confetti.ArgInt}, Required: true},
+	}}
+	write("port 80\n", 0)
+	w, err := confetti.NewWatcher([]string{path}, func(doc confetti.Document) {
+		reloads <- doc.Directives[0].Arguments[1]
+	}, confetti.WatcherOptions{
+		Interval: 5 * time.Millisecond,
+		Schema:   schema,
+		OnError:  func(_ string, err error) { errs <- err.Error() },
+	})
+	if err != nil {
+		t.Fatalf("Failed to watch file: %v", err)
+	}
+	defer w.Close()
+	if port := wait(reloads); port != "80" {
+		t.Fatalf("Expected the initial document, got port %s", port)
+	}
+
+	write("# reformatted\nport   80\n", 1)
+	write("port eighty\n", 2)
+	if e := wait(errs); !strings.Contains(e, "port") {
+		t.Fatalf("Expected a validation error, got %s", e)
+	}
+	write("port 8080\n", 3)
+	if port := wait(reloads); port != "8080" {
+		t.Fatalf("Expected the changed document, got port %s", port)
+	}
+
+	if _, err = confetti.NewWatcher([]string{path + ".missing"}, func(confetti.Document) {}, confetti.WatcherOptions{}); err == nil {
+		t.Fatal("Expected an error watching a missing file")
+	}
+}
+
+func TestLoader(t *testing.T) {
+	dir := t.TempDir()
+	system, local := filepath.Join(dir, "system.conf"), filepath.Join(dir, "local.conf")
+	if err := os.WriteFile(system, []byte("port 80\nlog info\n"), 0o644); err != nil {
+		t.Fatal(err)
+	} else if err = os.WriteFile(local, []byte("port 8080\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := confetti.Loader{Paths: []string{system, filepath.Join(dir, "missing.conf"), local}}.Load()
+	if err != nil {
+		t.Fatalf("Failed to load files: %v", err)
+	} else if got := doc.String(); got != "port 8080\nlog info\n" {
+		t.Fatalf("Unexpected merged document:\n%s", got)
+	} else if doc.Directives[0].Pos.Filename != local || doc.Directives[1].Pos.Filename != system {
+		t.Fatalf("Unexpected sources %q and %q", doc.Directives[0].Pos.Filename, doc.Directives[1].Pos.Filename)
+	}
+
+	if err = os.WriteFile(local, []byte("port {\n"), 0o644); err != nil {
+		t.Fatal(err)
+	} else if _, err = (confetti.Loader{Paths: []string{system, local}}).Load(); err == nil || !strings.HasPrefix(err.Error(), local) {
+		t.Fatalf("Expected an error in %s, got %v", local, err)
+	}
+}
+
+func TestParseDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"20-tls.conf":  "tls on\n",
+		"10-base.conf": "port 80\nlog info\n",
+		"README":       "not { configuration\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "old.conf"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := confetti.ParseDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to parse directory: %v", err)
+	} else if got := doc.String(); got != "port 80\nlog info\ntls on\n" {
+		t.Fatalf("Unexpected document:\n%s", got)
+	} else if pos := doc.Directives[2].Pos; pos.Filename != filepath.Join(dir, "20-tls.conf") || pos.Line != 1 {
+		t.Fatalf("Unexpected position %s", pos)
+	}
+
+	if doc, err = (confetti.Loader{Paths: []string{dir}}).Load(); err != nil || len(doc.Directives) != 3 {
+		t.Fatalf("Failed to load directory: %v", err)
+	}
+
+	bad := filepath.Join(dir, "30-bad.conf")
+	if err = os.WriteFile(bad, []byte("a 1\nb {\n"), 0o644); err != nil {
+		t.Fatal(err)
+	} else if _, err = confetti.ParseDir(dir); err == nil || !strings.HasPrefix(err.Error(), bad+":") {
+		t.Fatalf("Expected an error in %s, got %v", bad, err)
+	}
+}
+
+func TestEnvOverlay(t *testing.T) {
+	doc, err := confetti.Load("server {\n    port 80\n    max-connections 100\n}\nhosts a\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	out, unused, err := confetti.EnvOverlay(doc, "APP", []string{
+		"APP_SERVER_PORT=9090",
+		"APP_SERVER_MAX_CONNECTIONS=500",
+		`APP_HOSTS=b "c d"`,
+		"APP_SERVER_TLS=on",
+		"HOME=/root",
+	})
+	if err != nil {
+		t.Fatalf("Failed to apply environment: %v", err)
+	} else if got := out.String(); got != "server {\n    port 9090\n    max-connections 500\n}\nhosts b \"c d\"\n" {
+		t.Fatalf("Unexpected document:\n%s", got)
+	} else if !slices.Equal(unused, []string{"APP_SERVER_TLS"}) {
+		t.Fatalf("Unexpected unused variables %q", unused)
+	} else if doc.Directives[0].Subdirectives[0].Arguments[1] != "80" {
+		t.Fatal("EnvOverlay modified its input")
+	}
+
+	if _, _, err = confetti.EnvOverlay(doc, "APP", []string{"APP_HOSTS=a {"}); err == nil || !strings.HasPrefix(err.Error(), "APP_HOSTS: ") {
+		t.Fatalf("Expected an error for APP_HOSTS, got %v", err)
+	}
+}
+
+func TestBindFlags(t *testing.T) {
+	doc, err := confetti.Load("name example\nverbose\nserver {\n    port 8080\n    timeout 5s\n}\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	name := fs.String("name", "default", "")
+	verbose := fs.Bool("verbose", false, "")
+	port := fs.Int("server.port", 80, "")
+	timeout := fs.Duration("server-timeout", time.Second, "")
+	if err = confetti.BindFlags(fs, doc); err != nil {
+		t.Fatalf("Failed to bind flags: %v", err)
+	} else if err = fs.Parse([]string{"-server.port", "9090"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	if got := fmt.Sprintln(*name, *verbose, *port, *timeout); got != "example true 9090 5s\n" {
+		t.Fatalf("Unexpected flag values %s", got)
+	} else if def := fs.Lookup("server.port").DefValue; def != "8080" {
+		t.Fatalf("Expected the configured default, got %s", def)
+	}
+
+	doc, err = confetti.Load("server { port http }\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	} else if err = confetti.BindFlags(fs, doc); err == nil || !strings.HasPrefix(err.Error(), "1:10: server.port: ") {
+		t.Fatalf("Expected an error setting server.port, got %v", err)
+	}
+}
+
+func TestParseFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.conf":           {Data: []byte("name app\nserver {\n    include conf.d/server.conf\n}\n")},
+		"conf.d/server.conf": {Data: []byte("listen 80\ninclude ../shared.conf\n")},
+		"shared.conf":        {Data: []byte("timeout 5s\n")},
+		"escape.conf":        {Data: []byte("include ../etc/passwd\n")},
+		"absolute.conf":      {Data: []byte("include /etc/passwd\n")},
+	}
+
+	doc, err := confetti.ParseFS(fsys, "app.conf", confetti.WithIncludes(5))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+	timeout, err := doc.Lookup("server.timeout")
+	if err != nil {
+		t.Fatalf("Failed to look up included directive: %v", err)
+	} else if timeout.Pos.String() != "shared.conf:1:1" {
+		t.Fatalf("Expected included directive at shared.conf:1:1, got %s", timeout.Pos)
+	}
+
+	for _, name := range []string{"escape.conf", "absolute.conf"} {
+		if _, err = confetti.ParseFS(fsys, name, confetti.WithIncludes(5)); err == nil || !strings.Contains(err.Error(), "outside the file system") {
+			t.Fatalf("Expected %s to be kept inside the file system, got %v", name, err)
+		}
+	}
+	if _, err = confetti.ParseFS(fsys, "missing.conf"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	load := func(src string) confetti.Document {
+		doc, err := confetti.Load(src)
+		if err != nil {
+			t.Fatalf("Failed to load configuration: %v", err)
+		}
+		return doc
+	}
+
+	var s confetti.Store
+	if doc := s.Load(); len(doc.Directives) != 0 {
+		t.Fatalf("Expected an empty document, got:\n%s", doc)
+	}
+	changes, cancel := s.Subscribe()
+	s.Swap(load("port 80\n"))
+	if old := s.Swap(load("port 8080\n")); old.Directives[0].Arguments[1] != "80" {
+		t.Fatalf("Expected the replaced document, got:\n%s", old)
+	}
+	if doc := <-changes; doc.Directives[0].Arguments[1] != "8080" {
+		t.Fatalf("Expected only the latest document, got:\n%s", doc)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				if i%2 == 0 {
+					s.Swap(load("port " + strconv.Itoa(i) + "\n"))
+				} else if doc := s.Load(); len(doc.Directives) != 1 {
+					t.Errorf("Expected a whole document, got:\n%s", doc)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	cancel()
+	cancel()
+	<-changes
+	if _, ok := <-changes; ok {
+		t.Fatal("Expected the channel to be closed")
+	}
+}
+
+func TestToValue(t *testing.T) {
+	doc, err := confetti.Load(`name example
+ports 80 443
+server { host localhost }
+backend { weight 1 }
+backend { weight 2 }
+`)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	expected := map[string]any{
+		"name":    "example",
+		"ports":   []any{"80", "443"},
+		"server":  map[string]any{"host": "localhost"},
+		"backend": []any{map[string]any{"weight": "1"}, map[string]any{"weight": "2"}},
+	}
+	if v := confetti.ToValue(doc.Directives); !reflect.DeepEqual(v, expected) {
+		t.Fatalf("Value mismatch\nExpected:\n%v\nGot:\n%v", expected, v)
+	}
+
+	if m := doc.ToMap(); !reflect.DeepEqual(m, expected) {
+		t.Fatalf("Map mismatch\nExpected:\n%v\nGot:\n%v", expected, m)
+	} else if data, err := json.Marshal(m); err != nil {
+		t.Fatalf("Failed to encode map: %v", err)
+	} else if s := string(data); s != `{"backend":[{"weight":"1"},{"weight":"2"}],"name":"example","ports":["80","443"],"server":{"host":"localhost"}}` {
+		t.Fatalf("Unexpected JSON: %s", s)
+	}
+
+	back, err := confetti.FromValue(confetti.ToValue(doc.Directives))
+	if err != nil {
+		t.Fatalf("Failed to convert value: %v", err)
+	} else if len(back) != len(doc.Directives) {
+		t.Fatalf("Expected %d directives, got %d", len(doc.Directives), len(back))
+	}
+}
+
+func TestClone(t *testing.T) {
+	doc, err := confetti.Load("server { listen 80 }\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	cp := confetti.CloneDocument(doc)
+	cp.Directives[0].Subdirectives[0].Arguments[1] = "443"
+	if doc.Directives[0].Subdirectives[0].Arguments[1] != "80" {
+		t.Fatal("Modifying the clone modified the original")
+	} else if !doc.Directives[0].Equals(doc.Directives[0].Clone()) {
+		t.Fatal("Clone is not equal to the original")
+	} else if !doc.Equals(doc.Clone()) || doc.Equals(cp) {
+		t.Fatal("Document equality does not follow its directives")
+	}
+
+	reformatted, err := confetti.Load("# a server\nserver {\n    listen \"80\"\n}\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	} else if !doc.Equals(reformatted) {
+		t.Fatal("Formatting and comments affect equality")
+	}
+}
+
+func TestHash(t *testing.T) {
+	hash := func(src string) [32]byte {
+		doc, err := confetti.Load(src)
+		if err != nil {
+			t.Fatalf("Failed to load configuration: %v", err)
+		}
+		return doc.Hash()
+	}
+
+	h := hash("server { listen 80 }\n")
+	if h != hash("# web\nserver {\n    listen \"80\" # http\n}\n") {
+		t.Fatal("Formatting and comments changed the hash")
+	}
+	for _, src := range []string{"server { listen 8080 }", "server { listen 80 }\nserver {}", "server { listen; 80 }", "server listen 80", ""} {
+		if hash(src) == h {
+			t.Fatalf("Hash of %q collides", src)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	doc, err := confetti.Load("a 1\nserver { listen 80 }\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	s1 := confetti.NewSnapshot(doc.Directives)
+	s2, err := s1.Set([]int{1, 0}, confetti.Directive{Arguments: []string{"listen", "443"}})
+	if err != nil {
+		t.Fatalf("Failed to edit snapshot: %v", err)
+	}
+	if s2, err = s2.Insert([]int{2}, confetti.Directive{Arguments: []string{"b", "2"}}); err != nil {
+		t.Fatalf("Failed to edit snapshot: %v", err)
+	}
+
+	if d, _ := s1.At(1, 0); d.Arguments[1] != "80" {
+		t.Fatalf("Original snapshot was modified: %q", d.Arguments)
+	} else if d, _ = s2.At(1, 0); d.Arguments[1] != "443" {
+		t.Fatalf("Edit was not applied: %q", d.Arguments)
+	} else if s1.Len() != 2 || s2.Len() != 3 {
+		t.Fatalf("Expected lengths 2 and 3, got %d and %d", s1.Len(), s2.Len())
+	}
+
+	if _, err = s2.Delete([]int{5}); err == nil {
+		t.Fatal("Expected error deleting a missing directive")
+	}
+}
+
+func TestDocument(t *testing.T) {
+	doc, err := confetti.Load("\ufeffa 1 # comment\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	} else if !doc.BOM || doc.CtrlZ {
+		t.Fatalf("Expected BOM and no ^Z, got %v and %v", doc.BOM, doc.CtrlZ)
+	}
+
+	var comments []string
+	for _, tr := range doc.Trivia {
+		if tr.Kind == confetti.TriviaComment {
+			comments = append(comments, tr.Text)
+		}
+	}
+	if !slices.Equal(comments, []string{"# comment"}) {
+		t.Fatalf("Expected one comment, got %q", comments)
+	}
+}
+
+func TestParse(t *testing.T) {
+	doc, err := confetti.Parse("# c-style comments are off\nx 1\n", confetti.WithName("app.conf"))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if doc.Name != "app.conf" {
+		t.Fatalf("Expected name %q, got %q", "app.conf", doc.Name)
+	} else if len(doc.Directives) != 1 {
+		t.Fatalf("Expected 1 directive, got %d", len(doc.Directives))
+	}
+
+	if _, err = confetti.Parse("a (b)\n", confetti.WithExtensions(confetti.Extensions{confetti.ExtExpressionArguments: ""})); err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	doc, err := confetti.Parse("\ufeffa 1\r\nserver {\n    é \"x\"\n}\n", confetti.WithName("app.conf"))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	server := doc.Directives[1]
+	if s := server.Pos.String(); s != "app.conf:2:1" {
+		t.Fatalf("Expected server at app.conf:2:1, got %s", s)
+	} else if server.Pos.Offset != 8 {
+		t.Fatalf("Expected server at offset 8, got %d", server.Pos.Offset)
+	} else if s := server.End.String(); s != "app.conf:4:2" {
+		t.Fatalf("Expected server to end at app.conf:4:2, got %s", s)
+	}
+
+	sub := server.Subdirectives[0]
+	if sub.Pos.Offset != 21 || sub.Pos.Line != 3 || sub.Pos.Column != 5 {
+		t.Fatalf("Expected subdirective at offset 21, 3:5, got %d, %s", sub.Pos.Offset, sub.Pos)
+	} else if sub.End.Column != 10 {
+		t.Fatalf("Expected subdirective to end at column 10, got %d", sub.End.Column)
+	}
+}
+
+func TestColumns(t *testing.T) {
+	// "e" and a combining accent, an emoji outside the Basic Multilingual Plane, a family joined by zero width joiners, a flag, and a Hangul syllable in jamo
+	const line = "name e\u0301 \U0001F600 \U0001F468\u200d\U0001F469\u200d\U0001F467 \U0001F1EB\U0001F1F7 \u1100\u1161\u11a8 x"
+	src := "a 1\n" + line + "\n"
+	doc, err := confetti.Parse(src)
+	if err != nil {
+		t.Fatalf("Failed to parse source: %v", err)
+	}
+
+	tests := []struct {
+		arg                           int
+		bytes, runes, utf16, clusters int
+	}{
+		{1, 6, 6, 6, 6},
+		{2, 10, 9, 9, 8},
+		{3, 15, 11, 12, 10},
+		{4, 34, 17, 21, 12},
+		{5, 43, 20, 26, 14},
+		{6, 53, 24, 30, 16},
+	}
+	for _, test := range tests {
+		p := doc.Directives[1].Pos
+		p.Offset += strings.Index(line, doc.Directives[1].Arguments[test.arg])
+		lp := confetti.LinePosOf(src, p)
+		if lp.Line != line {
+			t.Fatalf("Expected line %q, got %q", line, lp.Line)
+		}
+
+		for kind, want := range []int{test.bytes, test.runes, test.utf16, test.clusters} {
+			if got := lp.Column(confetti.ColumnKind(kind)); got != want {
+				t.Fatalf("Argument %d: expected column %d of kind %d, got %d", test.arg, want, kind, got)
+			} else if back := confetti.LinePosAt(line, confetti.ColumnKind(kind), want); back != lp {
+				t.Fatalf("Argument %d: expected column %d of kind %d at offset %d, got %d", test.arg, want, kind, lp.Offset, back.Offset)
+			}
+		}
+	}
+
+	// columns within a character or cluster are at its start, and those past the end at the end
+	if lp := confetti.LinePosAt(line, confetti.ColumnUTF16, 10); lp.Offset != 9 {
+		t.Fatalf("Expected the second code unit of the emoji at its start, got offset %d", lp.Offset)
+	} else if lp := confetti.LinePosAt(line, confetti.ColumnGraphemes, 100); lp.Offset != len(line) {
+		t.Fatalf("Expected a column past the end at the end, got offset %d", lp.Offset)
+	}
+}
+
+func TestComments(t *testing.T) {
+	const src = "# unrelated\n\n# the server\n# on two lines\nserver { # opens\n    listen 80 # http\n} # closes\na; b # b only\n"
+
+	doc, err := confetti.Parse(src)
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	server := doc.Directives[0]
+	if want := []string{"# the server", "# on two lines"}; !slices.Equal(server.LeadingComments, want) {
+		t.Fatalf("Expected leading comments %q, got %q", want, server.LeadingComments)
+	} else if server.TrailingComment != "# closes" {
+		t.Fatalf("Expected trailing comment \"# closes\", got %q", server.TrailingComment)
+	}
+
+	listen := server.Subdirectives[0]
+	if want := []string{"# opens"}; !slices.Equal(listen.LeadingComments, want) {
+		t.Fatalf("Expected leading comments %q, got %q", want, listen.LeadingComments)
+	} else if listen.TrailingComment != "# http" {
+		t.Fatalf("Expected trailing comment \"# http\", got %q", listen.TrailingComment)
+	} else if doc.Directives[1].TrailingComment != "" || doc.Directives[2].TrailingComment != "# b only" {
+		t.Fatalf("Expected only b to have a trailing comment, got %q and %q", doc.Directives[1].TrailingComment, doc.Directives[2].TrailingComment)
+	}
+
+	const expected = "# the server\n# on two lines\nserver {\n    # opens\n    listen 80 # http\n} # closes\na\nb # b only\n"
+	if out := doc.String(); out != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, out)
+	}
+
+	doc.Directives[1].TrailingComment = "not a comment"
+	if _, err = confetti.Format(doc, confetti.FormatOptions{}); err == nil {
+		t.Fatal("Expected an error for an invalid comment")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := confetti.Parse("a {\n\tb ;;\n}\n", confetti.WithName("app.conf"))
+	if !errors.Is(err, confetti.ErrUnexpectedSemicolon) {
+		t.Fatalf("Expected ErrUnexpectedSemicolon, got %v", err)
+	}
+
+	var pe *confetti.ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("Expected a ParseError, got %T", err)
+	} else if s := pe.Error(); s != "app.conf:2:5: unexpected ';'" {
+		t.Fatalf("Expected error with location, got %q", s)
+	} else if pe.Token != ";" {
+		t.Fatalf("Expected offending token ';', got %q", pe.Token)
+	} else if s := pe.Snippet(); s != "\tb ;;\n\t   ^" {
+		t.Fatalf("Expected snippet with caret, got %q", s)
+	} else if pe.Code() != 8 || pe.Code().String() != "E0008" || pe.Code().Err() != confetti.ErrUnexpectedSemicolon {
+		t.Fatalf("Expected code E0008, got %s", pe.Code())
+	} else if s := pe.Render(false); s != "error[E0008]: unexpected ';'\n --> app.conf:2:5\n  |\n2 | \tb ;;\n  | \t   ^" {
+		t.Fatalf("Expected rendered error, got %q", s)
+	} else if s := pe.Render(true); !strings.Contains(s, "\x1b[1;31merror[E0008]\x1b[0m") || !strings.Contains(s, "\x1b[1;31m^\x1b[0m") {
+		t.Fatalf("Expected colored error, got %q", s)
+	}
+
+	const src = "name app\nport eighty\n"
+	d := confetti.Diagnostic{
+		Severity: confetti.SeverityWarning,
+		Message:  "port is not a number",
+		Pos:      confetti.Position{Offset: 14, Line: 2, Column: 6},
+		End:      confetti.Position{Offset: 20, Line: 2, Column: 12},
+	}
+	if s := d.Render(src, false); s != "warning: port is not a number\n --> 2:6\n  |\n2 | port eighty\n  |      ^^^^^^" {
+		t.Fatalf("Expected rendered diagnostic, got %q", s)
+	} else if s := d.Render("", false); s != "warning: port is not a number\n --> 2:6" {
+		t.Fatalf("Expected diagnostic without the line, got %q", s)
+	}
+
+	// unclosed arguments report where they started
+	_, err = confetti.Parse("a {\n\tb \"\"\"c\nd\n", confetti.WithName("app.conf"))
+	if !errors.As(err, &pe) || !errors.Is(err, confetti.ErrUnclosedQuoted) {
+		t.Fatalf("Expected ErrUnclosedQuoted, got %v", err)
+	} else if s := pe.Error(); s != "app.conf:4:1: unclosed quoted, started at 2:4" {
+		t.Fatalf("Expected error with start and end, got %q", s)
+	} else if pe.Start.String() != "app.conf:2:4" {
+		t.Fatalf("Expected start app.conf:2:4, got %s", pe.Start)
+	}
+
+	if confetti.CodeOf(fmt.Errorf("reading: %w", err)) != 5 || confetti.CodeOf(errors.New("other")) != 0 {
+		t.Fatal("Expected CodeOf to find the code through wrapping, and no code for other errors")
+	}
+
+	// Load keeps the plain message
+	if _, err = confetti.Load("a \"b"); err == nil || err.Error() != "error: unclosed quoted" {
+		t.Fatalf("Expected plain error, got %v", err)
+	} else if !errors.Is(err, confetti.ErrUnclosedQuoted) {
+		t.Fatalf("Expected ErrUnclosedQuoted, got %v", err)
+	}
+	if _, err = confetti.Load("a \u0001\nb \u0002\n", confetti.WithAllIllegalCharacters()); err == nil || err.Error() != "error: illegal character U+0001\nerror: illegal character U+0002" {
+		t.Fatalf("Expected every illegal character, got %v", err)
+	}
+
+	for src, want := range map[string]error{
+		"a \u0001":     confetti.ErrIllegalCharacter,
+		"a { b { c }":  confetti.ErrExpectedCloseBrace,
+		"a { b } {}":   confetti.ErrUnexpectedOpenBrace,
+		"{ a }":        confetti.ErrUnexpectedOpenBrace,
+		"a {{ b } }":   confetti.ErrUnexpectedOpenBrace,
+		"a\n{ b }\n{}": confetti.ErrUnexpectedOpenBrace,
+	} {
+		if _, err = confetti.Parse(src); !errors.Is(err, want) {
+			t.Fatalf("Expected %v for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	doc, err := confetti.Parse("a { b { c } d }\ne { f }\n")
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	var visited []string
+	confetti.Walk(doc.Directives, func(d *confetti.Directive, depth int) bool {
+		visited = append(visited, fmt.Sprint(d.Arguments[0], depth))
+		return d.Arguments[0] != "e"
+	})
+	if want := []string{"a0", "b1", "c2", "d1", "e0"}; !slices.Equal(visited, want) {
+		t.Fatalf("Expected %v, got %v", want, visited)
+	}
+
+	// rename every directive
+	confetti.Walk(doc.Directives, func(d *confetti.Directive, _ int) bool {
+		d.Arguments[0] += "!"
+		return true
+	})
+	if name := doc.Directives[0].Subdirectives[0].Subdirectives[0].Arguments[0]; name != "c!" {
+		t.Fatalf("Expected walk to modify directives, got %q", name)
+	}
+
+	var depth, maxDepth int
+	confetti.Inspect(doc.Directives, func(d *confetti.Directive) bool {
+		if d == nil {
+			depth--
+			return false
+		}
+		depth++
+		maxDepth = max(maxDepth, depth)
+		return true
+	})
+	if depth != 0 || maxDepth != 3 {
+		t.Fatalf("Expected balanced inspection reaching depth 3, got %d and %d", depth, maxDepth)
+	}
+}
+
+func TestGet(t *testing.T) {
+	doc, err := confetti.Parse("server {\n    listen 8080\n    listen 8443\n}\nserver {}\n")
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	server, ok := doc.Get("server")
+	if !ok {
+		t.Fatal("Expected a server directive")
+	} else if n := len(doc.GetAll("server")); n != 2 {
+		t.Fatalf("Expected 2 server directives, got %d", n)
+	} else if _, ok = doc.Get("client"); ok {
+		t.Fatal("Expected no client directive")
+	}
+
+	if listen, ok := server.Sub("listen"); !ok || !slices.Equal(listen.Arguments, []string{"listen", "8080"}) {
+		t.Fatalf("Expected listen 8080, got %v", listen.Arguments)
+	} else if n := len(server.SubAll("listen")); n != 2 {
+		t.Fatalf("Expected 2 listen directives, got %d", n)
+	}
+}
+
+func TestFind(t *testing.T) {
+	doc, err := confetti.Load("server {\n    listen 80\n}\nserver {\n    listen 443\n    tls on\n}\nlisten 8080\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	listens := doc.Find(func(d *confetti.Directive) bool { return d.Name() == "listen" })
+	var got []string
+	for _, m := range listens {
+		got = append(got, fmt.Sprintf("%v %s %s", m.Path, strings.Join(m.Names, "."), m.Directive.Arguments[1]))
+	}
+	if expected := []string{"[0 0] server.listen 80", "[1 0] server.listen 443", "[2] listen 8080"}; !slices.Equal(got, expected) {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+
+	listens[0].Directive.Arguments[1] = "8000"
+	if doc.Directives[0].Subdirectives[0].Arguments[1] != "8000" {
+		t.Fatal("Changing a match did not change the document")
+	}
+
+	if m, ok := doc.FindFirst(func(d *confetti.Directive) bool { return len(d.Subdirectives) > 1 }); !ok || !slices.Equal(m.Path, []int{1}) {
+		t.Fatalf("Unexpected first match %v %v", m.Path, ok)
+	} else if _, ok = doc.FindFirst(func(d *confetti.Directive) bool { return d.Name() == "missing" }); ok {
+		t.Fatal("Expected no match")
+	}
+}
+
+func TestSelector(t *testing.T) {
+	doc, err := confetti.Load(`listen 8080
+server {
+    listen 80
+    location /api {
+        listen 81
+        location /api/v2 { listen 82 }
+    }
+    location / { listen 83 }
+}
+location "/api" { listen 84 }
+`)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	for selector, want := range map[string]string{
+		"listen":                                "8080 80 81 82 83 84",
+		"> listen":                              "8080",
+		"server > listen":                       "80",
+		"server listen":                         "80 81 82 83",
+		`server > location[arg1="/api"] listen`: "81 82",
+		`location[arg1=/api] > listen`:          "81 84",
+		"location location listen":              "82",
+		"* > location[arg1] > listen":           "81 82 83",
+		"server>*>listen":                       "81 83",
+		"location[arg2] listen":                 "",
+		`"listen"[arg0=listen][arg1="8080"]`:    "8080",
+	} {
+		sel, err := confetti.CompileSelector(selector)
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %v", selector, err)
+		}
+		var got []string
+		for _, m := range sel.Select(doc) {
+			got = append(got, m.Directive.Arguments[1])
+		}
+		if strings.Join(got, " ") != want {
+			t.Fatalf("Selector %s: expected %s, got %s", selector, want, got)
+		}
+	}
+
+	for _, selector := range []string{"", "a >", "a[", "a[arg]", "a[x1]", `a[arg1="b]`, "a b]"} {
+		if _, err = confetti.CompileSelector(selector); err == nil {
+			t.Fatalf("Expected an error compiling %q", selector)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	doc, err := confetti.Parse(`server {
+    tls { certificate /etc/cert.pem }
+}
+upstream { host a }
+upstream { host b }
+upstream { host c }
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	for path, want := range map[string]string{
+		"server.tls.certificate": "/etc/cert.pem",
+		"upstream.host":          "a",
+		"upstream[2].host":       "c",
+	} {
+		d, err := doc.Lookup(path)
+		if err != nil {
+			t.Fatalf("Failed to look up %s: %v", path, err)
+		} else if got := d.Arguments[1]; got != want {
+			t.Fatalf("Expected %s to be %q, got %q", path, want, got)
+		}
+	}
+
+	for _, path := range []string{"upstream[3].host", "server.port", "server..tls", "upstream[x]", "upstream[1"} {
+		if _, err := doc.Lookup(path); err == nil {
+			t.Fatalf("Expected an error looking up %s", path)
+		}
+	}
+}
+
+// eventRecorder writes the events it receives on lines of their own.
+type eventRecorder struct {
+	strings.Builder
+}
+
+func (r *eventRecorder) OnDirective(args []string) error {
+	fmt.Fprintf(r, "directive %q\n", args)
+	return nil
+}
+
+func (r *eventRecorder) OnBlockStart() error {
+	r.WriteString("start\n")
+	return nil
+}
+
+func (r *eventRecorder) OnBlockEnd() error {
+	r.WriteString("end\n")
+	return nil
+}
+
+func (r *eventRecorder) OnComment(text string) error {
+	fmt.Fprintf(r, "comment %q\n", text)
+	return nil
+}
+
+func TestParseEvents(t *testing.T) {
+	const src = `# servers
+server example.com { # main
+    root """/srv
+/www"""
+    listen 80; listen 443
+}
+log debug
+`
+	var r eventRecorder
+	if err := confetti.ParseEvents(strings.NewReader(src), &r); err != nil {
+		t.Fatalf("Failed to parse events: %v", err)
+	}
+
+	const expected = `comment "# servers"
+directive ["server" "example.com"]
+start
+comment "# main"
+directive ["root" "/srv\n/www"]
+directive ["listen" "80"]
+directive ["listen" "443"]
+end
+directive ["log" "debug"]
+`
+	if r.String() != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, r.String())
+	}
+
+	for _, src := range []string{"a {\n    b \"c\n}\n", "a {\n    b\n", "a {\n", "a {", "a }\n", "a\n;\n"} {
+		_, perr := confetti.Parse(src, confetti.WithName("app.conf"))
+		eerr := confetti.ParseEvents(strings.NewReader(src), &eventRecorder{}, confetti.WithName("app.conf"))
+		if perr == nil || eerr == nil || perr.Error() != eerr.Error() {
+			t.Fatalf("%q: expected the error Parse gives, %v, got %v", src, perr, eerr)
+		}
+	}
+
+	stop := errors.New("stop")
+	if err := confetti.ParseEvents(strings.NewReader(src), &stopper{err: stop}); err != stop {
+		t.Fatalf("Expected the handler's error, got %v", err)
+	}
+
+	// lines end with any line terminator, so a source without LFs is not read whole
+	cr := &countingReader{r: iotest.OneByteReader(strings.NewReader(strings.Repeat("a\r", 1000)))}
+	if err := confetti.ParseEvents(cr, &stopper{err: stop}); err != stop {
+		t.Fatalf("Expected the handler's error, got %v", err)
+	} else if cr.n > 8 {
+		t.Fatalf("Expected the first directive after reading its line, but read %d bytes", cr.n)
+	}
+
+	r = eventRecorder{}
+	if err := confetti.ParseEvents(iotest.OneByteReader(strings.NewReader("a\rb\r\nc\u0085d\u2028e\u2029f\vg\fh")), &r); err != nil {
+		t.Fatalf("Failed to parse events: %v", err)
+	} else if expected := "directive [\"a\"]\ndirective [\"b\"]\ndirective [\"c\"]\ndirective [\"d\"]\ndirective [\"e\"]\ndirective [\"f\"]\ndirective [\"g\"]\ndirective [\"h\"]\n"; r.String() != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, r.String())
+	}
+
+	const crlf = "a\r\n\r\n;\r\n"
+	_, perr := confetti.Parse(crlf)
+	eerr := confetti.ParseEvents(iotest.OneByteReader(strings.NewReader(crlf)), &eventRecorder{})
+	if perr == nil || eerr == nil || perr.Error() != eerr.Error() {
+		t.Fatalf("%q: expected the error Parse gives, %v, got %v", crlf, perr, eerr)
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// stopper fails at the first directive.
+type stopper struct {
+	eventRecorder
+	err error
+}
+
+func (s *stopper) OnDirective([]string) error {
+	return s.err
+}
+
+func TestParser(t *testing.T) {
+	const src = "# first\na 1\nb {\n    c\n} # block\nd\n"
+	broken := errors.New("connection lost")
+	p := confetti.NewParser(io.MultiReader(strings.NewReader(src), iotest.ErrReader(broken)), confetti.WithName("app.conf"))
+
+	d, err := p.Next()
+	if err != nil {
+		t.Fatalf("Failed to read the first directive: %v", err)
+	} else if d.Name() != "a" || d.Pos.String() != "app.conf:2:1" || len(d.LeadingComments) != 1 {
+		t.Fatalf("Unexpected first directive: %+v", d)
+	}
+
+	if d, err = p.Next(); err != nil {
+		t.Fatalf("Failed to read the second directive: %v", err)
+	} else if d.Name() != "b" || len(d.Subdirectives) != 1 || d.TrailingComment != "# block" {
+		t.Fatalf("Unexpected second directive: %+v", d)
+	}
+
+	// the last directive is not returned until the source ends, as a block may follow
+	if _, err = p.Next(); err != broken {
+		t.Fatalf("Expected the reader's error, got %v", err)
+	}
+
+	p = confetti.NewParser(strings.NewReader("a\n}\n"))
+	if _, err = p.Next(); !errors.Is(err, confetti.ErrUnmatchedCloseBrace) {
+		t.Fatalf("Expected an unmatched brace, got %v", err)
+	} else if _, err = p.Next(); !errors.Is(err, confetti.ErrUnmatchedCloseBrace) {
+		t.Fatalf("Expected the error again, got %v", err)
+	}
+
+	for _, src := range []string{"a {\n", "a {"} {
+		_, perr := confetti.Parse(src)
+		if _, err = confetti.NewParser(strings.NewReader(src)).Next(); perr == nil || err == nil || err.Error() != perr.Error() {
+			t.Fatalf("%q: expected the error Parse gives, %v, got %v", src, perr, err)
+		}
+	}
+
+	p = confetti.NewParser(strings.NewReader("a\n"))
+	if d, err = p.Next(); err != nil || d.Name() != "a" {
+		t.Fatalf("Expected directive a, got %v, %v", d, err)
+	} else if _, err = p.Next(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	doc, err := confetti.Load("server {\n    listen 80\n}\nupstream app 10.0.0.1\nserver {\n    listen 443\n}\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	var listens, upstreams []string
+	r := confetti.NewRouter()
+	r.Handle("server", func(d confetti.Directive) error {
+		l, _ := d.Sub("listen")
+		listens = append(listens, l.Arg(1).String())
+		return nil
+	})
+	r.Handle("upstream", func(d confetti.Directive) error {
+		if len(d.Arguments) != 3 {
+			return errors.New("expected a name and an address")
+		}
+		upstreams = append(upstreams, d.Arguments[1])
+		return nil
+	})
+	if err := r.Process(doc); err != nil {
+		t.Fatalf("Failed to process configuration: %v", err)
+	} else if got := fmt.Sprint(listens, upstreams); got != "[80 443] [app]" {
+		t.Fatalf("Unexpected values: %s", got)
+	}
+
+	doc, _ = confetti.Load("upstream app\n")
+	if err := r.Process(doc); err == nil || err.Error() != "1:1: upstream: expected a name and an address" {
+		t.Fatalf("Expected a handler error, got %v", err)
+	}
+	doc, _ = confetti.Load("server {\n}\nlog debug\n")
+	if err := r.Process(doc); err == nil || err.Error() != "3:1: log: unhandled directive" {
+		t.Fatalf("Expected an unhandled directive error, got %v", err)
+	}
+}
+
+func TestAccessorsWithDefaults(t *testing.T) {
+	doc, err := confetti.Load("server {\n    port 9090\n    debug on\n    timeout 1m\n    name web\n    hosts a b\n    retries many\n}\n")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if n := doc.IntOr("server.port", 8080); n != 9090 {
+		t.Fatalf("Expected 9090, got %d", n)
+	} else if n = doc.IntOr("server.retries", 3); n != 3 {
+		t.Fatalf("Expected the default for an invalid integer, got %d", n)
+	} else if n = doc.IntOr("client.port", 80); n != 80 {
+		t.Fatalf("Expected the default for a missing directive, got %d", n)
+	} else if b := doc.BoolOr("server.debug", false); !b {
+		t.Fatal("Expected debug to be on")
+	} else if dur := doc.DurationOr("server.timeout", time.Second); dur != time.Minute {
+		t.Fatalf("Expected 1m, got %s", dur)
+	} else if s := doc.StringOr("server.name", "app"); s != "web" {
+		t.Fatalf("Expected web, got %s", s)
+	} else if s = doc.StringOr("server.hosts", "none"); s != "none" {
+		t.Fatalf("Expected the default for several arguments, got %s", s)
+	} else if s = doc.StringOr("server[", "bad"); s != "bad" {
+		t.Fatalf("Expected the default for an invalid path, got %s", s)
+	}
+}
+
+func TestLookupAll(t *testing.T) {
+	doc, err := confetti.Load(`servers {
+    web { port 80 }
+    api {
+        port 81
+        tls { port 443 }
+    }
+}
+tls on
+upstream { host a }
+upstream { host b }
+`)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	for path, want := range map[string]string{
+		"servers.*.port":   "servers.web.port [80] [0 0 0], servers.api.port [81] [0 1 0]",
+		"**.tls":           "servers.api.tls [] [0 1 1], tls [on] [1]",
+		"**.port":          "servers.web.port [80] [0 0 0], servers.api.port [81] [0 1 0], servers.api.tls.port [443] [0 1 1 0]",
+		"upstream.host":    "upstream.host [a] [2 0], upstream.host [b] [3 0]",
+		"upstream[1].host": "upstream.host [b] [3 0]",
+		"servers.**.tls.*": "servers.api.tls.port [443] [0 1 1 0]",
+		"servers.*.**":     "servers.web [] [0 0], servers.web.port [80] [0 0 0], servers.api [] [0 1], servers.api.port [81] [0 1 0], servers.api.tls [] [0 1 1], servers.api.tls.port [443] [0 1 1 0]",
+		"servers.missing":  "",
+	} {
+		ms, err := doc.LookupAll(path)
+		if err != nil {
+			t.Fatalf("Failed to look up %s: %v", path, err)
+		}
+		var got []string
+		for _, m := range ms {
+			got = append(got, fmt.Sprintf("%s %v %v", strings.Join(m.Names, "."), m.Directive.Arguments[1:], m.Path))
+		}
+		if g := strings.Join(got, ", "); g != want {
+			t.Fatalf("Path %s: expected %s, got %s", path, want, g)
+		}
+	}
+
+	if _, err = doc.LookupAll("servers..port"); err == nil {
+		t.Fatal("Expected an error for an empty name")
+	}
+}
+
+func TestArgument(t *testing.T) {
+	d := confetti.Directive{Arguments: []string{"limits", "0x10", "On", "2.5", "1m30s", "1.5KiB", "10MB"}}
+
+	if n, err := d.Arg(1).Int(); err != nil || n != 16 {
+		t.Fatalf("Expected 16, got %d, %v", n, err)
+	} else if b, err := d.Arg(2).Bool(); err != nil || !b {
+		t.Fatalf("Expected true, got %v, %v", b, err)
+	} else if f, err := d.Arg(3).Float64(); err != nil || f != 2.5 {
+		t.Fatalf("Expected 2.5, got %v, %v", f, err)
+	} else if dur, err := d.Arg(4).Duration(); err != nil || dur != 90*time.Second {
+		t.Fatalf("Expected 1m30s, got %v, %v", dur, err)
+	} else if n, err := d.Arg(5).Bytes(); err != nil || n != 1536 {
+		t.Fatalf("Expected 1536, got %d, %v", n, err)
+	} else if n, err := d.Arg(6).Bytes(); err != nil || n != 10_000_000 {
+		t.Fatalf("Expected 10000000, got %d, %v", n, err)
+	}
+
+	if d.Arg(7) != "" {
+		t.Fatalf("Expected empty argument out of range, got %q", d.Arg(7))
+	} else if _, err := d.Arg(0).Int(); !errors.Is(err, strconv.ErrSyntax) {
+		t.Fatalf("Expected syntax error, got %v", err)
+	} else if _, err := confetti.Argument("99999999999999999999").Int(); !errors.Is(err, strconv.ErrRange) {
+		t.Fatalf("Expected range error, got %v", err)
+	} else if _, err := confetti.Argument("10XB").Bytes(); err == nil {
+		t.Fatal("Expected error for unknown unit")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := confetti.Schema{Directives: map[string]confetti.DirectiveSchema{
+		"name": {MinArgs: 1, MaxArgs: 1, Required: true},
+		"server": {Repeatable: true, Sub: &confetti.Schema{Directives: map[string]confetti.DirectiveSchema{
+			"listen":  {MinArgs: 1, MaxArgs: -1, Args: []confetti.ArgType{confetti.ArgInt}, Required: true},
+			"timeout": {MinArgs: 1, MaxArgs: 1, Args: []confetti.ArgType{confetti.ArgDuration}},
+		}}},
+	}}
+
+	doc, err := confetti.Parse("name app\nserver {\n    listen 80 443\n}\n", confetti.WithName("app.conf"))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if errs := doc.Validate(schema); len(errs) != 0 {
+		t.Fatalf("Expected no errors, got %v", errs)
+	}
+
+	doc, err = confetti.Parse("server {\n    listen http\n    timeout 5 { x }\n    lissten 80\n}\nextra\n", confetti.WithName("app.conf"))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	var got []string
+	for _, err := range doc.Validate(schema) {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`app.conf:2:5: server.listen: argument 1: invalid integer "http": invalid syntax`,
+		`app.conf:3:5: server.timeout: argument 1: invalid duration "5": invalid syntax`,
+		`app.conf:3:5: server.timeout: unexpected subdirectives`,
+		`app.conf:4:5: server.lissten: unknown directive; did you mean "listen"?`,
+		`app.conf:6:1: extra: unknown directive`,
+		`app.conf: name: missing required directive`,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Expected errors:\n%s\nGot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestFileSet(t *testing.T) {
+	dir := t.TempDir()
+	tls := filepath.Join(dir, "tls.conf")
+	if err := os.WriteFile(tls, []byte("certificate cert.pem\nkey \"key.pem\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "site.conf")
+	const src = "name site\nserver {\n    include tls.conf\n}\n"
+
+	fset := confetti.NewFileSet()
+	_, err := confetti.Parse(src, confetti.WithName(main), confetti.WithIncludes(1), confetti.WithFileSet(fset))
+	var pe *confetti.ParseError
+	if !errors.As(errors.Unwrap(err), &pe) || !errors.Is(err, confetti.ErrUnclosedQuoted) {
+		t.Fatalf("Expected an unclosed quoted argument in the included file, got %v", err)
+	} else if files := fset.Files(); len(files) != 2 || files[0].Name != main || files[1].Name != tls {
+		t.Fatalf("Expected both files in the set, got %v", files)
+	}
+
+	// positions in the included file render with its lines
+	if s := fset.Render(pe.Diagnostic(), false); s != "error[E0005]: unclosed quoted, started at 2:5\n --> "+tls+":2:13\n  |\n2 | key \"key.pem\n  |             ^" {
+		t.Fatalf("Unexpected rendering %q", s)
+	}
+
+	// each byte of each file has its own number
+	for _, p := range []confetti.Position{{Filename: main, Offset: 14, Line: 2, Column: 5}, {Filename: tls, Offset: 0, Line: 1, Column: 1}, pe.Pos} {
+		if n := fset.Pos(p); n == 0 || fset.Position(n) != p {
+			t.Fatalf("Expected %s to round trip, got %d and %s", p, n, fset.Position(n))
+		}
+	}
+	if fset.Pos(confetti.Position{Filename: "other.conf", Line: 1, Column: 1}) != 0 || fset.Position(1<<20).IsValid() {
+		t.Fatal("Expected no numbers outside the set")
+	}
+
+	// sources parsed again replace those of the same name, and unnamed ones are not added
+	fset = confetti.NewFileSet()
+	doc, err := confetti.Parse("a 1\nb 2\nc 3\n", confetti.WithName(main), confetti.WithFileSet(fset))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+	old := fset.File(main)
+	if _, err = confetti.Parse("a 1\nb 2\nc 3\n", confetti.WithName(main), confetti.WithFileSet(fset)); err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if fset.File(main) != old {
+		t.Fatal("Expected an unchanged source to be kept")
+	} else if _, err = confetti.Parse("x\n", confetti.WithFileSet(fset)); err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+	if doc, err = doc.Reparse(confetti.Range{Start: confetti.Position{Offset: 6}, End: confetti.Position{Offset: 7}}, "20"); err != nil {
+		t.Fatalf("Failed to reparse configuration: %v", err)
+	} else if files := fset.Files(); len(files) != 1 || files[0].Src != "a 1\nb 20\nc 3\n" {
+		t.Fatalf("Expected only the reparsed source in the set, got %v", files)
+	} else if fset.Position(old.Base).IsValid() {
+		t.Fatal("Expected the replaced source's numbers to be out of the set")
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		} else if err = os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	write("conf.d/tls.conf", "certificate cert.pem\n")
+	write("conf.d/server.conf", "listen 80\ntls { include tls.conf }\n")
+	main := write("app.conf", "name app\nserver {\n    include conf.d/server.conf\n}\n")
+
+	doc, err := confetti.Parse("name app\nserver {\n    include conf.d/server.conf\n}\n", confetti.WithName(main), confetti.WithIncludes(5))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+	cert, err := doc.Lookup("server.tls.certificate")
+	if err != nil {
+		t.Fatalf("Failed to look up included directive: %v", err)
+	} else if want := filepath.Join(dir, "conf.d", "tls.conf") + ":1:1"; cert.Pos.String() != want {
+		t.Fatalf("Expected included directive at %s, got %s", want, cert.Pos)
+	}
+
+	if _, err = confetti.Parse("include conf.d/server.conf\n", confetti.WithName(main), confetti.WithIncludes(1)); !errors.Is(err, confetti.ErrIncludeDepth) {
+		t.Fatalf("Expected ErrIncludeDepth, got %v", err)
+	}
+
+	write("loop.conf", "a 1\ninclude loop.conf\n")
+	_, err = confetti.Parse("include loop.conf\n", confetti.WithName(main), confetti.WithIncludes(5))
+	if !errors.Is(err, confetti.ErrIncludeCycle) {
+		t.Fatalf("Expected ErrIncludeCycle, got %v", err)
+	} else if !strings.HasPrefix(err.Error(), main+":1:1: ") {
+		t.Fatalf("Expected error at the original include, got %v", err)
+	}
+
+	var pe *confetti.ParseError
+	if _, err = confetti.Parse("include a.conf b.conf\n", confetti.WithName(main), confetti.WithIncludes(1)); !errors.As(err, &pe) || pe.Code() == 0 || !errors.Is(err, confetti.ErrMalformedInclude) {
+		t.Fatalf("Expected ErrMalformedInclude with a code, got %v", err)
+	}
+
+	// includes are directives like any other unless enabled
+	if doc, err = confetti.Parse("include loop.conf\n"); err != nil || len(doc.Directives) != 1 {
+		t.Fatalf("Expected include to be left alone, got %v, %v", doc.Directives, err)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	exts := confetti.Extensions{confetti.ExtVariables: ""}
+	doc, err := confetti.Parse(`set base_dir /srv/app
+log_path ${base_dir}/logs
+server {
+    set base_dir /srv/server
+    root ${base_dir}
+    literal $${base_dir}
+}
+data ${base_dir}/data
+`, confetti.WithExtensions(exts))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	for path, want := range map[string]string{
+		"log_path":       "/srv/app/logs",
+		"server.root":    "/srv/server",
+		"server.literal": "${base_dir}",
+		"data":           "/srv/app/data",
+	} {
+		if d, err := doc.Lookup(path); err != nil || d.Arguments[1] != want {
+			t.Fatalf("Expected %s to be %q, got %v, %v", path, want, d.Arguments, err)
+		}
+	}
+	if _, err = doc.Lookup("set"); err == nil {
+		t.Fatal("Expected variable definitions to be removed")
+	}
+
+	_, err = confetti.Parse("a {\n    set x 1\n}\nb ${x}\n", confetti.WithExtensions(confetti.Extensions{confetti.ExtVariables: "let"}))
+	if !errors.Is(err, confetti.ErrUndefinedVariable) {
+		t.Fatalf("Expected ErrUndefinedVariable, got %v", err)
+	}
+	if _, err = confetti.Parse("let x 1\nb ${x}\n", confetti.WithExtensions(confetti.Extensions{confetti.ExtVariables: "let"})); err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	_, err = confetti.Parse("a 1\nset x\n", confetti.WithExtensions(exts))
+	var pe *confetti.ParseError
+	if !errors.As(err, &pe) || pe.Code() != confetti.CodeOf(confetti.ErrMalformedDefinition) || pe.Code() == 0 || err.Error() != "2:1: variable definition expects a name and a value" {
+		t.Fatalf("Expected ErrMalformedDefinition at 2:1, got %v", err)
+	}
+}
+
+func TestExtensionOptions(t *testing.T) {
+	exts := confetti.Extensions{confetti.ExtVariables: "let"}
+	doc, err := confetti.Parse("let x 1\na=${x} (b c) // d\n", confetti.WithExtensions(exts),
+		confetti.WithCStyleComments(), confetti.WithExpressionArguments(), confetti.WithPunctuators("=", ":"), confetti.WithMaxDepth(64))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if !slices.Equal(doc.Directives[0].Arguments, []string{"a", "=", "1", "b c"}) {
+		t.Fatalf("Unexpected arguments %q", doc.Directives[0].Arguments)
+	} else if len(doc.Extensions) != 4 || len(exts) != 1 {
+		t.Fatalf("Expected 4 extensions without changing the map given, got %v and %v", doc.Extensions, exts)
+	}
+
+	if doc, err = confetti.Parse("set x 1\nb ${x}\n", confetti.WithVariables("")); err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if doc.Directives[0].Arguments[1] != "1" {
+		t.Fatalf("Expected variable to be replaced, got %q", doc.Directives[0].Arguments)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base, err := confetti.Parse("port 80\nallow a\nallow b\nserver {\n    root /srv\n    index index.html\n}\nlog info\n", confetti.WithName("defaults.conf"))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+	overlay, err := confetti.Parse("allow c\nserver {\n    root /var/www\n}\nport 8080\n")
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	for policy, want := range map[confetti.MergePolicy]string{
+		confetti.MergeReplace: "port 8080\nallow c\nserver {\n    root /var/www\n    index index.html\n}\nlog info\n",
+		confetti.MergeAppend:  "port 80\nport 8080\nallow a\nallow b\nallow c\nserver {\n    root /srv\n    root /var/www\n    index index.html\n}\nlog info\n",
+	} {
+		merged := confetti.Merge(base, overlay, policy)
+		if got := merged.String(); got != want {
+			t.Fatalf("Policy %d: expected:\n%s\nGot:\n%s", policy, want, got)
+		} else if merged.Name != "defaults.conf" {
+			t.Fatalf("Expected the name of the base, got %q", merged.Name)
+		}
+	}
+
+	if base.Directives[0].Arguments[1] != "80" {
+		t.Fatal("Merge modified its input")
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	parse := func(src string) confetti.Document {
+		doc, err := confetti.Parse(src)
+		if err != nil {
+			t.Fatalf("Failed to parse configuration: %v", err)
+		}
+		return doc
+	}
+	base := parse("port 80\nworkers 4\nlog info\nserver {\n    root /srv\n    index index.html\n}\n")
+	ours := parse("port 8080\nworkers 8\nserver {\n    root /home/me/www # mine\n    index index.html\n}\n")
+	theirs := parse("port 80\nworkers 2\nlog warn\nserver {\n    root /srv\n    index index.html index.htm\n    gzip on\n}\ntimeout 30\n")
+
+	merged, conflicts := confetti.Merge3(base, ours, theirs)
+	const want = "port 8080\nworkers 8\nserver {\n    root /home/me/www # mine\n    index index.html index.htm\n    gzip on\n}\ntimeout 30\n"
+	if got := merged.String(); got != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, got)
+	}
+	var got []string
+	for _, c := range conflicts {
+		got = append(got, c.String())
+	}
+	if expected := []string{"workers: modified in both", "log: removed in ours and modified in theirs"}; !slices.Equal(got, expected) {
+		t.Fatalf("Expected conflicts %q, got %q", expected, got)
+	}
+
+	if merged, conflicts = confetti.Merge3(base, base, theirs); len(conflicts) != 0 || merged.String() != theirs.String() {
+		t.Fatalf("Expected the upstream document without conflicts, got %v:\n%s", conflicts, merged)
+	} else if base.Directives[1].Arguments[1] != "4" {
+		t.Fatal("Merge3 modified its input")
+	}
+}
+
+var extHexColors = confetti.RegisterExtension("hex_colors", confetti.ExtensionHooks{
+	Argument: func(rest, _ string) int {
+		if len(rest) >= 7 && rest[0] == '#' && strings.Trim(rest[1:7], "0123456789abcdef") == "" {
+			return 7
+		}
+		return 0
+	},
+	Transform: func(p []confetti.Directive, prefix string) ([]confetti.Directive, error) {
+		for i := range p {
+			p[i].Arguments[0] = prefix + p[i].Arguments[0]
+		}
+		return p, nil
+	},
+})
+
+// extFixedWidth reads arguments starting with '@' as the number of bytes its value gives.
+var extFixedWidth = confetti.RegisterExtension("fixed_width", confetti.ExtensionHooks{
+	Argument: func(rest, value string) int {
+		if n, _ := strconv.Atoi(value); strings.HasPrefix(rest, "@") {
+			return n
+		}
+		return 0
+	},
+})
+
+func TestRegisterExtension(t *testing.T) {
+	doc, err := confetti.Parse("color #ff8800 # orange\n", confetti.WithExtension("hex_colors", "theme."))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if !slices.Equal(doc.Directives[0].Arguments, []string{"theme.color", "#ff8800"}) {
+		t.Fatalf("Expected hooks to apply, got %q", doc.Directives[0].Arguments)
+	} else if !doc.Extensions.Has(extHexColors) {
+		t.Fatal("Expected the document to record the extension")
+	}
+
+	if ext, ok := confetti.LookupExtension("c_style_comments"); !ok || ext != confetti.ExtCStyleComments {
+		t.Fatalf("Expected c_style_comments to be ExtCStyleComments, got %v", ext)
+	} else if extHexColors.String() != "hex_colors" {
+		t.Fatalf("Expected extension name hex_colors, got %s", extHexColors)
+	}
+
+	// hooks read whole characters, and forbidden characters are reported where they are
+	if doc, err = confetti.Parse("a @\u00e9\n", confetti.WithExtension("fixed_width", "2")); err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if !slices.Equal(doc.Directives[0].Arguments, []string{"a", "@", "\u00e9"}) || !doc.Extensions.Has(extFixedWidth) {
+		t.Fatalf("Expected the argument to end before the character, got %q", doc.Directives[0].Arguments)
+	}
+	var pe *confetti.ParseError
+	if _, err = confetti.Parse("a @b\u0001cd\n", confetti.WithExtension("fixed_width", "5")); !errors.As(err, &pe) || err.Error() != "1:5: illegal character U+0001" || pe.Token != "\u0001" {
+		t.Fatalf("Expected an illegal character at 1:5, got %v", err)
+	}
+
+	if _, err = confetti.Parse("a\n", confetti.WithExtension("no_such_extension", "")); err == nil {
+		t.Fatal("Expected an error enabling an unknown extension")
+	}
+}
+
+func TestPunctuators(t *testing.T) {
+	doc, err := confetti.Parse("x:=1\ny=2\n", confetti.WithPunctuators("=", ":="))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if !slices.Equal(doc.Directives[0].Arguments, []string{"x", ":=", "1"}) {
+		t.Fatalf("Expected punctuators to be split off, got %q", doc.Directives[0].Arguments)
+	} else if !slices.Equal(doc.Directives[1].Arguments, []string{"y", "=", "2"}) {
+		t.Fatalf("Expected punctuators to be split off, got %q", doc.Directives[1].Arguments)
+	}
+
+	for _, p := range []string{"", "a b", "\n"} {
+		if _, err = confetti.Parse("a\n", confetti.WithPunctuators(p)); err == nil {
+			t.Fatalf("Expected an error for punctuator %q", p)
+		}
+	}
+
+	opt := confetti.WithPunctuators("=", ":=")
+	doc, err = confetti.Parse("k \\=v a\\:\\=b \":\" = x:=\n", opt)
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if !slices.Equal(doc.Directives[0].Arguments, []string{"k", "=v", "a:=b", ":", "=", "x", ":="}) {
+		t.Fatalf("Expected escaped punctuators to be kept, got %q", doc.Directives[0].Arguments)
+	}
+	doc.Directives[0].Arguments[2] = "a=b"
+	if out := doc.String(); out != "k \"=v\" \"a=b\" \":\" = x :=\n" {
+		t.Fatalf("Expected arguments containing punctuators to be quoted, got %q", out)
+	} else if back, err := confetti.Parse(out, opt); err != nil || !back.Equals(doc) {
+		t.Fatalf("Encoded document did not parse back: %v", err)
+	}
+
+	var b strings.Builder
+	enc := confetti.NewEncoder(&b)
+	enc.SetExtensions(doc.Extensions)
+	if err = enc.Append(confetti.Directive{Arguments: []string{"k", "=", "v=w"}}); err != nil {
+		t.Fatalf("Failed to append directive: %v", err)
+	} else if b.String() != "k = \"v=w\"\n" {
+		t.Fatalf("Expected appended arguments containing punctuators to be quoted, got %q", b.String())
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	dec := confetti.NewDecoder(strings.NewReader("server {\n    listen 80\n}\n"))
+
+	var doc confetti.Document
+	if err := dec.Decode(&doc); err != nil {
+		t.Fatalf("Failed to decode configuration: %v", err)
+	} else if len(doc.Directives) != 1 {
+		t.Fatalf("Expected 1 directive, got %d", len(doc.Directives))
+	} else if err = dec.Decode(&doc); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+
+	// a document read as it streams in is the one Parse gives
+	for _, src := range []string{"\ufeff# web\nserver {\n    listen 80 # http\r\n\tlisten 443\n}\n\u001a", "a \\\n  b; c \"d\"\n"} {
+		opts := []confetti.Option{confetti.WithName("app.conf"), confetti.WithWarnings()}
+		want, err := confetti.Parse(src, opts...)
+		if err != nil {
+			t.Fatalf("Failed to parse configuration: %v", err)
+		}
+		var doc confetti.Document
+		if err = confetti.NewDecoder(iotest.OneByteReader(strings.NewReader(src)), opts...).Decode(&doc); err != nil {
+			t.Fatalf("Failed to decode configuration: %v", err)
+		} else if !reflect.DeepEqual(doc.Directives, want.Directives) || !slices.Equal(doc.Trivia, want.Trivia) || !slices.Equal(doc.Diagnostics, want.Diagnostics) || doc.BOM != want.BOM || doc.CtrlZ != want.CtrlZ {
+			t.Fatalf("Decoded document differs from parsed document for %q", src)
+		} else if doc, err = doc.Reparse(confetti.Range{}, "first\n"); err != nil || doc.Directives[0].Name() != "first" {
+			t.Fatalf("Failed to reparse decoded document: %v", err)
+		}
+	}
+
+	// directives are decoded as they are read, before the input fails
+	var c struct {
+		Port int
+		Name string
+	}
+	broken := errors.New("connection reset")
+	dec = confetti.NewDecoder(io.MultiReader(strings.NewReader("port 8080\nname app\n"), iotest.ErrReader(broken)))
+	if err := dec.Decode(&c); !errors.Is(err, broken) {
+		t.Fatalf("Expected the reader's error, got %v", err)
+	} else if c.Port != 8080 {
+		t.Fatalf("Expected port 8080 decoded before the error, got %d", c.Port)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	if _, err := confetti.Parse("a { b }", confetti.WithMaxDepth(1)); err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	_, err := confetti.Parse("a { b { c } }", confetti.WithMaxDepth(1))
+	var pe *confetti.ParseError
+	if !errors.Is(err, confetti.ErrTooDeep) {
+		t.Fatalf("Expected ErrTooDeep, got %v", err)
+	} else if !errors.As(err, &pe) || pe.Pos.Column != 7 {
+		t.Fatalf("Expected error at the second brace, got %v", err)
+	}
+
+	// a hostile source fails quickly rather than exhausting the stack
+	deep := strings.Repeat("a {", 100000) + strings.Repeat("}", 100000)
+	if _, err = confetti.Parse(deep); !errors.Is(err, confetti.ErrTooDeep) {
+		t.Fatalf("Expected ErrTooDeep, got %v", err)
+	}
+
+	if _, err = confetti.Parse("a", confetti.WithMaxDepth(-1)); err == nil {
+		t.Fatal("Expected an error for a negative depth")
+	}
+}
+
+func TestNormalization(t *testing.T) {
+	const src = "caf\u00e9 1\n\"cafe\u0301\" 2\n"
+
+	doc, err := confetti.Parse(src, confetti.WithNormalization(confetti.NormalizeNFC))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if a, b := doc.Directives[0].Arguments[0], doc.Directives[1].Arguments[0]; a != b {
+		t.Fatalf("Expected normalized names to be equal, got %q and %q", a, b)
+	}
+
+	_, err = confetti.Parse(src, confetti.WithNormalization(confetti.RequireNFC))
+	var pe *confetti.ParseError
+	if !errors.Is(err, confetti.ErrNotNormalized) {
+		t.Fatalf("Expected ErrNotNormalized, got %v", err)
+	} else if !errors.As(err, &pe) || pe.Pos.String() != "2:1" {
+		t.Fatalf("Expected error at 2:1, got %v", err)
+	}
+
+	if doc, err = confetti.Parse(src); err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if doc.Directives[0].Arguments[0] == doc.Directives[1].Arguments[0] {
+		t.Fatal("Expected arguments to be kept as written by default")
+	}
+}
+
+func TestAllIllegalCharacters(t *testing.T) {
+	const src = "name \u0001app\n# \u0002 pasted\nport \"8\u00030\"\n"
+	if _, err := confetti.Parse(src); err == nil || strings.Contains(err.Error(), "\n") {
+		t.Fatalf("Expected a single error, got %v", err)
+	}
+
+	_, err := confetti.Parse(src, confetti.WithName("app.conf"), confetti.WithAllIllegalCharacters())
+	if !errors.Is(err, confetti.ErrIllegalCharacter) {
+		t.Fatalf("Expected ErrIllegalCharacter, got %v", err)
+	}
+	var got []string
+	for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var pe *confetti.ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("Expected a ParseError, got %T", err)
+		}
+		got = append(got, pe.Error()+" in "+strconv.Quote(pe.Line))
+	}
+	want := []string{
+		`app.conf:1:6: illegal character U+0001 in "name \x01app"`,
+		`app.conf:2:3: illegal character U+0002 in "# \x02 pasted"`,
+		`app.conf:3:8: illegal character U+0003 in "port \"8\x030\""`,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Expected errors:\n%s\nGot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	const src = "\ufeffserver {\n    root /srv\r\n\tport 80\n}\n\u001a"
+	doc, err := confetti.Parse(src)
+	if err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	} else if len(doc.Diagnostics) != 0 {
+		t.Fatalf("Expected no warnings without WithWarnings, got %v", doc.Diagnostics)
+	}
+
+	if doc, err = confetti.Parse(src, confetti.WithWarnings()); err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	}
+	var got []string
+	for _, d := range doc.Diagnostics {
+		if d.Severity != confetti.SeverityWarning {
+			t.Fatalf("Expected a warning, got severity %s", d.Severity)
+		}
+		got = append(got, d.Pos.String()+": "+d.Message)
+	}
+	want := []string{
+		"1:1: byte order mark",
+		`2:14: mixed line terminators "\n" and "\r\n"`,
+		"3:1: indented with tabs after lines indented with spaces",
+		"5:1: ^Z at the end of the source",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Expected warnings:\n%s\nGot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	// warnings about the whole source are found again after an edit
+	for _, e := range []struct{ src, at, text string }{
+		{"a 1\nb 2\n\tc 3\nd 4\ne 5\nf 6\n", "e 5", "  x\n"},
+		{"a 1\r\nb 2\r\nc 3\r\nd 4\r\n", "c 3", "x\n"},
+	} {
+		doc, err := confetti.Parse(e.src, confetti.WithWarnings())
+		if err != nil {
+			t.Fatalf("Failed to parse document: %v", err)
+		}
+		at := strings.Index(e.src, e.at)
+		if doc, err = doc.Reparse(confetti.Range{Start: confetti.Position{Offset: at}, End: confetti.Position{Offset: at}}, e.text); err != nil {
+			t.Fatalf("Failed to reparse document: %v", err)
+		}
+		want, err := confetti.Parse(e.src[:at]+e.text+e.src[at:], confetti.WithWarnings())
+		if err != nil {
+			t.Fatalf("Failed to parse document: %v", err)
+		} else if len(want.Diagnostics) == 0 || !slices.Equal(doc.Diagnostics, want.Diagnostics) {
+			t.Fatalf("Expected warnings %v after reparsing, got %v", want.Diagnostics, doc.Diagnostics)
+		}
+	}
+}
+
+func TestTranslator(t *testing.T) {
+	french := confetti.Catalog{
+		2:  "caractère interdit {detail}",
+		5:  "argument entre guillemets non fermé, ouvert en {start}",
+		19: "marque d'ordre des octets",
+	}
+
+	_, err := confetti.Parse("a \"b\nc", confetti.WithName("app.conf"), confetti.WithTranslator(french.Translate))
+	if err == nil || err.Error() != "app.conf:1:5: argument entre guillemets non fermé, ouvert en 1:3" {
+		t.Fatalf("Expected a translated error, got %v", err)
+	} else if !errors.Is(err, confetti.ErrUnclosedQuoted) {
+		t.Fatalf("Expected ErrUnclosedQuoted, got %v", err)
+	}
+
+	for _, err = range confetti.Tokens("a \"b\nc", confetti.WithTranslator(french.Translate)) {
+	}
+	if err == nil || err.Error() != "1:5: argument entre guillemets non fermé, ouvert en 1:3" {
+		t.Fatalf("Expected a translated error from Tokens, got %v", err)
+	}
+
+	_, err = confetti.Parse("a \u0001\nb \u0002\n", confetti.WithAllIllegalCharacters(), confetti.WithTranslator(french.Translate))
+	if err == nil || err.Error() != "1:3: caractère interdit U+0001\n2:3: caractère interdit U+0002" {
+		t.Fatalf("Expected translated errors, got %v", err)
+	}
+
+	// messages missing from the catalog stay in English
+	doc, err := confetti.Parse("\ufeffa\n\u001a", confetti.WithWarnings(), confetti.WithTranslator(french.Translate))
+	if err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	} else if len(doc.Diagnostics) != 2 || doc.Diagnostics[0].Message != "marque d'ordre des octets" || doc.Diagnostics[1].Message != "^Z at the end of the source" {
+		t.Fatalf("Unexpected diagnostics %v", doc.Diagnostics)
+	} else if doc.Diagnostics[1].Code != 20 {
+		t.Fatalf("Expected code E0020, got %s", doc.Diagnostics[1].Code)
+	}
+}
+
+func TestModes(t *testing.T) {
+	const src = "a \"b\nc }\nd {\n    e \u0001\n"
+
+	if _, err := confetti.Parse(src); err == nil {
+		t.Fatal("Expected an error in standard mode")
+	}
+
+	doc, err := confetti.Parse(src, confetti.WithMode(confetti.ModeLenient))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration leniently: %v", err)
+	} else if len(doc.Directives) != 3 || doc.Directives[0].Arguments[1] != "b" || len(doc.Directives[2].Subdirectives) != 1 {
+		t.Fatalf("Unexpected directives %v", doc.Directives)
+	}
+
+	var got []string
+	for _, d := range doc.Diagnostics {
+		if d.Severity != confetti.SeverityWarning {
+			t.Fatalf("Expected a warning, got severity %d", d.Severity)
+		}
+		got = append(got, d.Pos.String()+": "+d.Message)
+	}
+	want := []string{"1:5: unclosed quoted, started at 1:3", "4:7: illegal character U+0001", "2:3: found '}' without matching '{'", "3:3: expected '}'"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Expected diagnostics %q, got %q", want, got)
+	}
+
+	const bom = "\ufeffa \ufeff\n"
+	if _, err = confetti.Parse(bom); err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+	var pe *confetti.ParseError
+	if _, err = confetti.Parse(bom, confetti.WithMode(confetti.ModeStrict)); !errors.As(err, &pe) || !errors.Is(err, confetti.ErrIllegalCharacter) {
+		t.Fatalf("Expected ErrIllegalCharacter in strict mode, got %v", err)
+	} else if pe.Pos.String() != "1:3" {
+		t.Fatalf("Expected error at 1:3, got %s", pe.Pos)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	var args []string
+	for tok, err := range confetti.Tokens("server \"a b\" {\n    listen 80\n}\n") {
+		if err != nil {
+			t.Fatalf("Failed to lex configuration: %v", err)
+		} else if tok.Kind == confetti.TokenOpenBrace {
+			break
+		} else if tok.Kind != confetti.TokenWhitespace {
+			args = append(args, tok.Text+"="+tok.Value)
+		}
+	}
+	if !slices.Equal(args, []string{"server=server", "\"a b\"=a b"}) {
+		t.Fatalf("Unexpected tokens %q", args)
+	}
+
+	var n int
+	var pe *confetti.ParseError
+	for tok, err := range confetti.Tokens("a \"b\nc\"", confetti.WithName("x.conf")) {
+		if err == nil {
+			n++
+		} else if !errors.As(err, &pe) {
+			t.Fatalf("Expected a ParseError, got %T", err)
+		} else if tok != (confetti.Token{}) {
+			t.Fatalf("Expected a zero token with the error, got %v", tok)
+		}
+	}
+	if n != 2 || pe == nil {
+		t.Fatalf("Expected 2 tokens then an error, got %d tokens and %v", n, pe)
+	} else if pe.Pos.String() != "x.conf:1:5" {
+		t.Fatalf("Expected error at x.conf:1:5, got %s", pe.Pos)
+	}
+
+	for tok := range confetti.Tokens("# note") {
+		if got := tok.String(); got != `1:1: comment "# note"` {
+			t.Fatalf("Unexpected token string %s", got)
+		}
+	}
+	if got := confetti.TokenKind(99).String(); got != "TokenKind(99)" {
+		t.Fatalf("Unexpected kind string %s", got)
+	}
+}
+
+func TestSemanticTokens(t *testing.T) {
+	sts, err := confetti.SemanticTokens("server \"a b\" { # web\n    listen 80; \"\"\"x\"\"\" y\n}\n")
+	if err != nil {
+		t.Fatalf("Failed to classify tokens: %v", err)
+	}
+
+	var got []string
+	for _, st := range sts {
+		got = append(got, fmt.Sprintf("%s %s", st.Pos, st.Kind))
+	}
+	want := []string{
+		"1:1 directive-name", "1:8 string", "1:14 punctuation", "1:16 comment",
+		"2:5 directive-name", "2:12 argument", "2:14 punctuation", "2:16 directive-name", "2:24 argument",
+		"3:1 punctuation",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Unexpected semantic tokens\n got %q\nwant %q", got, want)
+	}
+
+	if _, err = confetti.SemanticTokens("a {"); err == nil {
+		t.Fatal("Expected an error for an unclosed block")
+	}
+}
+
+func TestReparse(t *testing.T) {
+	src := "# web\nserver a {\n    listen 80\n}\n\nserver b {\n    listen 81\n}\nlog on\n"
+	doc, err := confetti.Parse(src, confetti.WithName("x.conf"))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	// each edit replaces n bytes at the first match of at, or at the end of the source if at is empty
+	edits := []struct {
+		at   string
+		n    int
+		text string
+	}{
+		{"80", 2, "8080"},                        // an argument of the first block
+		{"# web", 0, "first\n"},                  // before every directive
+		{"listen 81", 0, "tls\n    # tls\n    "}, // a new subdirective
+		{"log on", 0, "# "},                      // a directive commented out
+		{"", 0, "end"},                           // after every directive
+	}
+	for _, e := range edits {
+		start := len(src)
+		if e.at != "" {
+			start = strings.Index(src, e.at)
+		}
+		end := start + e.n
+		src = src[:start] + e.text + src[end:]
+		if doc, err = doc.Reparse(confetti.Range{Start: confetti.Position{Offset: start}, End: confetti.Position{Offset: end}}, e.text); err != nil {
+			t.Fatalf("Failed to reparse %q: %v", src, err)
+		}
+		want, err := confetti.Parse(src, confetti.WithName("x.conf"))
+		if err != nil {
+			t.Fatalf("Failed to parse configuration: %v", err)
+		}
+
+		var got, exp []string
+		confetti.Walk(doc.Directives, func(d *confetti.Directive, _ int) bool {
+			got = append(got, fmt.Sprintf("%s-%s %q %q", d.Pos, d.End, d.Arguments, d.LeadingComments))
+			return true
+		})
+		confetti.Walk(want.Directives, func(d *confetti.Directive, _ int) bool {
+			exp = append(exp, fmt.Sprintf("%s-%s %q %q", d.Pos, d.End, d.Arguments, d.LeadingComments))
+			return true
+		})
+		if !slices.Equal(got, exp) {
+			t.Fatalf("Reparse of %q differs from Parse\n got %q\nwant %q", src, got, exp)
+		} else if !slices.Equal(doc.Trivia, want.Trivia) {
+			t.Fatalf("Reparse of %q has different trivia", src)
+		}
+	}
+
+	if _, err = doc.Reparse(confetti.Range{Start: confetti.Position{Offset: 0}, End: confetti.Position{Offset: 0}}, "open {\n"); !errors.Is(err, confetti.ErrExpectedCloseBrace) {
+		t.Fatalf("Expected ErrExpectedCloseBrace, got %v", err)
+	} else if _, err = doc.Reparse(confetti.Range{End: confetti.Position{Offset: len(src) + 1}}, ""); err == nil {
+		t.Fatal("Expected an error for a range outside the source")
+	} else if _, err = (confetti.Document{}).Reparse(confetti.Range{}, "a"); err == nil {
+		t.Fatal("Expected an error for a document not parsed from source")
+	}
+}
+
+// fuzzSeeds exercise escapes, quoting, comments and brace matching.
+var fuzzSeeds = []string{
+	"",
+	"server {\n    listen 80\n}\n",
+	"a \"b c\" \"\"\"d\ne\"\"\"\n",
+	"a \\\"b \"c\\\\\" \"\"\"\\\"\"\"\"\n",
+	"a \\\n  b\r\nc; d # e\n",
+	"a\n{\n    b { c }\n}\n",
+	"a { b } {}",
+	"{ a }",
+	"a {{ b } }",
+	"a \u0001",
+	"\ufeffa\u001a",
+	"a // b\n/* c\n*/ (d (e)) f\n",
+	"\u0085\ufeff",
+}
+
+func FuzzLex(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		var b strings.Builder
+		for tok, err := range confetti.Tokens(src, confetti.WithExtensions(confetti.Extensions{
+			confetti.ExtCStyleComments:      "",
+			confetti.ExtExpressionArguments: "",
+		})) {
+			if err != nil {
+				return
+			} else if tok.Pos.Offset != b.Len() || tok.End.Offset != b.Len()+len(tok.Text) {
+				t.Fatalf("Token %q at offsets %d-%d, expected %d", tok.Text, tok.Pos.Offset, tok.End.Offset, b.Len())
+			}
+			b.WriteString(tok.Text)
+		}
+		if b.String() != src {
+			t.Fatalf("Tokens do not cover the source\n-- Expected:\n%q\n-- Got:\n%q", src, b.String())
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		doc, err := confetti.Parse(src)
+		if err != nil {
+			return
+		}
+
+		back, err := confetti.Parse(doc.String())
+		if err != nil {
+			t.Fatalf("Failed to parse encoded document: %v\n%s", err, doc)
+		} else if len(back.Directives) != len(doc.Directives) {
+			t.Fatalf("Expected %d directives, got %d", len(doc.Directives), len(back.Directives))
+		}
+		for i, d := range back.Directives {
+			if !d.Equals(doc.Directives[i]) {
+				t.Fatalf("Directive mismatch at index %d\nExpected:\n%v\nGot:\n%v", i, doc.Directives[i], d)
+			}
+		}
+
+		if lossless, err := confetti.Parse(src, confetti.WithLossless()); err == nil && lossless.String() != src {
+			t.Fatalf("Lossless output mismatch\n-- Expected:\n%q\n-- Got:\n%q", src, lossless.String())
+		}
+	})
+}
+
+func BenchmarkParse(b *testing.B) {
+	var src strings.Builder
+	for i := range 1000 {
+		fmt.Fprintf(&src, "server%d {\n    listen %d # port\n    root \"/srv/www\" \"\"\"multi\nline\"\"\"\n}\n", i, 8000+i)
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := confetti.Parse(src.String()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReparse(b *testing.B) {
+	var src strings.Builder
+	for i := range 1000 {
+		fmt.Fprintf(&src, "server%d {\n    listen %d # port\n    root \"/srv/www\" \"\"\"multi\nline\"\"\"\n}\n", i, 8000+i)
+	}
+	doc, err := confetti.Parse(src.String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	off := strings.Index(src.String(), "8500")
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := doc.Reparse(confetti.Range{Start: confetti.Position{Offset: off}, End: confetti.Position{Offset: off + 4}}, "9500"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a, err := confetti.Parse(`name example # old
+server {
+    listen 80
+    root /srv
+}
+upstream app1 { weight 1 }
+upstream app2 { weight 2 }
+debug on
+`, confetti.WithName("a.conf"))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+	b, err := confetti.Parse(`name example # new
+server {
+    listen 443
+    root /srv
+    tls on
+}
+upstream app2 { weight 3 }
+upstream app1 { weight 1 }
+`, confetti.WithName("b.conf"))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	var got []string
+	for _, c := range confetti.Diff(a, b) {
+		got = append(got, c.String())
+	}
+	expected := []string{
+		"server.listen: modified",
+		"server.tls: added",
+		"upstream.weight: modified",
+		"debug: removed",
+	}
+	if !slices.Equal(got, expected) {
+		t.Fatalf("Change mismatch\nExpected:\n%q\nGot:\n%q", expected, got)
+	} else if cs := confetti.Diff(a, a); len(cs) != 0 {
+		t.Fatalf("Expected no changes, got %v", cs)
+	}
+
+	diff, err := confetti.UnifiedDiff(a, b)
+	if err != nil {
+		t.Fatalf("Failed to render diff: %v", err)
+	}
+	const expectedDiff = `--- a.conf
++++ b.conf
+@@ server.listen @@
+-listen 80
++listen 443
+@@ server.tls @@
++tls on
+@@ upstream.weight @@
+-weight 2
++weight 3
+@@ debug @@
+-debug on
+`
+	if diff != expectedDiff {
+		t.Fatalf("Diff mismatch\nExpected:\n%s\nGot:\n%s", expectedDiff, diff)
+	}
+}
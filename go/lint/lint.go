@@ -0,0 +1,221 @@
+// Package lint checks Confetti sources for problems that are not errors, such as inconsistent indentation and duplicate directives, with built-in rules and rules of its users' own.
+package lint
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	confetti "github.com/Heliodex/confetti"
+)
+
+// File is a source being checked.
+type File struct {
+	// Src is the source, and Doc and Tokens are what it parsed and lexed to. Tokens is nil if Src does not lex, as when ModeLenient repaired it to parse it.
+	Src    string
+	Doc    confetti.Document
+	Tokens []confetti.Token
+}
+
+// Rule checks a file for one kind of problem.
+type Rule interface {
+	// Name identifies the rule in diagnostics, such as "empty-block".
+	Name() string
+	// Check returns the problems the rule finds in f.
+	Check(f *File) []confetti.Diagnostic
+}
+
+// Diagnostic is a problem found by a rule.
+type Diagnostic struct {
+	// Rule is the name of the rule that found the problem.
+	Rule string
+	confetti.Diagnostic
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s (%s)", d.Pos, d.Message, d.Rule)
+}
+
+// Lint parses src with opts and checks it with rules, returning the problems they find, and those reported by the parser, such as in ModeLenient or WithWarnings, with the rule "parse", in order of position. Rules reading tokens find nothing in sources that parse only once repaired. It fails if src cannot be parsed; FromError turns the error into a diagnostic.
+func Lint(src []byte, rules []Rule, opts ...confetti.Option) ([]Diagnostic, error) {
+	f := &File{Src: string(src)}
+	var err error
+	if f.Doc, err = confetti.Parse(f.Src, opts...); err != nil {
+		return nil, err
+	}
+	for t, err := range confetti.Tokens(f.Src, opts...) {
+		if err != nil {
+			f.Tokens = nil // the parser repaired the source, so its problems are in the document's diagnostics
+			break
+		}
+		f.Tokens = append(f.Tokens, t)
+	}
+
+	var diags []Diagnostic
+	for _, d := range f.Doc.Diagnostics {
+		diags = append(diags, Diagnostic{ParseRule, d})
+	}
+	for _, r := range rules {
+		for _, d := range r.Check(f) {
+			diags = append(diags, Diagnostic{r.Name(), d})
+		}
+	}
+	slices.SortStableFunc(diags, func(a, b Diagnostic) int {
+		return cmp.Compare(a.Pos.Offset, b.Pos.Offset)
+	})
+	return diags, nil
+}
+
+// ParseRule is the rule of diagnostics reported by the parser rather than by a rule.
+const ParseRule = "parse"
+
+// FromError returns a diagnostic for an error from Lint or confetti.Parse, at the position of the problem if it is a *confetti.ParseError.
+func FromError(err error) Diagnostic {
+	var pe *confetti.ParseError
+	if errors.As(err, &pe) {
+		return Diagnostic{ParseRule, pe.Diagnostic()}
+	}
+	return Diagnostic{ParseRule, confetti.Diagnostic{Severity: confetti.SeverityError, Message: err.Error(), Code: confetti.CodeOf(err)}}
+}
+
+// DefaultMaxDepth is the depth of nesting at which the nesting rule of DefaultRules reports directives.
+const DefaultMaxDepth = 5
+
+// DefaultRules returns the built-in rules: Indentation, EmptyBlocks, Duplicates, Nesting(DefaultMaxDepth), and TrailingWhitespace.
+func DefaultRules() []Rule {
+	return []Rule{Indentation, EmptyBlocks, Duplicates, Nesting(DefaultMaxDepth), TrailingWhitespace}
+}
+
+type rule struct {
+	name  string
+	check func(f *File) []confetti.Diagnostic
+}
+
+func (r rule) Name() string {
+	return r.name
+}
+
+func (r rule) Check(f *File) []confetti.Diagnostic {
+	return r.check(f)
+}
+
+// NewRule returns a rule with the given name that checks files with check.
+func NewRule(name string, check func(f *File) []confetti.Diagnostic) Rule {
+	return rule{name, check}
+}
+
+func warning(pos confetti.Position, format string, a ...any) confetti.Diagnostic {
+	return confetti.Diagnostic{Severity: confetti.SeverityWarning, Message: fmt.Sprintf(format, a...), Pos: pos}
+}
+
+// Indentation reports directives starting a line that are not indented by the same string for each level of nesting. The string is that of the first indented directive of the first level.
+var Indentation = NewRule("indentation", func(f *File) (diags []confetti.Diagnostic) {
+	indents := map[int]string{} // by the offset of the end of the indentation
+	run := ""
+	for _, t := range f.Tokens {
+		if t.Kind != confetti.TokenWhitespace || t.Pos.Column != 1 && run == "" {
+			run = ""
+			continue
+		}
+		run += t.Text
+		indents[t.End.Offset] = run
+	}
+
+	var unit string
+	known := false
+	confetti.Walk(f.Doc.Directives, func(d *confetti.Directive, depth int) bool {
+		indent, ok := indents[d.Pos.Offset]
+		if !ok && d.Pos.Column != 1 {
+			return true // not the first on its line
+		}
+
+		if !known && depth == 1 {
+			unit, known = indent, true
+		}
+		if want := strings.Repeat(unit, depth); (known || depth == 0) && indent != want {
+			diags = append(diags, warning(d.Pos, "indented with %s, expected %s", strconv.Quote(indent), strconv.Quote(want)))
+		}
+		return true
+	})
+	return
+})
+
+// EmptyBlocks reports blocks with nothing but white space in them. Blocks with comments are not reported.
+var EmptyBlocks = NewRule("empty-block", func(f *File) (diags []confetti.Diagnostic) {
+	open := -1 // index of the open brace of a block with nothing in it so far
+	for i, t := range f.Tokens {
+		switch t.Kind {
+		case confetti.TokenOpenBrace:
+			open = i
+		case confetti.TokenCloseBrace:
+			if open >= 0 {
+				diags = append(diags, warning(f.Tokens[open].Pos, "empty block"))
+			}
+			open = -1
+		case confetti.TokenWhitespace, confetti.TokenNewline:
+		default:
+			open = -1
+		}
+	}
+	return
+})
+
+// Duplicates reports directives with the same arguments and equal subdirectives as a sibling before them, which Dedupe would remove.
+var Duplicates = NewRule("duplicate", func(f *File) (diags []confetti.Diagnostic) {
+	var check func(p []confetti.Directive)
+	check = func(p []confetti.Directive) {
+		for i, d := range p {
+			if j := slices.IndexFunc(p[:i], d.Equals); j >= 0 {
+				diags = append(diags, warning(d.Pos, "duplicate of the directive at %s", p[j].Pos))
+			}
+			check(d.Subdirectives)
+		}
+	}
+	check(f.Doc.Directives)
+	return
+})
+
+// Nesting returns a rule reporting directives nested more than max levels deep, so that with a max of 1, the subdirectives of top-level directives may not have subdirectives. Directives within those reported are not reported again.
+func Nesting(max int) Rule {
+	return NewRule("nesting", func(f *File) (diags []confetti.Diagnostic) {
+		confetti.Walk(f.Doc.Directives, func(d *confetti.Directive, depth int) bool {
+			if depth > max {
+				diags = append(diags, warning(d.Pos, "nested %d levels deep, more than %d", depth, max))
+				return false
+			}
+			return true
+		})
+		return
+	})
+}
+
+// TrailingWhitespace reports white space at the end of lines, including at the end of comments.
+var TrailingWhitespace = NewRule("trailing-whitespace", func(f *File) (diags []confetti.Diagnostic) {
+	start := -1 // index of the first of a run of white space
+	for i, t := range f.Tokens {
+		switch t.Kind {
+		case confetti.TokenWhitespace:
+			if start < 0 {
+				start = i
+			}
+			if i+1 == len(f.Tokens) || f.Tokens[i+1].Kind == confetti.TokenNewline {
+				diags = append(diags, warning(f.Tokens[start].Pos, "trailing white space"))
+			}
+			continue
+		case confetti.TokenComment:
+			if text := strings.TrimRightFunc(t.Text, unicode.IsSpace); len(text) < len(t.Text) && !strings.HasPrefix(t.Text, "/*") {
+				pos := t.Pos
+				pos.Offset += len(text)
+				pos.Column += utf8.RuneCountInString(text)
+				diags = append(diags, warning(pos, "trailing white space"))
+			}
+		}
+		start = -1
+	}
+	return
+})
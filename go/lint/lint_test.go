@@ -0,0 +1,134 @@
+package lint_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	confetti "github.com/Heliodex/confetti"
+	"github.com/Heliodex/confetti/lint"
+)
+
+const src = `# settings 
+server {
+    listen 80
+	listen 80
+    location / {}
+    a { b { c } }
+}
+`
+
+func TestLint(t *testing.T) {
+	diags, err := lint.Lint([]byte(src), append(lint.DefaultRules(), lint.Nesting(1)))
+	if err != nil {
+		t.Fatalf("Failed to lint source: %v", err)
+	}
+
+	var got []string
+	for _, d := range diags {
+		if d.Severity != confetti.SeverityWarning {
+			t.Fatalf("Expected a warning, got severity %d", d.Severity)
+		}
+		got = append(got, d.String())
+	}
+	expected := []string{
+		`1:11: trailing white space (trailing-whitespace)`,
+		`4:2: indented with "\t", expected "    " (indentation)`,
+		`4:2: duplicate of the directive at 3:5 (duplicate)`,
+		`5:16: empty block (empty-block)`,
+		`6:9: nested 2 levels deep, more than 1 (nesting)`,
+	}
+	if !slices.Equal(got, expected) {
+		t.Fatalf("Diagnostics mismatch\n-- Expected:\n%s\n-- Got:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	if _, err = lint.Lint([]byte("a {"), lint.DefaultRules()); err == nil {
+		t.Fatal("Expected an error for a source that does not parse")
+	}
+
+	// sources that parse only once repaired report what was repaired
+	for _, src := range []string{"a \"b\n", "a \x01 b\n"} {
+		diags, err := lint.Lint([]byte(src), lint.DefaultRules(), confetti.WithMode(confetti.ModeLenient))
+		if err != nil {
+			t.Fatalf("Failed to lint %q: %v", src, err)
+		} else if len(diags) != 1 || diags[0].Rule != lint.ParseRule {
+			t.Fatalf("Expected a diagnostic from the parser for %q, got %v", src, diags)
+		}
+	}
+}
+
+func TestCustomRule(t *testing.T) {
+	noRoot := lint.NewRule("no-root", func(f *lint.File) (diags []confetti.Diagnostic) {
+		for _, m := range f.Doc.Find(func(d *confetti.Directive) bool { return d.Name() == "root" }) {
+			diags = append(diags, confetti.Diagnostic{Severity: confetti.SeverityError, Message: "root is not allowed", Pos: m.Directive.Pos})
+		}
+		return
+	})
+
+	diags, err := lint.Lint([]byte("name app\nserver {\n    root /srv\n}\n"), []lint.Rule{noRoot})
+	if err != nil {
+		t.Fatalf("Failed to lint source: %v", err)
+	} else if len(diags) != 1 || diags[0].Rule != "no-root" || diags[0].Severity != confetti.SeverityError || diags[0].Pos.Line != 3 {
+		t.Fatalf("Unexpected diagnostics %v", diags)
+	}
+}
+
+func TestReports(t *testing.T) {
+	diags, err := lint.Lint([]byte("a {\n}\n"), lint.DefaultRules(), confetti.WithName("app.conf"))
+	if err != nil {
+		t.Fatalf("Failed to lint source: %v", err)
+	}
+	_, err = confetti.Parse(`a "b`, confetti.WithName("bad.conf"))
+	diags = append(diags, lint.FromError(err))
+
+	data, err := json.Marshal(diags)
+	if err != nil {
+		t.Fatalf("Failed to marshal diagnostics: %v", err)
+	}
+	const expected = `[{"rule":"empty-block","severity":"warning","message":"empty block","file":"app.conf","line":1,"column":3},` +
+		`{"rule":"parse","code":"E0005","severity":"error","message":"unclosed quoted, started at 1:3","file":"bad.conf","line":1,"column":5}]`
+	if string(data) != expected {
+		t.Fatalf("JSON mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, data)
+	}
+
+	var b bytes.Buffer
+	if err = lint.WriteSARIF(&b, "confetti", diags); err != nil {
+		t.Fatalf("Failed to write SARIF: %v", err)
+	}
+	var log struct {
+		Version string
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string
+					Rules []struct{ ID string }
+				}
+			}
+			Results []struct {
+				RuleID    string
+				Level     string
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine, StartColumn int }
+					}
+				}
+			}
+		}
+	}
+	if err = json.Unmarshal(b.Bytes(), &log); err != nil {
+		t.Fatalf("Failed to read SARIF: %v", err)
+	} else if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("Unexpected SARIF log %s", b.String())
+	}
+	run := log.Runs[0]
+	if run.Tool.Driver.Name != "confetti" || len(run.Tool.Driver.Rules) != 2 || len(run.Results) != 2 {
+		t.Fatalf("Unexpected SARIF run %s", b.String())
+	}
+	r := run.Results[1]
+	if r.RuleID != "parse" || r.Level != "error" || r.Locations[0].PhysicalLocation.ArtifactLocation.URI != "bad.conf" || r.Locations[0].PhysicalLocation.Region.StartColumn != 5 {
+		t.Fatalf("Unexpected SARIF result %s", b.String())
+	}
+}
@@ -0,0 +1,119 @@
+package lint
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+
+	confetti "github.com/Heliodex/confetti"
+)
+
+// jsonDiagnostic is the JSON form of a diagnostic, whose fields are kept stable for the tools reading it.
+type jsonDiagnostic struct {
+	Rule     string `json:"rule"`
+	Code     string `json:"code,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+}
+
+// MarshalJSON encodes the diagnostic as an object with the fields "rule", "code" if it has one, such as "E0005", "severity" ("error", "warning", or "info"), and "message", and, where they are known, "file", "line", and "column", with lines and columns starting at 1.
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	code := ""
+	if d.Code != 0 {
+		code = d.Code.String()
+	}
+	return json.Marshal(jsonDiagnostic{d.Rule, code, d.Severity.String(), d.Message, d.Pos.Filename, d.Pos.Line, d.Pos.Column})
+}
+
+// the parts of SARIF 2.1.0 written by WriteSARIF
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool       sarifTool     `json:"tool"`
+		ColumnKind string        `json:"columnKind"`
+		Results    []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name  string      `json:"name"`
+		Rules []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID string `json:"id"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation *sarifArtifactLocation `json:"artifactLocation,omitempty"`
+		Region           *sarifRegion           `json:"region,omitempty"`
+	}
+	sarifArtifactLocation struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn"`
+	}
+)
+
+var sarifLevels = [...]string{
+	confetti.SeverityError:   "error",
+	confetti.SeverityWarning: "warning",
+	confetti.SeverityInfo:    "note",
+}
+
+// WriteSARIF writes diags to w as a SARIF 2.1.0 log of a single run of a tool with the given name, for code review and continuous integration systems to read. Each diagnostic's file name, if it has one, is written as the URI of its file.
+func WriteSARIF(w io.Writer, tool string, diags []Diagnostic) error {
+	run := sarifRun{
+		Tool:       sarifTool{sarifDriver{Name: tool, Rules: []sarifRule{}}},
+		ColumnKind: "unicodeCodePoints",
+		Results:    []sarifResult{},
+	}
+
+	for _, d := range diags {
+		if !slices.Contains(run.Tool.Driver.Rules, sarifRule{d.Rule}) {
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{d.Rule})
+		}
+
+		level := "none"
+		if int(d.Severity) < len(sarifLevels) {
+			level = sarifLevels[d.Severity]
+		}
+		r := sarifResult{RuleID: d.Rule, Level: level, Message: sarifMessage{d.Message}}
+
+		var loc sarifPhysicalLocation
+		if d.Pos.Filename != "" {
+			loc.ArtifactLocation = &sarifArtifactLocation{d.Pos.Filename}
+		}
+		if d.Pos.IsValid() {
+			loc.Region = &sarifRegion{d.Pos.Line, d.Pos.Column}
+		}
+		if loc != (sarifPhysicalLocation{}) {
+			r.Locations = []sarifLocation{{loc}}
+		}
+		run.Results = append(run.Results, r)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{"2.1.0", "https://json.schemastore.org/sarif-2.1.0.json", []sarifRun{run}})
+}
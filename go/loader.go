@@ -0,0 +1,94 @@
+package confetti
+
+import (
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Loader reads a configuration spread over several files, such as /etc/app.conf, ~/.config/app.conf, and ./app.conf, later files taking precedence over earlier ones.
+type Loader struct {
+	// Paths are the files to read, in increasing precedence. Files that do not exist are skipped, directories are read as by ParseDir, and a leading "~/" stands for the user's home directory.
+	Paths []string
+	// Options are used to parse each file, which is named by its path.
+	Options []Option
+	// Policy selects how directives of the same name in different files combine, as in Merge.
+	Policy MergePolicy
+}
+
+// Load reads the files that exist and merges them in order with Merge, returning an empty document if there are none. Each directive keeps the position it was parsed at, so its Pos.Filename is the path of the file it came from.
+func (l Loader) Load() (Document, error) {
+	var doc Document
+	read := false
+	for _, path := range l.Paths {
+		if rest, ok := strings.CutPrefix(path, "~/"); ok {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return Document{}, err
+			}
+			path = filepath.Join(home, rest)
+		}
+
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return Document{}, err
+		}
+		var next Document
+		if info.IsDir() {
+			next, err = ParseDir(path, l.Options...)
+		} else {
+			next, err = parseFile(path, l.Options)
+		}
+		if err != nil {
+			return Document{}, err
+		}
+
+		if read {
+			doc = Merge(doc, next, l.Policy)
+		} else {
+			doc, read = next, true
+		}
+	}
+	return doc, nil
+}
+
+// parseFile parses the file at path, named by its path.
+func parseFile(path string, opts []Option) (Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Document{}, err
+	}
+	return Parse(string(data), append([]Option{WithName(path)}, opts...)...)
+}
+
+// ParseDir parses each file in dir whose name ends in ".conf", in lexical order, as a conf.d directory is read, and returns their directives one after another in a document named dir.
+// Each file is named by its path, so the positions of its directives and diagnostics, and any error parsing it, give the file they are in. The document has no trivia.
+func ParseDir(dir string, opts ...Option) (Document, error) {
+	c := newConfig(opts)
+	if c.err != nil {
+		return Document{}, c.err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Document{}, err
+	}
+
+	doc := Document{Name: dir, Extensions: maps.Clone(c.exts)}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".conf") {
+			continue
+		}
+		part, err := parseFile(filepath.Join(dir, e.Name()), opts)
+		if err != nil {
+			return Document{}, err
+		}
+		doc.Directives = append(doc.Directives, part.Directives...)
+		doc.Diagnostics = append(doc.Diagnostics, part.Diagnostics...)
+	}
+	return doc, nil
+}
@@ -0,0 +1,226 @@
+package confetti
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// syntax is the source text of a directive, kept in lossless mode. It is never modified once attached, so copies of a directive may share it.
+type syntax struct {
+	lead  string   // text before the first argument, since the end of the previous directive
+	args  []string // arguments as written, including quotes and escapes
+	vals  []string // arguments as parsed, to detect edits
+	gaps  []string // text between consecutive arguments
+	open  string   // text after the last argument, up to and including '{'
+	close string   // text after the last subdirective, up to and including '}'
+	trail string   // text after the directive on its last line, such as ';' and comments
+}
+
+func (s *syntax) clone() *syntax {
+	if s == nil {
+		return nil
+	}
+
+	cp := *s
+	cp.args = slices.Clone(s.args)
+	cp.vals = slices.Clone(s.vals)
+	cp.gaps = slices.Clone(s.gaps)
+	return &cp
+}
+
+func tokensText(ts []Token) string {
+	var b strings.Builder
+	for _, t := range ts {
+		b.WriteString(t.Text)
+	}
+	return b.String()
+}
+
+// splitTrail splits the tokens following a directive into those on its last line and the rest.
+func splitTrail(ts []Token) (trail, rest []Token) {
+	for i, t := range ts {
+		if t.Kind == TokenNewline {
+			return ts[:i], ts[i:]
+		}
+	}
+	return ts, nil
+}
+
+var errNotLossless = errors.New("source cannot be represented losslessly")
+
+// attachSyntax records the source text of each directive in p, which must have been parsed from ts. It returns the text following the last directive.
+func attachSyntax(ts []Token, p []Directive) (tail string, err error) {
+	type frame struct {
+		list    []Directive
+		idx     int  // index of the current (or previous) directive
+		open    bool // whether the current directive is still collecting arguments
+		pending []Token
+	}
+	stack := []*frame{{list: p, idx: -1}}
+
+	// finish assigns a frame's pending tokens to its last directive's trail, returning any left over.
+	finish := func(f *frame) []Token {
+		if f.idx < 0 {
+			return f.pending
+		}
+		trail, rest := splitTrail(f.pending)
+		f.list[f.idx].syntax.trail = tokensText(trail)
+		return rest
+	}
+
+	for _, t := range ts {
+		f := stack[len(stack)-1]
+
+		switch t.Kind {
+		case TokenArgument, TokenQuotedArgument, TokenTripleQuotedArgument, TokenPunctuator:
+			if !f.open {
+				rest := finish(f)
+				if f.idx++; f.idx >= len(f.list) {
+					return "", errNotLossless
+				}
+				f.open = true
+				f.list[f.idx].syntax = &syntax{lead: tokensText(rest)}
+			} else {
+				s := f.list[f.idx].syntax
+				s.gaps = append(s.gaps, tokensText(f.pending))
+			}
+			f.pending = nil
+
+			s := f.list[f.idx].syntax
+			s.args = append(s.args, t.Text)
+			s.vals = append(s.vals, t.Value)
+
+		case TokenSemicolon, TokenNewline:
+			f.open = false
+			f.pending = append(f.pending, t)
+
+		case TokenOpenBrace:
+			f.open = false
+			if f.idx < 0 {
+				return "", errNotLossless
+			}
+
+			d := &f.list[f.idx]
+			if d.syntax.open != "" {
+				// a second block replaced the first
+				return "", errNotLossless
+			}
+			d.syntax.open = tokensText(f.pending) + "{"
+			f.pending = nil
+			stack = append(stack, &frame{list: d.Subdirectives, idx: -1})
+
+		case TokenCloseBrace:
+			if len(stack) == 1 {
+				return "", errNotLossless
+			}
+			rest := finish(f)
+			stack = stack[:len(stack)-1]
+
+			parent := stack[len(stack)-1]
+			parent.list[parent.idx].syntax.close = tokensText(rest) + "}"
+
+		default:
+			f.pending = append(f.pending, t)
+		}
+	}
+
+	if len(stack) != 1 {
+		return "", errNotLossless
+	}
+	return tokensText(finish(stack[0])), nil
+}
+
+// siblingIndent guesses the indentation of directives in p from the first one with source text.
+func siblingIndent(p []Directive) (string, bool) {
+	for _, d := range p {
+		if d.syntax == nil {
+			continue
+		}
+		lead := d.syntax.lead
+		for i := len(lead); i > 0; {
+			r, size := utf8.DecodeLastRuneInString(lead[:i])
+			if isLineTerminator(r) {
+				return lead[i:], strings.TrimFunc(lead[i:], isWhitespace) == ""
+			}
+			i -= size
+		}
+	}
+	return "", false
+}
+
+func endsLine(b *strings.Builder) bool {
+	r, _ := utf8.DecodeLastRuneInString(b.String())
+	return b.Len() == 0 || isLineTerminator(r)
+}
+
+// writeLossless writes directives using their source text where they have it, and canonical formatting indented by prefix where they do not.
+func writeLossless(b *strings.Builder, p []Directive, opts FormatOptions, prefix string) error {
+	if sibling, ok := siblingIndent(p); ok {
+		prefix = sibling
+	}
+
+	for _, d := range p {
+		s := d.syntax
+		if s == nil {
+			if !endsLine(b) {
+				b.WriteString(opts.newline())
+			}
+			var cb strings.Builder
+			if err := writeDirectives(&cb, []Directive{d}, opts, prefix); err != nil {
+				return err
+			}
+			b.WriteString(strings.TrimSuffix(cb.String(), opts.newline()))
+			continue
+		} else if len(d.Arguments) == 0 {
+			return errors.New("directive has no arguments")
+		}
+
+		b.WriteString(s.lead)
+		for i, a := range d.Arguments {
+			if i > 0 {
+				if i-1 < len(s.gaps) {
+					b.WriteString(s.gaps[i-1])
+				} else {
+					b.WriteByte(' ')
+				}
+			}
+
+			if i < len(s.vals) && a == s.vals[i] {
+				b.WriteString(s.args[i])
+				continue
+			}
+			q, err := opts.quote(a)
+			if err != nil {
+				return err
+			}
+			b.WriteString(q)
+		}
+
+		if s.open == "" && len(d.Subdirectives) == 0 {
+			b.WriteString(s.trail)
+			continue
+		}
+
+		if s.open != "" {
+			b.WriteString(s.open)
+		} else {
+			b.WriteString(" {")
+		}
+		if err := writeLossless(b, d.Subdirectives, opts, prefix+opts.indent()); err != nil {
+			return err
+		}
+		if s.close != "" {
+			b.WriteString(s.close)
+		} else {
+			if !endsLine(b) {
+				b.WriteString(opts.newline())
+			}
+			b.WriteString(prefix + "}")
+		}
+		b.WriteString(s.trail)
+	}
+
+	return nil
+}
@@ -1,7 +1,10 @@
 // package confetti implements the Confetti configuration language.
 package confetti
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 type extension uint8
 
@@ -10,6 +13,8 @@ const (
 	ExtCStyleComments
 	ExtExpressionArguments
 	ExtPunctuatorArguments
+	// ExtVariables enables variables, defined by a directive named by the extension's value, or "set" if it is empty. Unquoted arguments may then contain references such as "${name}" despite the braces. See WithExtensions.
+	ExtVariables
 )
 
 type Extensions map[extension]string
@@ -19,16 +24,144 @@ func (e Extensions) Has(ext extension) bool {
 	return ok
 }
 
-func Load(conf string, exts Extensions) ([]Directive, error) {
-	ts, err := lex(conf, exts)
+// Parse parses a Confetti source into a document.
+func Parse(src string, opts ...Option) (Document, error) {
+	return parseSource(src, newConfig(opts))
+}
+
+// read lexes and parses src.
+func (c config) read(src string) ([]Token, []Directive, error) {
+	if c.mode == ModeStrict {
+		if err := checkStrict(src); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ts, err := lex(src, c.exts)
+	if errors.Is(err, ErrIllegalCharacter) && c.allIllegal {
+		return nil, nil, illegalCharacters(src, c.name)
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if c.name != "" {
+		for i := range ts {
+			ts[i].Pos.Filename = c.name
+			ts[i].End.Filename = c.name
+		}
+	}
+	if err = normalize(ts, c.norm); err != nil {
+		return nil, nil, err
+	}
+
+	p, err := parse(ts, c.exts, c.maxDepth)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ts, p, nil
+}
+
+func parseSource(src string, c config) (_ Document, err error) {
+	if c.err != nil {
+		return Document{}, c.err
+	}
+	if c.fset != nil && c.name != "" {
+		c.fset.AddFile(c.name, src)
+	}
+	defer func() {
+		if err != nil && c.translate != nil {
+			setTranslator(err, c.translate)
+		}
+	}()
+
+	orig := src
+	ts, p, err := c.read(src)
+	var diags []Diagnostic
+	for c.mode == ModeLenient && err != nil && len(diags) < maxRepairs {
+		fixed, ok := repair(src, err)
+		if !ok {
+			break
+		}
+
+		var pe *ParseError
+		errors.As(withSource(err, src, c.name), &pe)
+		pe.translate = c.translate
+		d := pe.Diagnostic()
+		d.Severity = SeverityWarning
+		diags = append(diags, d)
+
+		src = fixed
+		ts, p, err = c.read(src)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error: %w", err)
+		return Document{}, withSource(err, src, c.name)
 	}
 
-	p, err := parse(ts, exts)
+	if c.includeDepth > 0 {
+		if c.lossless {
+			return Document{}, errors.New("includes cannot be used in lossless mode")
+		} else if p, err = c.include(p); err != nil {
+			return Document{}, withSource(err, src, c.name)
+		}
+	}
+
+	// extensions transform the document once includes are spliced in, so that included files share the including file's variables
+	if hooks, values := c.exts.hooks(); c.including == nil {
+		for i, h := range hooks {
+			if h.Transform == nil {
+				continue
+			} else if c.lossless {
+				return Document{}, errors.New("transforming extensions cannot be used in lossless mode")
+			} else if p, err = h.Transform(p, values[i]); err != nil {
+				return Document{}, withSource(err, src, c.name)
+			}
+		}
+	}
+
+	doc := newDocument(ts, p, c.exts)
+	doc.Name = c.name
+	doc.Diagnostics = diags
+	if c.warnings {
+		doc.Diagnostics = append(doc.Diagnostics, warnings(ts, c.translate)...)
+	}
+	doc.src, doc.conf = orig, &c
+
+	if c.lossless {
+		if doc.tail, err = attachSyntax(ts, doc.Directives); err != nil {
+			return Document{}, err
+		}
+		doc.lossless = true
+
+		// make sure the source can be reproduced
+		if out := doc.String(); out != src {
+			return Document{}, errNotLossless
+		}
+	}
+
+	return doc, nil
+}
+
+// Load parses a Confetti source. It is equivalent to Parse, except that errors are prefixed with "error: " and carry no location. Each of several errors, as WithAllIllegalCharacters reports, is prefixed on its own line.
+func Load(conf string, opts ...Option) (Document, error) {
+	doc, err := Parse(conf, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("error: %w", err)
+		return Document{}, loadError(err)
+	}
+	return doc, nil
+}
+
+// loadError returns err as Load reports it.
+func loadError(err error) error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, err := range joined.Unwrap() {
+			errs = append(errs, loadError(err))
+		}
+		return errors.Join(errs...)
 	}
 
-	return p, nil
+	var pe *ParseError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	return fmt.Errorf("error: %w", err)
 }
@@ -1,13 +1,22 @@
 package confetti
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
 
-const testsDir = "../confetti/tests/conformance"
+// testsDir returns the directory of the Confetti conformance tests, from the upstream repository's submodule unless CONFETTI_CONFORMANCE_DIR is set. The tests fail if it is missing, unless CONFETTI_SKIP_CONFORMANCE is set.
+func testsDir() string {
+	if dir := os.Getenv("CONFETTI_CONFORMANCE_DIR"); dir != "" {
+		return dir
+	}
+	return "../confetti/tests/conformance"
+}
 
 type testCase struct {
 	Name          string
@@ -16,14 +25,18 @@ type testCase struct {
 }
 
 func getCases(t *testing.T) (cases []*testCase, err error) {
-	dir, err := os.ReadDir(testsDir)
-	if err != nil {
+	dir, err := os.ReadDir(testsDir())
+	if errors.Is(err, fs.ErrNotExist) && os.Getenv("CONFETTI_SKIP_CONFORMANCE") != "" {
+		t.Skipf("Conformance tests not found in %s", testsDir())
+	} else if errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Conformance tests not found in %s; run `git submodule update --init`, set CONFETTI_CONFORMANCE_DIR, or set CONFETTI_SKIP_CONFORMANCE to skip them", testsDir())
+	} else if err != nil {
 		t.Fatalf("Failed to read tests directory: %v", err)
 	}
 
 	addTest := func(c *testCase, n, v string) error {
 		// read file
-		data, err := os.ReadFile(testsDir + "/" + n + "." + v)
+		data, err := os.ReadFile(filepath.Join(testsDir(), n+"."+v))
 		if err != nil {
 			return fmt.Errorf("failed to read file %s.%s: %w", n, v, err)
 		}
@@ -40,16 +53,11 @@ func getCases(t *testing.T) (cases []*testCase, err error) {
 				c.Extensions = make(Extensions, 1)
 			}
 
-			switch v[4:] {
-			case "c_style_comments":
-				c.Extensions[ExtCStyleComments] = strdata
-			case "expression_arguments":
-				c.Extensions[ExtExpressionArguments] = strdata
-			case "punctuator_arguments":
-				c.Extensions[ExtPunctuatorArguments] = strdata
-			default:
+			ext, ok := LookupExtension(v[4:])
+			if !ok {
 				return fmt.Errorf("unknown extension %s", v[4:])
 			}
+			c.Extensions[ext] = strdata
 		default:
 			return fmt.Errorf("unknown file type %s", v)
 		}
@@ -59,8 +67,10 @@ func getCases(t *testing.T) (cases []*testCase, err error) {
 
 dirloop:
 	for _, entry := range dir {
-		split := strings.Split(entry.Name(), ".")
-		n, v := split[0], split[1]
+		n, v, ok := strings.Cut(entry.Name(), ".")
+		if !ok || entry.IsDir() {
+			continue
+		}
 
 		// search for the test case with the same name
 		for _, c := range cases {
@@ -94,10 +104,10 @@ func runConformanceTest(c *testCase, t *testing.T) {
 	rin, rout, exts := *c.Input, *c.Output, c.Extensions
 
 	var out string
-	if p, err := Load(rin, exts); err != nil {
+	if doc, err := Load(rin, WithExtensions(exts)); err != nil {
 		out = err.Error() + "\n"
 	} else {
-		out = testFormat(p, 0)
+		out = testFormat(doc.Directives, 0)
 	}
 
 	if rout != out {
@@ -111,9 +121,10 @@ func TestConformance(t *testing.T) {
 		t.Fatalf("Failed to get test cases: %v", err)
 	}
 
-	for i, c := range cases {
-		t.Logf("Test case %d\nconfetti/tests/conformance/%s.conf", i+1, c.Name)
-		runConformanceTest(c, t)
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			runConformanceTest(c, t)
+		})
 	}
 }
 
@@ -138,11 +149,12 @@ func TestReformat(t *testing.T) {
 		t.Fatalf("Failed to get test cases: %v", err)
 	}
 
-	for i, c := range cases {
+	for _, c := range cases {
 		if strings.HasPrefix(*c.Output, "error:") {
 			continue
 		}
-		t.Logf("Test case %d\nconfetti/tests/conformance/%s.conf", i+1, c.Name)
-		runReformatTest(c, t)
+		t.Run(c.Name, func(t *testing.T) {
+			runReformatTest(c, t)
+		})
 	}
 }
@@ -0,0 +1,86 @@
+// Package mapconv lets configuration libraries that work on map[string]any, such as koanf and viper, read and write Confetti, converting documents as confetti.ToValue and confetti.FromValue do.
+// The adapters implement the interfaces of those libraries by their methods alone, so this package does not depend on them.
+package mapconv
+
+import (
+	"maps"
+	"os"
+
+	confetti "github.com/Heliodex/confetti"
+)
+
+func toMap(data []byte, opts []confetti.Option) (map[string]any, error) {
+	doc, err := confetti.Parse(string(data), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return doc.ToMap(), nil
+}
+
+// Parser is a koanf Parser of Confetti, parsing with Options.
+//
+//	k.Load(file.Provider("app.conf"), mapconv.Parser{})
+type Parser struct {
+	Options []confetti.Option
+}
+
+// Unmarshal parses Confetti source into a map.
+func (p Parser) Unmarshal(data []byte) (map[string]any, error) {
+	return toMap(data, p.Options)
+}
+
+// Marshal encodes a map as Confetti source.
+func (p Parser) Marshal(m map[string]any) ([]byte, error) {
+	return confetti.Marshal(m)
+}
+
+// Provider is a koanf Provider reading a Confetti file, for use without a Parser.
+//
+//	k.Load(mapconv.File("app.conf"), nil)
+type Provider struct {
+	path string
+	opts []confetti.Option
+}
+
+// File returns a provider reading the file at path, parsed with the given options, naming it by its path.
+func File(path string, opts ...confetti.Option) *Provider {
+	return &Provider{path, append([]confetti.Option{confetti.WithName(path)}, opts...)}
+}
+
+// ReadBytes returns the contents of the file.
+func (p *Provider) ReadBytes() ([]byte, error) {
+	return os.ReadFile(p.path)
+}
+
+// Read parses the file into a map.
+func (p *Provider) Read() (map[string]any, error) {
+	data, err := p.ReadBytes()
+	if err != nil {
+		return nil, err
+	}
+	return toMap(data, p.opts)
+}
+
+// Codec is a viper codec for Confetti, parsing with Options.
+//
+//	codecs := viper.NewCodecRegistry()
+//	codecs.RegisterCodec("conf", mapconv.Codec{})
+//	v := viper.NewWithOptions(viper.WithCodecRegistry(codecs))
+type Codec struct {
+	Options []confetti.Option
+}
+
+// Encode encodes a map as Confetti source.
+func (c Codec) Encode(m map[string]any) ([]byte, error) {
+	return confetti.Marshal(m)
+}
+
+// Decode parses Confetti source into m.
+func (c Codec) Decode(data []byte, m map[string]any) error {
+	parsed, err := toMap(data, c.Options)
+	if err != nil {
+		return err
+	}
+	maps.Copy(m, parsed)
+	return nil
+}
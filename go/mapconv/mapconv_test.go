@@ -0,0 +1,87 @@
+package mapconv_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	confetti "github.com/Heliodex/confetti"
+	"github.com/Heliodex/confetti/mapconv"
+)
+
+// the interfaces of koanf and viper the adapters implement
+var (
+	_ interface {
+		Unmarshal([]byte) (map[string]any, error)
+		Marshal(map[string]any) ([]byte, error)
+	} = mapconv.Parser{}
+	_ interface {
+		ReadBytes() ([]byte, error)
+		Read() (map[string]any, error)
+	} = mapconv.File("")
+	_ interface {
+		Encode(map[string]any) ([]byte, error)
+		Decode([]byte, map[string]any) error
+	} = mapconv.Codec{}
+)
+
+const src = `name app
+allow 10.0.0.1 10.0.0.2
+server {
+    root /srv
+}
+`
+
+var expected = map[string]any{
+	"name":   "app",
+	"allow":  []any{"10.0.0.1", "10.0.0.2"},
+	"server": map[string]any{"root": "/srv"},
+}
+
+func TestParser(t *testing.T) {
+	m, err := mapconv.Parser{}.Unmarshal([]byte(src))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("Map mismatch\nExpected:\n%v\nGot:\n%v", expected, m)
+	}
+
+	data, err := mapconv.Parser{}.Marshal(m)
+	if err != nil {
+		t.Fatalf("Failed to encode map: %v", err)
+	}
+	const sorted = "allow 10.0.0.1 10.0.0.2\nname app\nserver {\n    root /srv\n}\n"
+	if string(data) != sorted {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", sorted, data)
+	}
+}
+
+func TestProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.conf")
+	if err := os.WriteFile(path, []byte(src+"port 80 /* http */\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mapconv.File(path, confetti.WithCStyleComments()).Read()
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	} else if m["port"] != "80" || m["name"] != "app" {
+		t.Fatalf("Unexpected map %v", m)
+	}
+
+	if _, err = mapconv.File(path + ".missing").Read(); err == nil {
+		t.Fatal("Expected an error reading a missing file")
+	}
+}
+
+func TestCodec(t *testing.T) {
+	m := map[string]any{"kept": "yes"}
+	if err := (mapconv.Codec{}).Decode([]byte(src), m); err != nil {
+		t.Fatalf("Failed to decode configuration: %v", err)
+	} else if m["kept"] != "yes" || !reflect.DeepEqual(m["server"], expected["server"]) {
+		t.Fatalf("Unexpected map %v", m)
+	} else if err = (mapconv.Codec{}).Decode([]byte("a {"), m); err == nil {
+		t.Fatal("Expected an error decoding invalid source")
+	}
+}
@@ -0,0 +1,79 @@
+package confetti
+
+import (
+	"maps"
+	"slices"
+)
+
+// MergePolicy selects how Merge combines directives of the same name.
+type MergePolicy uint8
+
+const (
+	// MergeReplace replaces the base directives of a name with the overlay directives of that name, at the position of the first base directive.
+	MergeReplace MergePolicy = iota
+	// MergeAppend keeps the base directives of a name and adds the overlay directives of that name after them.
+	MergeAppend
+)
+
+// Merge combines two documents, such as defaults and site-specific settings, with the directives of overlay taking precedence over those of base as selected by the policy. A directive that appears once at the same level in each document with subdirectives in both is merged recursively instead, taking the arguments of the overlay.
+// The result has the name and extensions of base and shares no memory with either document.
+func Merge(base, overlay Document, policy MergePolicy) Document {
+	return Document{
+		Name:       base.Name,
+		Directives: mergeDirectives(base.Directives, overlay.Directives, policy),
+		Extensions: maps.Clone(base.Extensions),
+	}
+}
+
+func countNames(p []Directive) map[string]int {
+	counts := map[string]int{}
+	for _, d := range p {
+		counts[d.Name()]++
+	}
+	return counts
+}
+
+func mergeDirectives(base, overlay []Directive, policy MergePolicy) []Directive {
+	out := cloneDirectives(base)
+	bcounts, ocounts := countNames(base), countNames(overlay)
+	replaced := map[string]bool{}
+
+	for _, o := range overlay {
+		name := o.Name()
+		named := func(d Directive) bool {
+			return d.Name() == name
+		}
+
+		if i := slices.IndexFunc(out, named); i >= 0 && bcounts[name] == 1 && ocounts[name] == 1 &&
+			len(out[i].Subdirectives) > 0 && len(o.Subdirectives) > 0 {
+			out[i].Arguments = slices.Clone(o.Arguments)
+			out[i].Subdirectives = mergeDirectives(out[i].Subdirectives, o.Subdirectives, policy)
+			continue
+		}
+
+		if policy == MergeReplace && !replaced[name] {
+			replaced[name] = true
+			if i := slices.IndexFunc(out, named); i >= 0 {
+				out = slices.Insert(slices.DeleteFunc(out, named), i, o.Clone())
+				continue
+			}
+		}
+
+		// after the last directive of the same name, if any
+		if i := lastIndexFunc(out, named); i >= 0 {
+			out = slices.Insert(out, i+1, o.Clone())
+		} else {
+			out = append(out, o.Clone())
+		}
+	}
+	return out
+}
+
+func lastIndexFunc(p []Directive, f func(Directive) bool) int {
+	for i := len(p) - 1; i >= 0; i-- {
+		if f(p[i]) {
+			return i
+		}
+	}
+	return -1
+}
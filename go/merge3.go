@@ -0,0 +1,131 @@
+package confetti
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Conflict is a directive changed differently in two documents merged by Merge3.
+type Conflict struct {
+	// Path is the names of the directive and its parents.
+	Path []string
+	// Base, Ours, and Theirs are the directive in each document, or the zero Directive in those it is absent from.
+	Base, Ours, Theirs Directive
+}
+
+func (c Conflict) String() string {
+	how := "modified in both"
+	switch {
+	case c.Base.Arguments == nil:
+		how = "added differently in both"
+	case c.Ours.Arguments == nil:
+		how = "removed in ours and modified in theirs"
+	case c.Theirs.Arguments == nil:
+		how = "modified in ours and removed in theirs"
+	}
+	return strings.Join(c.Path, ".") + ": " + how
+}
+
+// Merge3 applies the changes from base to theirs, such as a new version of a default configuration, to ours, a copy of base with changes of its own, such as a user's edits. Directives are matched between the documents as by Diff, ignoring comments and formatting.
+// Where both documents change a directive, differently, the directive is kept as it is in ours and a Conflict reported. A directive added by theirs goes after the directive it follows there, unless ours adds an equal one, and conflicts if ours adds a different one of the same name.
+// The result has the name and extensions of ours and shares no memory with any of the documents.
+func Merge3(base, ours, theirs Document) (Document, []Conflict) {
+	var conflicts []Conflict
+	p := merge3(base.Directives, ours.Directives, theirs.Directives, nil, &conflicts)
+	return Document{
+		Name:       ours.Name,
+		Directives: p,
+		Extensions: maps.Clone(ours.Extensions),
+	}, conflicts
+}
+
+// partners returns the index in b of the directive paired with each of a, or -1, given the pairing of b with a.
+func partners(n int, pair []int) []int {
+	in := make([]int, n)
+	for i := range in {
+		in[i] = -1
+	}
+	for j, i := range pair {
+		if i >= 0 {
+			in[i] = j
+		}
+	}
+	return in
+}
+
+func merge3(base, ours, theirs []Directive, path []string, conflicts *[]Conflict) []Directive {
+	oursPair, theirsPair := pairDirectives(base, ours), pairDirectives(base, theirs)
+	inOurs, inTheirs := partners(len(base), oursPair), partners(len(base), theirsPair)
+	conflict := func(name string, b, o, t Directive) {
+		*conflicts = append(*conflicts, Conflict{append(path[:len(path):len(path)], name), b, o, t})
+	}
+
+	out := cloneDirectives(ours)
+	keep := make([]bool, len(ours))
+	for j := range keep {
+		keep[j] = true
+	}
+	for i, b := range base {
+		oj, tj := inOurs[i], inTheirs[i]
+		switch {
+		case oj < 0 && tj < 0:
+		case tj < 0:
+			if ours[oj].Equals(b) {
+				keep[oj] = false
+			} else {
+				conflict(b.Name(), b, ours[oj], Directive{})
+			}
+		case oj < 0:
+			if !theirs[tj].Equals(b) {
+				conflict(b.Name(), b, Directive{}, theirs[tj])
+			}
+		default:
+			o, t := ours[oj], theirs[tj]
+			switch {
+			case slices.Equal(t.Arguments, b.Arguments), slices.Equal(t.Arguments, o.Arguments):
+			case slices.Equal(o.Arguments, b.Arguments):
+				out[oj].Arguments, out[oj].ArgumentSources = slices.Clone(t.Arguments), slices.Clone(t.ArgumentSources)
+			default:
+				conflict(b.Name(), b, o, t)
+			}
+			out[oj].Subdirectives = merge3(b.Subdirectives, o.Subdirectives, t.Subdirectives, append(path[:len(path):len(path)], b.Name()), conflicts)
+		}
+	}
+
+	// directives added by theirs, by the index in ours they go before
+	added := make([][]Directive, len(ours)+1)
+	at := 0
+	for j, t := range theirs {
+		if i := theirsPair[j]; i >= 0 {
+			if oj := inOurs[i]; oj >= 0 {
+				at = oj + 1
+			}
+			continue
+		}
+
+		same, equal := -1, false
+		for k, o := range ours {
+			if oursPair[k] < 0 && o.Name() == t.Name() {
+				same, equal = k, o.Equals(t)
+				if equal {
+					break
+				}
+			}
+		}
+		if same >= 0 && !equal {
+			conflict(t.Name(), Directive{}, ours[same], t)
+		} else if same < 0 {
+			added[at] = append(added[at], t.Clone())
+		}
+	}
+
+	var p []Directive
+	for k := range added {
+		p = append(p, added[k]...)
+		if k < len(ours) && keep[k] {
+			p = append(p, out[k])
+		}
+	}
+	return p
+}
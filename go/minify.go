@@ -0,0 +1,91 @@
+package confetti
+
+import (
+	"errors"
+	"strings"
+)
+
+// Minify returns the smallest source it can that parses to the same directives as src: comments, blank lines, and indentation are removed, directives are separated by semicolons rather than line terminators, and each argument is written in whichever of quotes or escapes is shorter. Options other than WithMaxDepth and those enabling extensions are ignored.
+func Minify(src []byte, opts ...Option) ([]byte, error) {
+	c := newConfig(opts)
+	if c.err != nil {
+		return nil, c.err
+	}
+	s := string(src)
+
+	ts, err := lex(s, c.exts)
+	if err != nil {
+		return nil, withSource(err, s, c.name)
+	}
+	p, err := parse(ts, c.exts, c.maxDepth)
+	if err != nil {
+		return nil, withSource(err, s, c.name)
+	}
+
+	var b strings.Builder
+	if err = minify(&b, p, FormatOptions{}.withExtensions(c.exts)); err != nil {
+		return nil, err
+	}
+	out := b.String()
+
+	if !reparses(out, p, c) {
+		return nil, errors.New("minifying changed the document")
+	}
+	return []byte(out), nil
+}
+
+// minify writes the directives p, quoted as opts say or escaped where that is shorter.
+func minify(b *strings.Builder, p []Directive, opts FormatOptions) error {
+	escaped := opts
+	escaped.Quoting = QuoteEscaped
+
+	for i, d := range p {
+		if len(d.Arguments) == 0 {
+			return errors.New("directive has no arguments")
+		} else if i > 0 && len(p[i-1].Subdirectives) == 0 {
+			// a directive after a block needs no separator
+			b.WriteByte(';')
+		}
+
+		for j, a := range d.Arguments {
+			q, err := opts.quote(a)
+			if err != nil {
+				return err
+			}
+			if e, _ := escaped.quote(a); len(e) < len(q) {
+				q = e
+			}
+			if j > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(q)
+		}
+
+		if len(d.Subdirectives) > 0 {
+			b.WriteByte('{')
+			if err := minify(b, d.Subdirectives, opts); err != nil {
+				return err
+			}
+			b.WriteByte('}')
+		}
+	}
+	return nil
+}
+
+// reparses reports whether out parses to the directives p with the options in c.
+func reparses(out string, p []Directive, c config) bool {
+	ts, err := lex(out, c.exts)
+	if err != nil {
+		return false
+	}
+	q, err := parse(ts, c.exts, c.maxDepth)
+	if err != nil || len(q) != len(p) {
+		return false
+	}
+	for i := range p {
+		if !p[i].Equals(q[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package confetti
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Mode selects how strictly Parse treats a source.
+type Mode uint8
+
+const (
+	// ModeStandard accepts exactly the sources allowed by the Confetti specification.
+	ModeStandard Mode = iota
+	// ModeStrict also rejects a byte order mark anywhere but at the start of the source, Unicode noncharacters, and a ^Z at the end of the source.
+	ModeStrict
+	// ModeLenient recovers from errors where it can, reporting each as a warning in the document's Diagnostics. It removes stray characters such as an unmatched '}' and adds missing ones such as a closing quote or brace, so the positions of later directives may be a few bytes past those in the original source.
+	ModeLenient
+)
+
+// maxRepairs is the number of errors ModeLenient recovers from before giving up.
+const maxRepairs = 100
+
+func isNoncharacter(r rune) bool {
+	return r >= 0xfdd0 && r <= 0xfdef || r&0xfffe == 0xfffe
+}
+
+// checkStrict returns an error at the first character in src that ModeStrict rejects.
+func checkStrict(src string) error {
+	s := stream{src: src, here: Position{Line: 1, Column: 1}}
+	if strings.HasPrefix(src, "\ufeff") {
+		s.src, s.here.Offset = src[3:], 3
+	}
+
+	for i, r := range s.src {
+		if r == 0xfeff || r == 0x1a || isNoncharacter(r) {
+			s.pos = i
+			return &ParseError{Pos: s.position(), Token: string(r), Err: fmt.Errorf("%w U+%04X", ErrIllegalCharacter, r)}
+		}
+	}
+	return nil
+}
+
+// illegalCharacters returns a *ParseError for each forbidden character in src, joined with errors.Join, for WithAllIllegalCharacters.
+func illegalCharacters(src, name string) error {
+	s := stream{src: strings.TrimSuffix(src, "\u001a"), here: Position{Line: 1, Column: 1}}
+	if strings.HasPrefix(src, "\ufeff") {
+		s.src, s.here.Offset = s.src[3:], 3
+	}
+
+	var errs []error
+	for i, r := range s.src {
+		if isForbidden(r) {
+			s.pos = i
+			errs = append(errs, withSource(&ParseError{Pos: s.position(), Token: string(r), Err: fmt.Errorf("%w U+%04X", ErrIllegalCharacter, r)}, src, name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// repair returns src changed to avoid the error err, for ModeLenient. Removed characters are replaced by spaces, so the positions of those after them are unchanged.
+func repair(src string, err error) (string, bool) {
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		return "", false
+	} else if errors.Is(pe.Err, ErrMalformedUTF8) {
+		return strings.ToValidUTF8(src, "\ufffd"), true
+	} else if !pe.Pos.IsValid() || pe.Pos.Offset > len(src) {
+		return "", false
+	}
+
+	off := pe.Pos.Offset
+	blank := func(n int) (string, bool) {
+		return src[:off] + strings.Repeat(" ", n) + src[off+n:], true
+	}
+	insert := func(s string) (string, bool) {
+		return src[:off] + s + src[off:], true
+	}
+
+	switch {
+	case errors.Is(pe.Err, ErrIllegalCharacter):
+		if r, size := utf8.DecodeRuneInString(src[off:]); isForbidden(r) {
+			return blank(size)
+		}
+
+	case errors.Is(pe.Err, ErrIllegalEscape), errors.Is(pe.Err, ErrIncompleteEscape):
+		if off > 0 && src[off-1] == '\\' {
+			off--
+			return blank(1)
+		} else if strings.HasPrefix(src[off:], "\\") {
+			return blank(1)
+		}
+
+	case errors.Is(pe.Err, ErrUnclosedQuoted):
+		return insert(`"`)
+
+	case errors.Is(pe.Err, ErrUnterminatedComment):
+		return insert("*/")
+
+	case errors.Is(pe.Err, ErrIncompleteExpression):
+		return insert(")")
+
+	case errors.Is(pe.Err, ErrUnexpectedSemicolon), errors.Is(pe.Err, ErrUnexpectedOpenBrace),
+		errors.Is(pe.Err, ErrUnmatchedCloseBrace), errors.Is(pe.Err, ErrUnexpectedContinuation):
+		return blank(1)
+
+	case errors.Is(pe.Err, ErrExpectedCloseBrace):
+		// close the block at the end of the source
+		off = len(strings.TrimSuffix(src, "\u001a"))
+		if r, _ := utf8.DecodeLastRuneInString(src[:off]); isLineTerminator(r) {
+			return insert("}")
+		}
+		return insert("\n}")
+	}
+
+	return "", false
+}
@@ -0,0 +1,207 @@
+package confetti
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"strings"
+)
+
+// An Option configures how Parse reads a source.
+type Option func(*config)
+
+type config struct {
+	name       string
+	exts       Extensions
+	lossless   bool
+	maxDepth   int
+	norm       Normalization
+	mode       Mode
+	allIllegal bool // report every forbidden character, not just the first
+	warnings   bool
+	translate  Translator
+	fset       *FileSet // if not nil, where sources are recorded
+
+	includeDepth int      // 0 if includes are disabled
+	including    []string // absolute paths of the sources currently being parsed, outermost first
+	fsys         fs.FS    // if not nil, the file system includes are read from, by slash-separated paths
+
+	err error // an invalid option
+}
+
+// same reports whether sources are read alike with c and o.
+func (c config) same(o config) bool {
+	return c.name == o.name && maps.Equal(c.exts, o.exts) && c.lossless == o.lossless && c.maxDepth == o.maxDepth &&
+		c.norm == o.norm && c.mode == o.mode && c.allIllegal == o.allIllegal && c.warnings == o.warnings && c.fset == o.fset && c.includeDepth == o.includeDepth
+}
+
+// DefaultMaxDepth is the number of blocks that may be nested inside each other unless WithMaxDepth is used.
+const DefaultMaxDepth = 1000
+
+func newConfig(opts []Option) (c config) {
+	c.maxDepth = DefaultMaxDepth
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return
+}
+
+// WithName sets the name of the source, such as its file path, recorded in the parsed document.
+func WithName(name string) Option {
+	return func(c *config) {
+		c.name = name
+	}
+}
+
+// WithMaxDepth sets the number of blocks that may be nested inside each other, so a source with deeper blocks fails with ErrTooDeep. A depth of 0 allows no blocks at all.
+func WithMaxDepth(depth int) Option {
+	return func(c *config) {
+		if depth < 0 {
+			c.err = fmt.Errorf("invalid maximum depth %d", depth)
+			return
+		}
+		c.maxDepth = depth
+	}
+}
+
+// WithMode sets how strictly the source is read. See Mode.
+func WithMode(m Mode) Option {
+	return func(c *config) {
+		c.mode = m
+	}
+}
+
+// WithAllIllegalCharacters makes Parse, on finding a forbidden character, scan the rest of the source and report every forbidden character in it, each as a *ParseError with ErrIllegalCharacter, joined with errors.Join, so that a source with many can be cleaned up at once.
+func WithAllIllegalCharacters() Option {
+	return func(c *config) {
+		c.allIllegal = true
+	}
+}
+
+// WithWarnings reports what the source allows but may not have been meant as warnings in the document's Diagnostics: a byte order mark, a ^Z at the end, line terminators other than the first one used, and indentation with tabs where earlier lines are indented with spaces, or the other way around.
+func WithWarnings() Option {
+	return func(c *config) {
+		c.warnings = true
+	}
+}
+
+// WithTranslator translates the messages of parse errors and diagnostics with codes using t, so that they can be shown in the operator's language. Catalog.Translate is a Translator.
+func WithTranslator(t Translator) Option {
+	return func(c *config) {
+		c.translate = t
+	}
+}
+
+// WithFileSet adds each source parsed to s, including the files included by it, named as they are in positions, so that the lines of positions in any of them can be found. Loader and ParseDir add each file they read. A source parsed again, as by Reparse or a Watcher, replaces the one of the same name, and sources without a name are not added.
+func WithFileSet(s *FileSet) Option {
+	return func(c *config) {
+		c.fset = s
+	}
+}
+
+// Normalization selects what Parse does with arguments not in Unicode Normalization Form C, where the same text can be written with different characters, such as "é" as "e" followed by a combining accent.
+type Normalization uint8
+
+const (
+	// NormalizeNone keeps arguments as they are written.
+	NormalizeNone Normalization = iota
+	// NormalizeNFC converts arguments to Normalization Form C, so equal text compares equal.
+	NormalizeNFC
+	// RequireNFC makes parsing fail with ErrNotNormalized.
+	RequireNFC
+)
+
+// WithNormalization sets what Parse does with arguments not in Unicode Normalization Form C.
+func WithNormalization(n Normalization) Option {
+	return func(c *config) {
+		c.norm = n
+	}
+}
+
+// WithExtensions enables the given extensions.
+//
+// With ExtVariables, a directive such as "set base_dir /srv/app" defines a variable and is removed from the document, and "${base_dir}" in any later argument is replaced by its value. A variable is visible in the rest of the block it is defined in, including subdirectives, which may redefine it for themselves. "$${" is replaced by a literal "${".
+func WithExtensions(exts Extensions) Option {
+	return func(c *config) {
+		c.exts = exts
+	}
+}
+
+// WithExtension enables the extension registered under name, with the given value. Parsing fails if no extension has that name.
+func WithExtension(name, value string) Option {
+	return func(c *config) {
+		ext, ok := LookupExtension(name)
+		if !ok {
+			c.err = fmt.Errorf("unknown extension %q", name)
+			return
+		}
+
+		c.enable(ext, value)
+	}
+}
+
+// enable adds ext to the enabled extensions without changing the map given to WithExtensions.
+func (c *config) enable(ext extension, value string) {
+	exts := make(Extensions, len(c.exts)+1)
+	maps.Copy(exts, c.exts)
+	exts[ext] = value
+	c.exts = exts
+}
+
+// WithCStyleComments enables ExtCStyleComments, so "//" starts a comment running to the end of the line and "/*" one running to the next "*/".
+func WithCStyleComments() Option {
+	return func(c *config) {
+		c.enable(ExtCStyleComments, "")
+	}
+}
+
+// WithExpressionArguments enables ExtExpressionArguments, so text in parentheses, which may contain white space and nested parentheses, is read as one argument without the outer parentheses.
+func WithExpressionArguments() Option {
+	return func(c *config) {
+		c.enable(ExtExpressionArguments, "")
+	}
+}
+
+// WithVariables enables ExtVariables, with variables defined by directives named name, or "set" if it is empty. See WithExtensions.
+func WithVariables(name string) Option {
+	return func(c *config) {
+		c.enable(ExtVariables, name)
+	}
+}
+
+// WithPunctuators enables ExtPunctuatorArguments with the given punctuators, which are read as separate arguments even where they are not surrounded by whitespace. Parsing fails if a punctuator is empty or contains whitespace, a line terminator, or a forbidden character.
+func WithPunctuators(ps ...string) Option {
+	var err error
+	for _, p := range ps {
+		if p == "" || strings.ContainsFunc(p, func(r rune) bool {
+			return isWhitespace(r) || isLineTerminator(r) || isForbidden(r)
+		}) {
+			err = fmt.Errorf("invalid punctuator %q", p)
+			break
+		}
+	}
+
+	return func(c *config) {
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		c.enable(ExtPunctuatorArguments, strings.Join(ps, "\n"))
+	}
+}
+
+// WithLossless keeps the source text of each directive, including comments and whitespace, so that encoding the document reproduces the source byte for byte. Arguments and directives changed after parsing are encoded canonically, leaving the rest of the source untouched.
+func WithLossless() Option {
+	return func(c *config) {
+		c.lossless = true
+	}
+}
+
+// WithIncludes enables include directives, which take the form "include path" and are replaced by the directives of the file at path. Relative paths are resolved against the directory of the including source's name, as set by WithName, or the working directory if it has none.
+// Included files may include others up to depth levels deep, and may not include themselves directly or indirectly. Includes cannot be used with WithLossless. Documents parsed with ParseFS include files from its file system instead.
+func WithIncludes(depth int) Option {
+	return func(c *config) {
+		c.includeDepth = depth
+	}
+}
@@ -1,18 +1,28 @@
 package confetti
 
-import (
-	"errors"
-	"fmt"
-)
+import "slices"
 
 // The Confetti language consists of zero or more directives. A directive consists of one or more arguments and optional subdirectives.
 
 // The entire AST of the language is ONE struct!!!!
 type Directive struct {
-	Arguments     []string
-	Subdirectives []Directive
+	Arguments     []string    `json:"arguments"`
+	Subdirectives []Directive `json:"subdirectives,omitempty"`
+
+	// ArgumentSources are how each argument was written, if the directive was parsed from source. The encoder writes an argument as it was written for as long as its value is unchanged.
+	ArgumentSources []ArgumentSource `json:"-"`
+
+	// Pos and End are the positions of the first character of the directive and just after its last, if it was parsed from source.
+	Pos, End Position `json:"-"`
+
+	// LeadingComments are the comments on the lines directly before the directive, with no blank line between them, and TrailingComment is the comment at the end of its last line. Each is written as in the source, including its delimiters, such as "# note".
+	LeadingComments []string `json:"-"`
+	TrailingComment string   `json:"-"`
+
+	syntax *syntax
 }
 
+// Equals reports whether d and other have the same arguments and equal subdirectives, ignoring how the arguments were written, positions, and comments.
 func (d Directive) Equals(other Directive) (eq bool) {
 	if len(d.Arguments) != len(other.Arguments) {
 		return
@@ -35,79 +45,157 @@ func (d Directive) Equals(other Directive) (eq bool) {
 	return true
 }
 
-func parse(ts []token, exts Extensions) (p []Directive, err error) {
+// Clone returns a deep copy of the directive, sharing no memory with the original.
+func (d Directive) Clone() Directive {
+	var args []string
+	if d.Arguments != nil {
+		args = make([]string, len(d.Arguments))
+		copy(args, d.Arguments)
+	}
+	return Directive{
+		Arguments:       args,
+		Subdirectives:   cloneDirectives(d.Subdirectives),
+		Pos:             d.Pos,
+		End:             d.End,
+		ArgumentSources: slices.Clone(d.ArgumentSources),
+		LeadingComments: slices.Clone(d.LeadingComments),
+		TrailingComment: d.TrailingComment,
+		syntax:          d.syntax.clone(),
+	}
+}
+
+func quoting(t TokenKind) Quoting {
+	switch t {
+	case TokenQuotedArgument:
+		return QuotingSingle
+	case TokenTripleQuotedArgument:
+		return QuotingTriple
+	}
+	return QuotingNone
+}
+
+// parse reads directives from ts, allowing blocks nested up to maxDepth levels.
+func parse(ts []Token, exts Extensions, maxDepth int) (p []Directive, err error) {
 	var current Directive
+	var comments []string // comments for the next directive
+	lineEnd := false      // whether the previous directive ended on the current line
+	newlines := 0         // line terminators since the last comment or directive
+
 	push := func() {
 		if current.Arguments == nil {
 			return
 		}
 		p = append(p, current)
 		current = Directive{}
+		lineEnd = true
 	}
 
 	i := 0
+	block := false // whether the previous directive already has a block
 
-	for prevSignificant := func() tokenType {
+	for prevSignificant := func() TokenKind {
 		for ci := i - 1; ci > 0; ci-- {
-			if prev := ts[ci].Type; prev != tokWhitespace && prev != tokComment {
+			if prev := ts[ci].Kind; prev != TokenWhitespace && prev != TokenComment {
 				return prev
 			}
 		}
-		return tokUnicode
+		return TokenUnicode
 	}; i < len(ts); i++ {
-		switch t := ts[i]; t.Type {
-		case tok0qArgument, tok1qArgument, tok3qArgument:
-			current.Arguments = append(current.Arguments, t.Content)
+		switch t := ts[i]; t.Kind {
+		case TokenArgument, TokenQuotedArgument, TokenTripleQuotedArgument, TokenPunctuator:
+			if current.Arguments == nil {
+				current.Pos = t.Pos
+				current.LeadingComments, comments = comments, nil
+				block = false
+			}
+			current.Arguments = append(current.Arguments, t.Value)
+			current.ArgumentSources = append(current.ArgumentSources, ArgumentSource{quoting(t.Kind), t.Text})
+			current.End = t.End
+			newlines = 0
+
+		case TokenComment:
+			switch {
+			case current.Arguments != nil:
+				current.TrailingComment = t.Text
+			case lineEnd && len(p) > 0:
+				p[len(p)-1].TrailingComment = t.Text
+			default:
+				comments = append(comments, t.Text)
+			}
+			newlines = 0
 
-		case tokSemicolon: // end of directive
-			if prev := prevSignificant(); prev == tokSemicolon || prev == tokNewline || prev == tokLineContinuation {
-				return nil, errors.New("unexpected ';'")
+		case TokenSemicolon: // end of directive
+			if prev := prevSignificant(); prev == TokenSemicolon || prev == TokenNewline || prev == TokenContinuation {
+				return nil, tokenError(t, ErrUnexpectedSemicolon)
 			}
 			push()
 
-		case tokNewline: // end of directive
+		case TokenNewline: // end of directive
 			push()
+			lineEnd = false
+			if newlines++; newlines > 1 {
+				// a blank line separates comments from the next directive
+				comments = nil
+			}
 
-		case tokOpenBrace:
-			if i == len(ts)-1 || prevSignificant() == tokSemicolon {
-				return nil, fmt.Errorf("unexpected '{'")
+		case TokenOpenBrace:
+			if i == len(ts)-1 || prevSignificant() == TokenSemicolon ||
+				current.Arguments == nil && (len(p) == 0 || block) {
+				return nil, tokenError(t, ErrUnexpectedOpenBrace)
+			} else if maxDepth == 0 {
+				return nil, tokenError(t, ErrTooDeep)
 			}
 
+			comments = nil
+
 			// Get all tokens until next close brace
 			i++
 			si := i
 			for depth := 0; i < len(ts); i++ {
 				// escapes should be dealt with in lexer
-				if t2 := ts[i]; t2.Type == tokOpenBrace {
-					depth++
-				} else if t2.Type == tokCloseBrace {
+				if t2 := ts[i]; t2.Kind == TokenOpenBrace {
+					if depth++; depth >= maxDepth {
+						// fail before recursing into every level
+						return nil, tokenError(t2, ErrTooDeep)
+					}
+				} else if t2.Kind == TokenCloseBrace {
 					if depth == 0 {
 						break
 					}
 					depth--
 				} else if i == len(ts)-1 {
-					return nil, fmt.Errorf("expected '}'")
+					return nil, tokenError(t, ErrExpectedCloseBrace)
 				}
 			}
+			if i == len(ts) {
+				return nil, tokenError(t, ErrExpectedCloseBrace)
+			}
 
-			subp, err := parse(ts[si:i], exts)
+			end := ts[i].End
+			subp, err := parse(ts[si:i], exts, maxDepth-1)
 			if err != nil {
 				return nil, err
-			} else if current.Arguments == nil {
+			}
+
+			block = true
+			if current.Arguments == nil {
 				// push to the previous directive
 				p[len(p)-1].Subdirectives = subp
+				p[len(p)-1].End = end
+				lineEnd = true
 				break
 			}
 
 			current.Subdirectives = subp
+			current.End = end
 			push()
 
-		case tokCloseBrace:
-			return nil, errors.New("found '}' without matching '{'")
+		case TokenCloseBrace:
+			return nil, tokenError(t, ErrUnmatchedCloseBrace)
 
-		case tokLineContinuation:
+		case TokenContinuation:
 			if current.Arguments == nil {
-				return nil, fmt.Errorf("unexpected line continuation")
+				return nil, tokenError(t, ErrUnexpectedContinuation)
 			}
 		}
 	}
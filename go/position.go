@@ -0,0 +1,203 @@
+package confetti
+
+import (
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Position is a location in a source.
+type Position struct {
+	Filename string // name of the source, if any
+	Offset   int    // byte offset, starting at 0
+	Line     int    // line number, starting at 1
+	Column   int    // column number in characters, starting at 1
+}
+
+// IsValid reports whether the position is set.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position as "file:line:column", "line:column", or "-" if the position is not valid.
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
+// Range is the part of a source from Start up to but not including End.
+type Range struct {
+	Start, End Position
+}
+
+// ColumnKind selects what a column number counts.
+type ColumnKind uint8
+
+const (
+	// ColumnBytes counts bytes of UTF-8.
+	ColumnBytes ColumnKind = iota
+	// ColumnRunes counts characters, as Position.Column does.
+	ColumnRunes
+	// ColumnUTF16 counts UTF-16 code units, as the Language Server Protocol does.
+	ColumnUTF16
+	// ColumnGraphemes counts the characters people see, the extended grapheme clusters of Unicode Standard Annex #29, so that "é" written as "e" and a combining accent, or an emoji sequence joined by zero width joiners, is one column. Prepended characters, which only a few scripts use, start a new cluster.
+	ColumnGraphemes
+)
+
+// LinePos is a position in a line of a source, whose column can be given in any ColumnKind.
+type LinePos struct {
+	// Line is the line, without its terminator.
+	Line string
+	// Offset is the byte offset in Line, starting at 0.
+	Offset int
+}
+
+// LinePosOf returns the position p in src as a position in its line.
+func LinePosOf(src string, p Position) LinePos {
+	off := min(max(p.Offset, 0), len(src))
+	start := lineStart(src, off)
+	return LinePos{src[start:lineEnd(src, off)], off - start}
+}
+
+// LinePosAt returns the position of column col, starting at 1, of line, counting columns as kind says. Columns past the end of the line are at its end, and those within a character or cluster at its start.
+func LinePosAt(line string, kind ColumnKind, col int) LinePos {
+	off := 0
+	for n := 1; n < col && off < len(line); n++ {
+		size := columnSize(line[off:], kind)
+		if kind == ColumnUTF16 && size == 4 {
+			// a character outside the Basic Multilingual Plane is two code units
+			if n++; n == col {
+				break
+			}
+		}
+		off += size
+	}
+	return LinePos{line, off}
+}
+
+// Column returns the column of the position, starting at 1, counting columns as kind says.
+func (p LinePos) Column(kind ColumnKind) int {
+	col := 1
+	for off := 0; off < min(p.Offset, len(p.Line)); {
+		size := columnSize(p.Line[off:], kind)
+		if off+size > p.Offset {
+			break // within a cluster
+		} else if kind == ColumnUTF16 && size == 4 {
+			col++
+		}
+		off += size
+		col++
+	}
+	return col
+}
+
+// columnSize returns the number of bytes at the start of s a column counts, or 1 for invalid UTF-8.
+func columnSize(s string, kind ColumnKind) int {
+	switch kind {
+	case ColumnBytes:
+		return 1
+	case ColumnGraphemes:
+		return graphemeSize(s)
+	}
+	_, size := utf8.DecodeRuneInString(s)
+	return size
+}
+
+// graphemeSize returns the length in bytes of the extended grapheme cluster at the start of s.
+func graphemeSize(s string) int {
+	prev, n := utf8.DecodeRuneInString(s)
+	regional := isRegional(prev)         // whether the cluster so far ends in an odd number of regional indicators
+	pictographic := isPictographic(prev) // whether the cluster so far is a pictograph followed by extending characters
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		switch {
+		case prev == '\r' && r == '\n':
+		case isGraphemeControl(prev) || isGraphemeControl(r):
+			return n
+		case hangulJoins(prev, r):
+		case isExtending(r):
+		case prev == '\u200d' && pictographic && isPictographic(r):
+		case regional && isRegional(r):
+		default:
+			return n
+		}
+
+		pictographic = isPictographic(r) || pictographic && isExtending(r)
+		regional = false // the cluster now ends in an even number of regional indicators, if any
+		prev, n = r, n+size
+	}
+	return n
+}
+
+func isGraphemeControl(r rune) bool {
+	return unicode.In(r, unicode.Cc, unicode.Zl, unicode.Zp)
+}
+
+// isExtending reports whether r joins the cluster before it: combining marks, spacing marks, the zero width joiner, and emoji skin tone modifiers.
+func isExtending(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) || r == '\u200d' || r >= 0x1f3fb && r <= 0x1f3ff
+}
+
+func isRegional(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// isPictographic approximates the Extended_Pictographic property with the blocks of symbols and emoji.
+func isPictographic(r rune) bool {
+	switch {
+	case r == 0xa9, r == 0xae, r == 0x203c, r == 0x2049, r == 0x2122, r == 0x2139, r == 0x3030, r == 0x303d, r == 0x3297, r == 0x3299:
+		return true
+	case r >= 0x2194 && r <= 0x21aa, r >= 0x2300 && r <= 0x23ff, r >= 0x25aa && r <= 0x27bf, r >= 0x2934 && r <= 0x2935, r >= 0x2b05 && r <= 0x2b55:
+		return true
+	}
+	return r >= 0x1f000 && r <= 0x1faff && !isRegional(r) && !(r >= 0x1f3fb && r <= 0x1f3ff)
+}
+
+// hangul syllable types
+const (
+	hangulNone = iota
+	hangulL
+	hangulV
+	hangulT
+	hangulLV
+	hangulLVT
+)
+
+func hangulType(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115f, r >= 0xa960 && r <= 0xa97c:
+		return hangulL
+	case r >= 0x1160 && r <= 0x11a7, r >= 0xd7b0 && r <= 0xd7c6:
+		return hangulV
+	case r >= 0x11a8 && r <= 0x11ff, r >= 0xd7cb && r <= 0xd7fb:
+		return hangulT
+	case r >= 0xac00 && r <= 0xd7a3:
+		if (r-0xac00)%28 == 0 {
+			return hangulLV
+		}
+		return hangulLVT
+	}
+	return hangulNone
+}
+
+// hangulJoins reports whether the Hangul jamo or syllables a and b form one syllable.
+func hangulJoins(a, b rune) bool {
+	switch ta, tb := hangulType(a), hangulType(b); ta {
+	case hangulL:
+		return tb == hangulL || tb == hangulV || tb == hangulLV || tb == hangulLVT
+	case hangulLV, hangulV:
+		return tb == hangulV || tb == hangulT
+	case hangulLVT, hangulT:
+		return tb == hangulT
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package confetti
+
+import (
+	"io"
+	"slices"
+)
+
+// Parser reads the top-level directives of a source one at a time, so that a program can process a large source as it is read, or stop early, without holding all of it in memory. See NewParser.
+type Parser struct {
+	er  *eventReader
+	err error // to return once the directives read have been
+
+	ts    []Token // read since the last directive was returned
+	last  int     // the index in ts after the last token of the directive being read, or 0 if none has started
+	open  bool    // whether the directive being read may take more arguments
+	depth int
+	ready []Directive
+
+	keep bool // whether to keep every token read in kept, for Decoder
+	kept []Token
+}
+
+// noEvents is an EventHandler that ignores every event.
+type noEvents struct{}
+
+func (noEvents) OnDirective([]string) error { return nil }
+func (noEvents) OnBlockStart() error        { return nil }
+func (noEvents) OnBlockEnd() error          { return nil }
+func (noEvents) OnComment(string) error     { return nil }
+
+// NewParser returns a parser reading from r. The options are those ParseEvents accepts.
+func NewParser(r io.Reader, opts ...Option) *Parser {
+	er, err := newEventReader(r, noEvents{}, opts)
+	return &Parser{er: er, err: err}
+}
+
+// Next returns the next top-level directive of the source, with its subdirectives, parsed as Parse would. A directive is returned once the next one starts or the source ends, as until then a block on a later line may still belong to it. Next returns io.EOF after the last directive, or the error that stopped parsing, with a *ParseError for an invalid source.
+func (p *Parser) Next() (*Directive, error) {
+	for len(p.ready) == 0 && p.err == nil {
+		ts, err := p.er.read()
+		if err == io.EOF {
+			p.flush(len(p.ts))
+		}
+		if err != nil && p.err == nil {
+			p.err = err
+		}
+		if p.err != nil {
+			break
+		}
+
+		if p.keep {
+			p.kept = append(p.kept, ts...)
+		}
+		for _, t := range ts {
+			p.add(t)
+		}
+	}
+
+	if len(p.ready) == 0 {
+		return nil, p.err
+	}
+	d := p.ready[0]
+	p.ready = p.ready[1:]
+	return &d, nil
+}
+
+// add adds a token to those of the directive being read, first parsing that directive if the token starts the next one.
+func (p *Parser) add(t Token) {
+	switch t.Kind {
+	case TokenArgument, TokenQuotedArgument, TokenTripleQuotedArgument, TokenPunctuator:
+		if p.depth > 0 {
+			break
+		} else if !p.open && p.last > 0 {
+			// the directive ends at the end of its line, where its trailing comment is, or where the next starts
+			cut := len(p.ts)
+			if i := slices.IndexFunc(p.ts[p.last:], func(t Token) bool { return t.Kind == TokenNewline }); i >= 0 {
+				cut = p.last + i + 1
+			}
+			p.flush(cut)
+		}
+		p.open = true
+		p.last = len(p.ts) + 1
+
+	case TokenOpenBrace:
+		p.depth++
+		p.open = false
+
+	case TokenCloseBrace:
+		if p.depth--; p.depth == 0 {
+			p.last = len(p.ts) + 1
+		}
+
+	case TokenNewline, TokenSemicolon:
+		if p.depth == 0 {
+			p.open = false
+		}
+	}
+	p.ts = append(p.ts, t)
+}
+
+// flush parses the first n tokens read, which the event parser has already checked, as a directive ready to be returned.
+func (p *Parser) flush(n int) {
+	ds, err := parse(p.ts[:n], p.er.p.c.exts, p.er.p.c.maxDepth)
+	if err != nil {
+		p.err = err
+		return
+	}
+	p.ready = append(p.ready, ds...)
+	p.ts = slices.Clone(p.ts[n:])
+	p.last = 0
+}
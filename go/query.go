@@ -0,0 +1,199 @@
+package confetti
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Name returns the directive's first argument, or "" if it has none.
+func (d Directive) Name() string {
+	if len(d.Arguments) == 0 {
+		return ""
+	}
+	return d.Arguments[0]
+}
+
+func firstNamed(p []Directive, name string) (Directive, bool) {
+	for _, d := range p {
+		if d.Name() == name {
+			return d, true
+		}
+	}
+	return Directive{}, false
+}
+
+func allNamed(p []Directive, name string) (ds []Directive) {
+	for _, d := range p {
+		if d.Name() == name {
+			ds = append(ds, d)
+		}
+	}
+	return
+}
+
+// Get returns the first top-level directive named name.
+func (doc Document) Get(name string) (Directive, bool) {
+	return firstNamed(doc.Directives, name)
+}
+
+// GetAll returns every top-level directive named name, in order.
+func (doc Document) GetAll(name string) []Directive {
+	return allNamed(doc.Directives, name)
+}
+
+// Sub returns the first subdirective named name.
+func (d Directive) Sub(name string) (Directive, bool) {
+	return firstNamed(d.Subdirectives, name)
+}
+
+// SubAll returns every subdirective named name, in order.
+func (d Directive) SubAll(name string) []Directive {
+	return allNamed(d.Subdirectives, name)
+}
+
+// Lookup returns the directive at a dotted path of directive names, such as "server.tls.certificate". A name may be followed by an index to select among repeated directives of that name, starting at 0, as in "upstream[2].host"; without one, the first is selected. LookupAll finds every directive a path, which may have wildcards, matches.
+func (doc Document) Lookup(path string) (Directive, error) {
+	p := doc.Directives
+	var d Directive
+	for seg := range strings.SplitSeq(path, ".") {
+		name, idx, err := parseSelector(seg)
+		if err != nil {
+			return Directive{}, fmt.Errorf("invalid path %q: %w", path, err)
+		}
+
+		ds := allNamed(p, name)
+		if idx >= len(ds) {
+			return Directive{}, fmt.Errorf("%w %q", errPath, path)
+		}
+		d = ds[idx]
+		p = d.Subdirectives
+	}
+	return d, nil
+}
+
+// LookupAll returns every directive at a dotted path of directive names, in depth-first order, or none if there are none. A name selects every directive of that name unless it is followed by an index, as in Lookup. "*" stands for a directive of any name, and "**" for any number of levels of directives, including none, so "servers.*.port" finds the port of each block in servers, and "**.tls" each tls directive at any depth.
+func (doc Document) LookupAll(path string) ([]Match, error) {
+	segs := strings.Split(path, ".")
+	for _, seg := range segs {
+		if seg == "*" || seg == "**" {
+			continue
+		} else if _, _, err := parseSelector(seg); err != nil {
+			return nil, fmt.Errorf("invalid path %q: %w", path, err)
+		}
+	}
+
+	var ms []Match
+	seen := map[string]bool{}
+	root := Match{Directive: &Directive{Subdirectives: doc.Directives}}
+	lookupAll(root, segs, func(m Match) {
+		if key := fmt.Sprint(m.Path); !seen[key] {
+			seen[key] = true
+			ms = append(ms, m)
+		}
+	})
+	slices.SortFunc(ms, func(a, b Match) int {
+		return slices.Compare(a.Path, b.Path)
+	})
+	return ms, nil
+}
+
+// lookupAll calls yield with each directive within m that segs match, or m itself if segs is empty. The root, which has an empty path, is never yielded.
+func lookupAll(m Match, segs []string, yield func(Match)) {
+	if len(segs) == 0 {
+		if len(m.Path) > 0 {
+			yield(m)
+		}
+		return
+	}
+
+	seg, rest := segs[0], segs[1:]
+	if seg == "**" {
+		lookupAll(m, rest, yield)
+	}
+
+	name, idx, _ := parseSelector(seg)
+	indexed := strings.Contains(seg, "[")
+	n := 0
+	for i := range m.Directive.Subdirectives {
+		d := &m.Directive.Subdirectives[i]
+		sub := Match{d, append(m.Path[:len(m.Path):len(m.Path)], i), append(m.Names[:len(m.Names):len(m.Names)], d.Name())}
+		switch {
+		case seg == "**":
+			lookupAll(sub, segs, yield)
+		case seg == "*":
+			lookupAll(sub, rest, yield)
+		case d.Name() == name:
+			if !indexed || n == idx {
+				lookupAll(sub, rest, yield)
+			}
+			n++
+		}
+	}
+}
+
+// parseSelector splits a path segment into a name and an optional index.
+func parseSelector(seg string) (name string, idx int, err error) {
+	name, rest, ok := strings.Cut(seg, "[")
+	if name == "" {
+		return "", 0, fmt.Errorf("empty name in %q", seg)
+	} else if !ok {
+		return name, 0, nil
+	}
+
+	num, ok := strings.CutSuffix(rest, "]")
+	if !ok {
+		return "", 0, fmt.Errorf("unclosed index in %q", seg)
+	}
+	if idx, err = strconv.Atoi(num); err != nil || idx < 0 {
+		return "", 0, fmt.Errorf("bad index in %q", seg)
+	}
+	return name, idx, nil
+}
+
+// Match is a directive found by Find.
+type Match struct {
+	// Directive points into the document searched, so changes to it change the document.
+	Directive *Directive
+	// Path is the index of the directive and those of its parents, from the top level, as taken by CommentOut and Snapshot, and Names are their names.
+	Path  []int
+	Names []string
+}
+
+// Find returns the directives, at any depth, for which f returns true, in depth-first order.
+func (doc Document) Find(f func(d *Directive) bool) []Match {
+	var ms []Match
+	search(doc.Directives, nil, nil, func(m Match) bool {
+		if f(m.Directive) {
+			ms = append(ms, m)
+		}
+		return true
+	})
+	return ms
+}
+
+// FindFirst returns the first directive, in depth-first order, for which f returns true, or false if there is none.
+func (doc Document) FindFirst(f func(d *Directive) bool) (Match, bool) {
+	var found Match
+	ok := !search(doc.Directives, nil, nil, func(m Match) bool {
+		if f(m.Directive) {
+			found = m
+			return false
+		}
+		return true
+	})
+	return found, ok
+}
+
+// search calls yield with a match for each directive in p, whose parents have the given path and names, and their subdirectives, reporting false if yield stopped it by returning false.
+func search(p []Directive, path []int, names []string, yield func(Match) bool) bool {
+	for i := range p {
+		d := &p[i]
+		m := Match{d, append(path[:len(path):len(path)], i), append(names[:len(names):len(names)], d.Name())}
+		if !yield(m) || !search(d.Subdirectives, m.Path, m.Names, yield) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+//go:build !js && !wasip1 && !plan9
+
+package confetti
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ReloadOnSignal loads the file at path, parsed with opts, which must match schema if it is not nil, and loads it again each time the process receives SIGHUP until ctx is done, the reload convention of daemons.
+// It returns a function giving the active document, which a successful reload replaces atomically, as in a Store, so each call gives a whole document, old or new, which must not be modified. A reload that fails leaves the active document in place.
+// onChange, if not nil, is called after each reload with the new document, or with the error that kept the old one active.
+func ReloadOnSignal(ctx context.Context, path string, schema *Schema, onChange func(Document, error), opts ...Option) (func() Document, error) {
+	doc, err := loadValid(path, opts, schema)
+	if err != nil {
+		return nil, err
+	}
+	var active Store
+	active.Swap(doc)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			}
+
+			doc, err := loadValid(path, opts, schema)
+			if err == nil {
+				active.Swap(doc)
+			}
+			if onChange != nil {
+				onChange(doc, err)
+			}
+		}
+	}()
+
+	return active.Load, nil
+}
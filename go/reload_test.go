@@ -0,0 +1,69 @@
+//go:build !js && !wasip1 && !plan9
+
+package confetti_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	confetti "github.com/Heliodex/confetti"
+)
+
+func TestReloadOnSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no SIGHUP on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "app.conf")
+	if err := os.WriteFile(path, []byte("port 80 // http\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	schema := &confetti.Schema{Directives: map[string]confetti.DirectiveSchema{
+		"port": {MinArgs: 1, MaxArgs: 1, Args: []confetti.ArgType{confetti.ArgInt}},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan error, 10)
+	active, err := confetti.ReloadOnSignal(ctx, path, schema, func(_ confetti.Document, err error) {
+		changes <- err
+	}, confetti.WithCStyleComments())
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	} else if port := active().Directives[0].Arguments[1]; port != "80" {
+		t.Fatalf("Expected port 80, got %s", port)
+	}
+
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hup := func(src string) error {
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		} else if err = self.Signal(syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-changes:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a reload")
+			return nil
+		}
+	}
+	if err = hup("port 8080\n"); err != nil {
+		t.Fatalf("Failed to reload configuration: %v", err)
+	} else if port := active().Directives[0].Arguments[1]; port != "8080" {
+		t.Fatalf("Expected port 8080, got %s", port)
+	}
+	if err = hup("port http\n"); err == nil {
+		t.Fatal("Expected a validation error")
+	} else if port := active().Directives[0].Arguments[1]; port != "8080" {
+		t.Fatalf("Expected the previous configuration to stay active, got port %s", port)
+	}
+}
@@ -0,0 +1,99 @@
+package confetti
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ANSI escape sequences used by Render.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiGutter = "\x1b[1;34m"
+)
+
+var severityColors = [...]string{
+	SeverityError:   "\x1b[1;31m",
+	SeverityWarning: "\x1b[1;33m",
+	SeverityInfo:    "\x1b[1;36m",
+}
+
+// Diagnostic returns the error as an error diagnostic, spanning the offending token if it is on one line.
+func (e *ParseError) Diagnostic() Diagnostic {
+	d := Diagnostic{Severity: SeverityError, Message: e.message(), Pos: e.Pos, Code: e.Code()}
+	if e.Pos.IsValid() && e.Token != "" && !strings.ContainsFunc(e.Token, isLineTerminator) {
+		d.End = e.Pos
+		d.End.Offset += len(e.Token)
+		d.End.Column += utf8.RuneCountInString(e.Token)
+	}
+	return d
+}
+
+// Render renders the error as compilers such as rustc do, with the source line containing it and a caret under the offending token, colored with ANSI escape sequences if color is set.
+func (e *ParseError) Render(color bool) string {
+	return render(e.Diagnostic(), e.Line, color)
+}
+
+// Render renders the diagnostic as compilers such as rustc do, with the line of src containing it and carets under the part of it reported, colored with ANSI escape sequences if color is set. src is the source the diagnostic is about, or "" to leave the line out.
+func (d Diagnostic) Render(src string, color bool) string {
+	line := ""
+	if off := d.Pos.Offset; d.Pos.IsValid() && off <= len(src) {
+		line = src[lineStart(src, off):lineEnd(src, off)]
+	}
+	return render(d, line, color)
+}
+
+// render renders d, with line as the source line containing its position.
+func render(d Diagnostic, line string, color bool) string {
+	paint := func(s, code string) string {
+		if !color {
+			return s
+		}
+		return code + s + ansiReset
+	}
+	code := ansiBold
+	if int(d.Severity) < len(severityColors) {
+		code = severityColors[d.Severity]
+	}
+
+	var b strings.Builder
+	label := d.Severity.String()
+	if d.Code != 0 {
+		label += "[" + d.Code.String() + "]"
+	}
+	b.WriteString(paint(label, code) + paint(": "+d.Message, ansiBold))
+	if !d.Pos.IsValid() {
+		return b.String()
+	}
+
+	num := strconv.Itoa(d.Pos.Line)
+	gutter := strings.Repeat(" ", len(num))
+	fmt.Fprintf(&b, "\n%s%s %s", gutter, paint("-->", ansiGutter), d.Pos)
+	if line == "" {
+		return b.String()
+	}
+
+	// a space for each character people see before the position, keeping tabs, so the carets line up
+	start := LinePosAt(line, ColumnRunes, d.Pos.Column)
+	var pad strings.Builder
+	for off := 0; off < start.Offset; {
+		size := graphemeSize(line[off:])
+		if line[off:off+size] == "\t" {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+		off += size
+	}
+	width := 1
+	if d.End.Line == d.Pos.Line && d.End.Column > d.Pos.Column {
+		width = max(LinePosAt(line, ColumnRunes, d.End.Column).Column(ColumnGraphemes)-start.Column(ColumnGraphemes), 1)
+	}
+
+	fmt.Fprintf(&b, "\n%s %s", gutter, paint("|", ansiGutter))
+	fmt.Fprintf(&b, "\n%s %s", paint(num+" |", ansiGutter), line)
+	fmt.Fprintf(&b, "\n%s %s%s", paint(gutter+" |", ansiGutter), pad.String(), paint(strings.Repeat("^", width), code))
+	return b.String()
+}
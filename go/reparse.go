@@ -0,0 +1,167 @@
+package confetti
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Reparse returns the document of its source with the text in r replaced by newText, as Parse would with the same options. Only the offsets of r are used.
+// Where it can, Reparse reads again only the top-level directives around the change and moves the positions of those after it, so small edits to large sources are cheap. Edits that need the whole source read again, such as opening a block that closes much later, and documents parsed with includes, transforming extensions, lossless mode, warnings, or a mode other than ModeStandard are parsed in full.
+// doc must have been returned by Parse or Reparse and not changed since.
+func (doc Document) Reparse(r Range, newText string) (Document, error) {
+	if doc.conf == nil {
+		return Document{}, errors.New("document was not parsed from a source")
+	}
+
+	start, end := r.Start.Offset, r.End.Offset
+	if start < 0 || start > end || end > len(doc.src) {
+		return Document{}, fmt.Errorf("range %d-%d outside source of %d bytes", start, end, len(doc.src))
+	}
+	src := doc.src[:start] + newText + doc.src[end:]
+
+	if out, ok := doc.reparse(start, end, src); ok {
+		if c := doc.conf; c.fset != nil && c.name != "" {
+			c.fset.AddFile(c.name, src)
+		}
+		return out, nil
+	}
+	return parseSource(src, *doc.conf)
+}
+
+// startsLine reports whether only white space comes before src[off] on its line.
+func startsLine(src string, off int) bool {
+	return strings.TrimFunc(src[lineStart(src, off):off], isWhitespace) == ""
+}
+
+// reparse reads again the top-level directives of doc near the change of doc.src[start:end] that gave src, reporting false if the whole source must be read.
+func (doc Document) reparse(start, end int, src string) (Document, bool) {
+	c := *doc.conf
+	if c.mode != ModeStandard || c.lossless || c.warnings || c.includeDepth > 0 {
+		return Document{}, false
+	}
+	if hooks, _ := c.exts.hooks(); slices.ContainsFunc(hooks, func(h ExtensionHooks) bool { return h.Transform != nil }) {
+		return Document{}, false
+	}
+
+	// directives touching the change, the one after them as its leading comments may have changed, and one before them starting a line, so the lexer and parser start in a known state
+	p := doc.Directives
+	k := 0
+	for k < len(p) && p[k].End.Offset < start {
+		k++
+	}
+	first := k - 1
+	for first >= 0 && !startsLine(doc.src, p[first].Pos.Offset) {
+		first--
+	}
+	if first < 0 {
+		return Document{}, false
+	}
+	next := k
+	for next < len(p) && p[next].Pos.Offset <= end {
+		next++
+	}
+	next++ // the first directive left as it is
+
+	delta := len(src) - len(doc.src)
+	from, to := p[first].Pos, len(doc.src)
+	if next < len(p) {
+		to = p[next].Pos.Offset
+	}
+
+	part := src[from.Offset : to+delta]
+	if !utf8.ValidString(part) {
+		return Document{}, false
+	}
+	var ts []Token
+	newEnd, err := lexAt(part, from, c.exts, func(t Token) bool {
+		t.Pos.Filename, t.End.Filename = c.name, c.name
+		ts = append(ts, t)
+		return true
+	})
+	if err != nil {
+		return Document{}, false
+	}
+
+	if next < len(p) {
+		// the lexer and parser must end between lines, as they were before the directive
+		i := len(ts) - 1
+		for i >= 0 && ts[i].Kind == TokenWhitespace {
+			i--
+		}
+		if i < 0 || ts[i].Kind != TokenNewline {
+			return Document{}, false
+		}
+	}
+	if normalize(ts, c.norm) != nil {
+		return Document{}, false
+	}
+	// a stand-in for the first directive left as it is collects the comments before it
+	read := ts
+	if next < len(p) {
+		read = append(ts, Token{Kind: TokenArgument, Value: "_", Pos: newEnd, End: newEnd})
+	}
+	q, err := parse(read, c.exts, c.maxDepth)
+	if err != nil || len(q) == 0 || q[0].Pos.Offset != from.Offset {
+		return Document{}, false
+	}
+	var comments []string
+	if next < len(p) {
+		comments = q[len(q)-1].LeadingComments
+		if q = q[:len(q)-1]; len(q) == 0 {
+			return Document{}, false
+		}
+	}
+	q[0].LeadingComments = p[first].LeadingComments
+
+	oldEnd := Position{}
+	if next < len(p) {
+		oldEnd = p[next].Pos
+	}
+	move := func(pos Position) Position {
+		if pos.Line == oldEnd.Line {
+			pos.Column += newEnd.Column - oldEnd.Column
+		}
+		pos.Line += newEnd.Line - oldEnd.Line
+		pos.Offset += delta
+		return pos
+	}
+
+	out := doc
+	out.src = src
+	out.Directives = append(slices.Clone(p[:first]), q...)
+	for _, d := range p[min(next, len(p)):] {
+		out.Directives = append(out.Directives, d.Clone())
+	}
+	Walk(out.Directives[first+len(q):], func(d *Directive, _ int) bool {
+		d.Pos, d.End = move(d.Pos), move(d.End)
+		return true
+	})
+	if next < len(p) {
+		out.Directives[first+len(q)].LeadingComments = comments
+	}
+
+	region := newDocument(ts, q, c.exts)
+	if next >= len(p) {
+		out.CtrlZ = region.CtrlZ
+	}
+	out.Trivia = nil
+	for _, t := range doc.Trivia {
+		if t.Pos.Offset >= from.Offset {
+			break
+		}
+		out.Trivia = append(out.Trivia, t)
+	}
+	out.Trivia = append(out.Trivia, region.Trivia...)
+	if next < len(p) {
+		for _, t := range doc.Trivia {
+			if t.Pos.Offset >= to {
+				t.Pos = move(t.Pos)
+				out.Trivia = append(out.Trivia, t)
+			}
+		}
+	}
+	return out, true
+}
@@ -0,0 +1,43 @@
+package confetti
+
+import "errors"
+
+// A DirectiveHandler processes a directive a Router dispatches to it.
+type DirectiveHandler func(d Directive) error
+
+// Router dispatches the top-level directives of a document to handlers registered by name, for programs that process their configuration one directive at a time rather than decoding it into a struct.
+type Router struct {
+	handlers map[string]DirectiveHandler
+}
+
+// NewRouter returns a router with no handlers.
+func NewRouter() *Router {
+	return &Router{handlers: map[string]DirectiveHandler{}}
+}
+
+// Handle registers h for the directives named name. It panics if a handler is already registered for name.
+func (r *Router) Handle(name string, h DirectiveHandler) {
+	if _, ok := r.handlers[name]; ok {
+		panic("confetti: handler for " + name + " registered twice")
+	}
+	r.handlers[name] = h
+}
+
+// Process calls the handler registered for each top-level directive of doc in turn, stopping at the first error. A directive with no handler, or whose handler fails, gives a *DecodeError at the directive; an error the handler returns is wrapped, unless it is already a *DecodeError.
+func (r *Router) Process(doc Document) error {
+	for _, d := range doc.Directives {
+		name := d.Name()
+		h, ok := r.handlers[name]
+		if !ok {
+			return &DecodeError{d.Pos, []string{name}, errors.New("unhandled directive")}
+		}
+
+		if err := h(d); err != nil {
+			if _, ok := err.(*DecodeError); ok {
+				return err
+			}
+			return &DecodeError{d.Pos, []string{name}, err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package confetti
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// ArgType is the type an argument must have to pass validation.
+type ArgType uint8
+
+const (
+	ArgString ArgType = iota
+	ArgInt
+	ArgBool
+	ArgFloat
+	ArgDuration
+	ArgBytes
+)
+
+// check reports whether a can be interpreted as the type, using the Argument accessor of the same name.
+func (t ArgType) check(a Argument) (err error) {
+	switch t {
+	case ArgInt:
+		_, err = a.Int()
+	case ArgBool:
+		_, err = a.Bool()
+	case ArgFloat:
+		_, err = a.Float64()
+	case ArgDuration:
+		_, err = a.Duration()
+	case ArgBytes:
+		_, err = a.Bytes()
+	}
+	return
+}
+
+// Schema describes the directives allowed in a document or block.
+type Schema struct {
+	// Directives maps each allowed directive name to its description.
+	Directives map[string]DirectiveSchema
+	// AllowUnknown permits directives not in Directives, which are not validated further.
+	AllowUnknown bool
+}
+
+// DirectiveSchema describes a directive. Argument counts do not include the name.
+type DirectiveSchema struct {
+	MinArgs int
+	// MaxArgs is the most arguments allowed, or -1 for no limit.
+	MaxArgs int
+	// Args are the types of the arguments in order. The last type applies to any further arguments. If Args is empty, any argument is allowed.
+	Args []ArgType
+	// Required directives must appear at least once.
+	Required bool
+	// Repeatable directives may appear more than once.
+	Repeatable bool
+	// Sub describes the directive's subdirectives. If Sub is nil, the directive may not have any.
+	Sub *Schema
+	// Doc describes the directive for people editing a document, such as in an editor's hover text.
+	Doc string
+}
+
+// ValidationError is a directive that does not match a schema.
+type ValidationError struct {
+	Pos Position
+	// Path is the names of the directive and its parents.
+	Path []string
+	Err  error
+}
+
+func (e ValidationError) Error() string {
+	return e.Pos.String() + ": " + strings.Join(e.Path, ".") + ": " + e.Err.Error()
+}
+
+func (e ValidationError) Unwrap() error {
+	return e.Err
+}
+
+var errMissing = errors.New("missing required directive")
+
+// Validate checks the document against a schema, returning an error for each violation.
+func (doc Document) Validate(s Schema) []ValidationError {
+	return validate(doc.Directives, s, nil, Position{Filename: doc.Name})
+}
+
+// validate checks the directives of one block, whose parent is at pos.
+func validate(p []Directive, s Schema, path []string, pos Position) (errs []ValidationError) {
+	counts := map[string]int{}
+	for _, d := range p {
+		name := d.Name()
+		dpath := append(path[:len(path):len(path)], name)
+		fail := func(format string, a ...any) {
+			errs = append(errs, ValidationError{d.Pos, dpath, fmt.Errorf(format, a...)})
+		}
+
+		ds, ok := s.Directives[name]
+		if !ok {
+			if s.AllowUnknown {
+				continue
+			} else if near, ok := closest(name, slices.Sorted(maps.Keys(s.Directives))); ok {
+				fail("unknown directive; did you mean %q?", near)
+			} else {
+				fail("unknown directive")
+			}
+			continue
+		}
+
+		if counts[name]++; counts[name] == 2 && !ds.Repeatable {
+			fail("repeated directive")
+		}
+
+		args := d.Arguments[1:]
+		if len(args) < ds.MinArgs {
+			fail("expected at least %d arguments, got %d", ds.MinArgs, len(args))
+		} else if ds.MaxArgs >= 0 && len(args) > ds.MaxArgs {
+			fail("expected at most %d arguments, got %d", ds.MaxArgs, len(args))
+		}
+		for i, a := range args {
+			if len(ds.Args) == 0 {
+				break
+			}
+			if err := ds.Args[min(i, len(ds.Args)-1)].check(Argument(a)); err != nil {
+				fail("argument %d: %w", i+1, err)
+			}
+		}
+
+		if ds.Sub != nil {
+			errs = append(errs, validate(d.Subdirectives, *ds.Sub, dpath, d.Pos)...)
+		} else if len(d.Subdirectives) > 0 {
+			fail("unexpected subdirectives")
+		}
+	}
+
+	// required directives are reported at the parent
+	for _, name := range slices.Sorted(maps.Keys(s.Directives)) {
+		if s.Directives[name].Required && counts[name] == 0 {
+			errs = append(errs, ValidationError{pos, append(path[:len(path):len(path)], name), errMissing})
+		}
+	}
+	return
+}
+
+// closest returns the name in names with the fewest edits from name, if it is near enough to be a likely misspelling of it. Ties go to the earliest name.
+func closest(name string, names []string) (near string, ok bool) {
+	best := max(1, utf8.RuneCountInString(name)/3) + 1
+	for _, n := range names {
+		if d := editDistance(name, n); d < best {
+			near, best, ok = n, d, true
+		}
+	}
+	return
+}
+
+// editDistance returns the Levenshtein distance between a and b: the fewest characters inserted, deleted, or substituted to turn one into the other.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	row := make([]int, len(rb)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := range ra {
+		diag := row[0]
+		row[0] = i + 1
+		for j := range rb {
+			sub := diag
+			if ra[i] != rb[j] {
+				sub++
+			}
+			diag = row[j+1]
+			row[j+1] = min(row[j+1]+1, row[j]+1, sub)
+		}
+	}
+	return row[len(rb)]
+}
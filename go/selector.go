@@ -0,0 +1,194 @@
+package confetti
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Selector selects directives of a document by their names, arguments, and nesting, as CSS selectors do elements of HTML. See CompileSelector.
+type Selector struct {
+	steps []selectorStep
+}
+
+// selectorStep is a compound selector with the combinator before it.
+type selectorStep struct {
+	child bool   // whether the directive must be a child of the one before, rather than any descendant
+	name  string // or "*" for any
+	attrs []selectorAttr
+}
+
+// selectorAttr requires an argument to be present, or to equal value if hasValue.
+type selectorAttr struct {
+	index    int
+	value    string
+	hasValue bool
+}
+
+func (s selectorStep) matches(d *Directive) bool {
+	if s.name != "*" && d.Name() != s.name {
+		return false
+	}
+	for _, a := range s.attrs {
+		if a.index >= len(d.Arguments) || a.hasValue && d.Arguments[a.index] != a.value {
+			return false
+		}
+	}
+	return true
+}
+
+// CompileSelector compiles a selector such as `server > location[arg1="/api"] listen`, which selects the listen directives anywhere in a location block with "/api" as its first argument after the name, directly in a server block.
+// A selector is a list of directive names, or "*" for any name, separated by white space, for a directive anywhere within the one before, or by ">", for a directive directly within it. A leading ">" selects only top-level directives. Each name may be followed by conditions on its arguments in brackets: [argN] requires an Nth argument after the name, and [argN=value] requires it to equal value. arg0 is the name itself. Names and values may be quoted with double quotes, with backslashes escaping quotes and backslashes.
+func CompileSelector(selector string) (*Selector, error) {
+	p := selectorParser{src: selector}
+	s, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("selector %q: offset %d: %w", selector, p.off, err)
+	}
+	return s, nil
+}
+
+// Select returns the directives of doc the selector selects, in depth-first order.
+func (s *Selector) Select(doc Document) []Match {
+	ms := []Match{{Directive: &Directive{Subdirectives: doc.Directives}}}
+	for _, step := range s.steps {
+		var next []Match
+		seen := map[string]bool{}
+		add := func(sub Match) bool {
+			if key := fmt.Sprint(sub.Path); step.matches(sub.Directive) && !seen[key] {
+				seen[key] = true
+				next = append(next, sub)
+			}
+			return true
+		}
+
+		for _, m := range ms {
+			if !step.child {
+				search(m.Directive.Subdirectives, m.Path, m.Names, add)
+				continue
+			}
+			for i := range m.Directive.Subdirectives {
+				d := &m.Directive.Subdirectives[i]
+				add(Match{d, append(m.Path[:len(m.Path):len(m.Path)], i), append(m.Names[:len(m.Names):len(m.Names)], d.Name())})
+			}
+		}
+		slices.SortFunc(next, func(a, b Match) int {
+			return slices.Compare(a.Path, b.Path)
+		})
+		ms = next
+	}
+	return ms
+}
+
+type selectorParser struct {
+	src string
+	off int
+}
+
+func (p *selectorParser) skipSpace() bool {
+	start := p.off
+	for p.off < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.off])) {
+		p.off++
+	}
+	return p.off > start
+}
+
+func (p *selectorParser) parse() (*Selector, error) {
+	s := &Selector{}
+	p.skipSpace()
+	for p.off < len(p.src) {
+		var step selectorStep
+		if p.src[p.off] == '>' {
+			step.child = true
+			p.off++
+			p.skipSpace()
+		}
+
+		name, err := p.word()
+		if err != nil {
+			return nil, err
+		}
+		step.name = name
+		for p.off < len(p.src) && p.src[p.off] == '[' {
+			a, err := p.attr()
+			if err != nil {
+				return nil, err
+			}
+			step.attrs = append(step.attrs, a)
+		}
+		s.steps = append(s.steps, step)
+
+		if !p.skipSpace() && p.off < len(p.src) && p.src[p.off] != '>' {
+			return nil, fmt.Errorf("unexpected %q", p.src[p.off])
+		}
+	}
+
+	if len(s.steps) == 0 {
+		return nil, errors.New("empty selector")
+	}
+	return s, nil
+}
+
+// word reads a name or value, quoted or not.
+func (p *selectorParser) word() (string, error) {
+	if p.off < len(p.src) && p.src[p.off] == '"' {
+		var b strings.Builder
+		for p.off++; p.off < len(p.src); p.off++ {
+			switch c := p.src[p.off]; c {
+			case '"':
+				p.off++
+				return b.String(), nil
+			case '\\':
+				if p.off++; p.off == len(p.src) {
+					return "", errors.New("unterminated string")
+				}
+				b.WriteByte(p.src[p.off])
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", errors.New("unterminated string")
+	}
+
+	start := p.off
+	for p.off < len(p.src) && !strings.ContainsRune(" \t\r\n>[]=\"", rune(p.src[p.off])) {
+		p.off++
+	}
+	if p.off == start {
+		if p.off == len(p.src) {
+			return "", errors.New("expected a name")
+		}
+		return "", fmt.Errorf("unexpected %q", p.src[p.off])
+	}
+	return p.src[start:p.off], nil
+}
+
+// attr reads a condition in brackets.
+func (p *selectorParser) attr() (a selectorAttr, err error) {
+	p.off++ // [
+	start := p.off
+	key, err := p.word()
+	if err != nil {
+		return a, err
+	}
+	num, ok := strings.CutPrefix(key, "arg")
+	if a.index, err = strconv.Atoi(num); !ok || err != nil || a.index < 0 {
+		p.off = start
+		return a, fmt.Errorf("expected argN, got %q", key)
+	}
+
+	if p.off < len(p.src) && p.src[p.off] == '=' {
+		p.off++
+		if a.value, err = p.word(); err != nil {
+			return a, err
+		}
+		a.hasValue = true
+	}
+	if p.off == len(p.src) || p.src[p.off] != ']' {
+		return a, errors.New("expected ]")
+	}
+	p.off++
+	return a, nil
+}
@@ -0,0 +1,77 @@
+package confetti
+
+import "fmt"
+
+// SemanticKind is the category of a SemanticToken, for highlighting source in an editor.
+type SemanticKind uint8
+
+const (
+	SemanticDirectiveName SemanticKind = iota // the first argument of a directive
+	SemanticArgument                          // an unquoted or punctuator argument
+	SemanticString                            // a quoted or triple quoted argument
+	SemanticComment
+	SemanticPunctuation // a semicolon, brace, or line continuation
+)
+
+var semanticKinds = [...]string{
+	SemanticDirectiveName: "directive-name",
+	SemanticArgument:      "argument",
+	SemanticString:        "string",
+	SemanticComment:       "comment",
+	SemanticPunctuation:   "punctuation",
+}
+
+func (k SemanticKind) String() string {
+	if int(k) < len(semanticKinds) {
+		return semanticKinds[k]
+	}
+	return fmt.Sprintf("SemanticKind(%d)", k)
+}
+
+// SemanticToken is a range of source to highlight as its Kind. Triple quoted arguments and multi-line comments may span more than one line.
+type SemanticToken struct {
+	Kind     SemanticKind
+	Pos, End Position
+}
+
+// SemanticTokens classifies the tokens of src for highlighting, in source order. White space, line terminators, and the byte order mark and ^Z are left out.
+func SemanticTokens(src string, opts ...Option) ([]SemanticToken, error) {
+	c := newConfig(opts)
+	if c.err != nil {
+		return nil, c.err
+	}
+	ts, p, err := c.read(src)
+	if err != nil {
+		return nil, withSource(err, src, c.name)
+	}
+
+	names := map[int]bool{}
+	Walk(p, func(d *Directive, _ int) bool {
+		names[d.Pos.Offset] = true
+		return true
+	})
+
+	var sts []SemanticToken
+	for _, t := range ts {
+		var k SemanticKind
+		switch t.Kind {
+		case TokenArgument, TokenQuotedArgument, TokenTripleQuotedArgument, TokenPunctuator:
+			switch {
+			case names[t.Pos.Offset]:
+				k = SemanticDirectiveName
+			case t.Kind == TokenQuotedArgument, t.Kind == TokenTripleQuotedArgument:
+				k = SemanticString
+			default:
+				k = SemanticArgument
+			}
+		case TokenComment:
+			k = SemanticComment
+		case TokenSemicolon, TokenOpenBrace, TokenCloseBrace, TokenContinuation:
+			k = SemanticPunctuation
+		default:
+			continue
+		}
+		sts = append(sts, SemanticToken{k, t.Pos, t.End})
+	}
+	return sts, nil
+}
@@ -0,0 +1,141 @@
+package confetti
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Snapshot is an immutable tree of directives. Edits return a new Snapshot sharing every unchanged subtree with the original, so many readers can cheaply hold consistent snapshots while a writer applies updates.
+// The zero Snapshot is empty and ready to use.
+type Snapshot struct {
+	nodes []*node
+}
+
+type node struct {
+	args []string
+	subs []*node
+}
+
+func toNodes(p []Directive) []*node {
+	if p == nil {
+		return nil
+	}
+
+	ns := make([]*node, len(p))
+	for i, d := range p {
+		ns[i] = &node{slices.Clone(d.Arguments), toNodes(d.Subdirectives)}
+	}
+	return ns
+}
+
+func fromNodes(ns []*node) []Directive {
+	if ns == nil {
+		return nil
+	}
+
+	p := make([]Directive, len(ns))
+	for i, n := range ns {
+		p[i] = Directive{Arguments: slices.Clone(n.args), Subdirectives: fromNodes(n.subs)}
+	}
+	return p
+}
+
+// NewSnapshot returns a snapshot holding a copy of p.
+func NewSnapshot(p []Directive) Snapshot {
+	return Snapshot{toNodes(p)}
+}
+
+// Len returns the number of top-level directives in the snapshot.
+func (s Snapshot) Len() int {
+	return len(s.nodes)
+}
+
+// Directives returns a copy of the snapshot's directives, which the caller may modify.
+func (s Snapshot) Directives() []Directive {
+	return fromNodes(s.nodes)
+}
+
+func find(ns []*node, path []int) *node {
+	for i, idx := range path {
+		if idx < 0 || idx >= len(ns) {
+			return nil
+		} else if i == len(path)-1 {
+			return ns[idx]
+		}
+		ns = ns[idx].subs
+	}
+	return nil
+}
+
+// At returns a copy of the directive at the index path.
+func (s Snapshot) At(path ...int) (Directive, bool) {
+	n := find(s.nodes, path)
+	if n == nil {
+		return Directive{}, false
+	}
+	return Directive{Arguments: slices.Clone(n.args), Subdirectives: fromNodes(n.subs)}, true
+}
+
+var errPath = errors.New("no directive at path")
+
+// update copies the nodes along path, replacing the list containing the last element with the result of f.
+func update(ns []*node, path []int, f func(ns []*node, i int) ([]*node, error)) ([]*node, error) {
+	if len(path) == 0 {
+		return nil, errPath
+	} else if len(path) == 1 {
+		return f(ns, path[0])
+	} else if path[0] < 0 || path[0] >= len(ns) {
+		return nil, errPath
+	}
+
+	old := ns[path[0]]
+	subs, err := update(old.subs, path[1:], f)
+	if err != nil {
+		return nil, err
+	}
+
+	ns = slices.Clone(ns)
+	ns[path[0]] = &node{old.args, subs}
+	return ns, nil
+}
+
+func (s Snapshot) edit(path []int, f func(ns []*node, i int) ([]*node, error)) (Snapshot, error) {
+	ns, err := update(s.nodes, path, f)
+	if err != nil {
+		return s, fmt.Errorf("%w %v", err, path)
+	}
+	return Snapshot{ns}, nil
+}
+
+// Set returns a snapshot with the directive at path replaced by d.
+func (s Snapshot) Set(path []int, d Directive) (Snapshot, error) {
+	return s.edit(path, func(ns []*node, i int) ([]*node, error) {
+		if i < 0 || i >= len(ns) {
+			return nil, errPath
+		}
+		ns = slices.Clone(ns)
+		ns[i] = toNodes([]Directive{d})[0]
+		return ns, nil
+	})
+}
+
+// Insert returns a snapshot with d inserted at path, before the directive currently there. The last index of path may equal the number of siblings to append d.
+func (s Snapshot) Insert(path []int, d Directive) (Snapshot, error) {
+	return s.edit(path, func(ns []*node, i int) ([]*node, error) {
+		if i < 0 || i > len(ns) {
+			return nil, errPath
+		}
+		return slices.Insert(slices.Clip(ns), i, toNodes([]Directive{d})[0]), nil
+	})
+}
+
+// Delete returns a snapshot with the directive at path removed.
+func (s Snapshot) Delete(path []int) (Snapshot, error) {
+	return s.edit(path, func(ns []*node, i int) ([]*node, error) {
+		if i < 0 || i >= len(ns) {
+			return nil, errPath
+		}
+		return slices.Delete(slices.Clone(ns), i, i+1), nil
+	})
+}
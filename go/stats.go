@@ -0,0 +1,38 @@
+package confetti
+
+import "strings"
+
+// Stats summarises the size and shape of a document.
+type Stats struct {
+	// Directives is the number of directives, including subdirectives.
+	Directives int
+	// Depth is the number of levels of directives, 1 for a document without blocks and 0 for an empty one.
+	Depth int
+	// Arguments is the number of arguments of all directives, including their names.
+	Arguments int
+	// ArgumentCounts maps each number of arguments to the number of directives with that many.
+	ArgumentCounts map[int]int
+	// Bytes is the size of the source the document was parsed from, not counting included files, or of its encoding if it was not parsed.
+	Bytes int
+}
+
+// Stats returns statistics about the document, such as for enforcing limits on the size of configurations.
+func (doc Document) Stats() Stats {
+	s := Stats{ArgumentCounts: map[int]int{}}
+	Walk(doc.Directives, func(d *Directive, depth int) bool {
+		s.Directives++
+		s.Depth = max(s.Depth, depth+1)
+		s.Arguments += len(d.Arguments)
+		s.ArgumentCounts[len(d.Arguments)]++
+		return true
+	})
+
+	if doc.conf != nil {
+		s.Bytes = len(doc.src)
+	} else {
+		var b strings.Builder
+		writeDirectives(&b, doc.Directives, FormatOptions{}.withExtensions(doc.Extensions), "")
+		s.Bytes = b.Len()
+	}
+	return s
+}
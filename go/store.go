@@ -0,0 +1,66 @@
+package confetti
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Store holds the active document of a program whose configuration changes while it runs, such as one reloaded by a Watcher. Its methods are safe for concurrent use, and the zero Store holds an empty document.
+// Documents in a store are shared by every goroutine loading them, so they must not be modified.
+type Store struct {
+	doc atomic.Pointer[Document]
+
+	mu   sync.Mutex
+	subs map[chan Document]struct{}
+}
+
+// Load returns the active document.
+func (s *Store) Load() Document {
+	if doc := s.doc.Load(); doc != nil {
+		return *doc
+	}
+	return Document{}
+}
+
+// Swap makes doc the active document, returning the one it replaces, and sends it to each subscriber.
+func (s *Store) Swap(doc Document) Document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.doc.Swap(&doc)
+	for c := range s.subs {
+		// a subscriber that has not received the last document gets this one instead
+		select {
+		case <-c:
+		default:
+		}
+		c <- doc
+	}
+
+	if old == nil {
+		return Document{}
+	}
+	return *old
+}
+
+// Subscribe returns a channel receiving each document Swap makes active from now on, and a function ending the subscription, which closes the channel. A subscriber slower than the changes only receives the latest document; Swap never waits for it.
+func (s *Store) Subscribe() (<-chan Document, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := make(chan Document, 1)
+	if s.subs == nil {
+		s.subs = map[chan Document]struct{}{}
+	}
+	s.subs[c] = struct{}{}
+
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.subs, c)
+			close(c)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package confetti
+
+import "iter"
+
+// Tokens returns an iterator over the tokens of src, read as they are needed. If the source cannot be lexed, the iterator yields the tokens before the problem and then a single *ParseError.
+func Tokens(src string, opts ...Option) iter.Seq2[Token, error] {
+	return func(yield func(Token, error) bool) {
+		c := newConfig(opts)
+		if c.err != nil {
+			yield(Token{}, c.err)
+			return
+		}
+
+		err := lexEach(src, c.exts, func(t Token) bool {
+			if c.name != "" {
+				t.Pos.Filename = c.name
+				t.End.Filename = c.name
+			}
+			return yield(t, nil)
+		})
+		if err != nil {
+			err = withSource(err, src, c.name)
+			if c.translate != nil {
+				setTranslator(err, c.translate)
+			}
+			yield(Token{}, err)
+		}
+	}
+}
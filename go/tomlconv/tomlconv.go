@@ -0,0 +1,202 @@
+// Package tomlconv converts between Confetti documents and TOML, following the same rules as confetti.ToValue and confetti.FromValue.
+package tomlconv
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	confetti "github.com/Heliodex/confetti"
+)
+
+// ToTOML converts a document into TOML as described by confetti.ToValue, except that a directive with no further arguments becomes an empty table, as TOML has no null. Directives with subdirectives become tables, and repeated ones arrays of tables, so TOML's ordering rules may move them after other keys.
+func ToTOML(doc confetti.Document) ([]byte, error) {
+	var b bytes.Buffer
+	if err := toml.NewEncoder(&b).Encode(noNulls(confetti.ToValue(doc.Directives))); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// noNulls replaces the nils in a value from confetti.ToValue with empty tables.
+func noNulls(v any) any {
+	switch v := v.(type) {
+	case nil:
+		return map[string]any{}
+	case map[string]any:
+		for k, e := range v {
+			v[k] = noNulls(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = noNulls(e)
+		}
+	}
+	return v
+}
+
+// FromTOML converts TOML into a document, reversing ToTOML. Keys keep their order in the source. Values of any type become arguments as written, except that dates and times are formatted as in RFC 3339.
+// An empty table becomes a directive with no further arguments, an array of values, optionally ending in a table, becomes a single directive, and any other array becomes one directive per element.
+func FromTOML(data []byte) (confetti.Document, error) {
+	var m map[string]any
+	md, err := toml.Decode(string(data), &m)
+	if err != nil {
+		return confetti.Document{}, err
+	}
+
+	order := map[string]int{}
+	for i, k := range md.Keys() {
+		if _, ok := order[k.String()]; !ok {
+			order[k.String()] = i
+		}
+	}
+
+	c := converter{order}
+	p, err := c.fromTable(m, "")
+	if err != nil {
+		return confetti.Document{}, err
+	}
+	return confetti.Document{Directives: p}, nil
+}
+
+type converter struct {
+	order map[string]int // position of each key path in the source
+}
+
+func (c converter) fromTable(m map[string]any, path string) (p []confetti.Directive, err error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Compare(c.order[join(path, a)], c.order[join(path, b)])
+	})
+
+	for _, k := range keys {
+		ds, err := c.fromEntry(k, m[k], join(path, k))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		p = append(p, ds...)
+	}
+	return
+}
+
+// join appends a key to a key path in the form toml.Key.String uses.
+func join(path, k string) string {
+	if !isBare(k) {
+		k = strconv.Quote(k)
+	}
+	if path == "" {
+		return k
+	}
+	return path + "." + k
+}
+
+func isBare(k string) bool {
+	return k != "" && !strings.ContainsFunc(k, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-')
+	})
+}
+
+func scalar(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case time.Time:
+		return v.Format(time.RFC3339Nano), true
+	case fmt.Stringer:
+		// local dates and times
+		return v.String(), true
+	}
+	return "", false
+}
+
+func elems(v any) ([]any, bool) {
+	switch v := v.(type) {
+	case []any:
+		return v, true
+	case []map[string]any:
+		es := make([]any, len(v))
+		for i, e := range v {
+			es[i] = e
+		}
+		return es, true
+	}
+	return nil, false
+}
+
+// single reports whether an array converts to a single directive.
+func single(es []any) bool {
+	for i, e := range es {
+		if _, ok := scalar(e); ok {
+			continue
+		} else if _, ok := e.(map[string]any); ok && i == len(es)-1 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func (c converter) fromEntry(name string, v any, path string) ([]confetti.Directive, error) {
+	es, ok := elems(v)
+	if !ok || single(es) {
+		d, err := c.fromSingle(name, v, path)
+		if err != nil {
+			return nil, err
+		}
+		return []confetti.Directive{d}, nil
+	}
+
+	p := make([]confetti.Directive, len(es))
+	for i, e := range es {
+		d, err := c.fromSingle(name, e, path)
+		if err != nil {
+			return nil, err
+		}
+		p[i] = d
+	}
+	return p, nil
+}
+
+func (c converter) fromSingle(name string, v any, path string) (confetti.Directive, error) {
+	d := confetti.Directive{Arguments: []string{name}}
+	if s, ok := scalar(v); ok {
+		d.Arguments = append(d.Arguments, s)
+		return d, nil
+	} else if m, ok := v.(map[string]any); ok {
+		subs, err := c.fromTable(m, path)
+		d.Subdirectives = subs
+		return d, err
+	}
+
+	es, ok := elems(v)
+	if !ok || !single(es) {
+		return d, fmt.Errorf("unsupported value of type %T", v)
+	}
+	for _, e := range es {
+		if m, ok := e.(map[string]any); ok {
+			subs, err := c.fromTable(m, path)
+			if err != nil {
+				return d, err
+			}
+			d.Subdirectives = subs
+			continue
+		}
+		s, _ := scalar(e)
+		d.Arguments = append(d.Arguments, s)
+	}
+	return d, nil
+}
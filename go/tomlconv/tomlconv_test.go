@@ -0,0 +1,80 @@
+package tomlconv_test
+
+import (
+	"testing"
+
+	confetti "github.com/Heliodex/confetti"
+	"github.com/Heliodex/confetti/tomlconv"
+)
+
+func TestTOML(t *testing.T) {
+	doc, err := confetti.Parse(`name app
+debug
+allow 10.0.0.1 10.0.0.2
+server {
+    root /srv
+}
+upstream { weight 2 }
+upstream { weight 1 }
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	data, err := tomlconv.ToTOML(doc)
+	if err != nil {
+		t.Fatalf("Failed to convert to TOML: %v", err)
+	}
+
+	const expected = `allow = ["10.0.0.1", "10.0.0.2"]
+name = "app"
+
+[debug]
+
+[server]
+  root = "/srv"
+
+[[upstream]]
+  weight = "2"
+
+[[upstream]]
+  weight = "1"
+`
+	if string(data) != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, data)
+	}
+
+	back, err := tomlconv.FromTOML([]byte(`name = "app"
+port = 8080
+ratio = 0.5
+started = 2024-01-02T03:04:05Z
+
+[server]
+root = "/srv"
+tls = { enabled = true }
+
+[[upstream]]
+weight = 2
+`))
+	if err != nil {
+		t.Fatalf("Failed to convert from TOML: %v", err)
+	}
+
+	const expectedConf = `name app
+port 8080
+ratio 0.5
+started 2024-01-02T03:04:05Z
+server {
+    root /srv
+    tls {
+        enabled true
+    }
+}
+upstream {
+    weight 2
+}
+`
+	if back.String() != expectedConf {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expectedConf, back.String())
+	}
+}
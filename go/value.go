@@ -0,0 +1,463 @@
+package confetti
+
+import (
+	"cmp"
+	"encoding"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FromValue converts a generic Go value into directives. v must be a map with string keys or a struct, each entry or field of which becomes one or more directives named by its key:
+//
+//   - nil becomes a directive with no further arguments
+//   - strings, booleans, numbers, time.Duration, url.URL, and types implementing encoding.TextMarshaler become a single argument
+//   - maps and structs become subdirectives
+//   - slices of scalars become multiple arguments
+//   - slices of scalars followed by one map become arguments and subdirectives
+//   - any other slice becomes one directive per element, converted by the rules above
+//
+// Map entries are ordered by key, and struct fields are named as described by Marshal.
+func FromValue(v any) ([]Directive, error) {
+	rv := indirect(reflect.ValueOf(v))
+	if !rv.IsValid() {
+		return nil, nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Map:
+		return fromMap(rv)
+	case reflect.Struct:
+		return fromStruct(rv)
+	}
+	return nil, fmt.Errorf("cannot convert %s to directives", rv.Type())
+}
+
+func indirect(rv reflect.Value) reflect.Value {
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return reflect.Value{}
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+func fromMap(rv reflect.Value) (p []Directive, err error) {
+	if rv.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("cannot convert map with %s keys to directives", rv.Type().Key())
+	}
+
+	keys := rv.MapKeys()
+	slices.SortFunc(keys, func(a, b reflect.Value) int {
+		return cmp.Compare(a.String(), b.String())
+	})
+
+	for _, k := range keys {
+		ds, err := fromEntry(k.String(), rv.MapIndex(k))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k.String(), err)
+		}
+		p = append(p, ds...)
+	}
+	return
+}
+
+// fieldName returns the directive name of a struct field, or false if it is skipped.
+func fieldName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+
+	tag, _, _ := strings.Cut(f.Tag.Get("confetti"), ",")
+	switch tag {
+	case "-":
+		return "", false
+	case "":
+		return strings.ToLower(f.Name), true
+	}
+	return tag, true
+}
+
+// structField is a field directives map to, in a struct or in a struct embedded in it.
+type structField struct {
+	reflect.StructField // whose Index leads from the outer struct
+	name                string
+	tagged              bool
+}
+
+// structFields returns the fields of the struct type t that directives map to, promoting the fields of embedded structs without a tag as encoding/json does: a field hides fields of the same name embedded more deeply, and fields of the same name at the same depth hide each other unless exactly one is tagged.
+func structFields(t reflect.Type) []structField {
+	var all []structField
+	collectFields(t, nil, map[reflect.Type]bool{}, &all)
+
+	depth := map[string]int{}
+	count := map[string]int{}
+	tagged := map[string]int{}
+	for _, f := range all {
+		if d, ok := depth[f.name]; ok && d < len(f.Index) {
+			continue
+		} else if !ok || d > len(f.Index) {
+			depth[f.name], count[f.name], tagged[f.name] = len(f.Index), 0, 0
+		}
+		count[f.name]++
+		if f.tagged {
+			tagged[f.name]++
+		}
+	}
+
+	fields := all[:0]
+	for _, f := range all {
+		if len(f.Index) == depth[f.name] && (count[f.name] == 1 || f.tagged && tagged[f.name] == 1) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func collectFields(t reflect.Type, index []int, seen map[reflect.Type]bool, fields *[]structField) {
+	if seen[t] {
+		return
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		f.Index = append(index[:len(index):len(index)], i)
+		tag, _, _ := strings.Cut(f.Tag.Get("confetti"), ",")
+
+		if et := f.Type; f.Anonymous && tag == "" {
+			if et.Kind() == reflect.Pointer {
+				et = et.Elem()
+			}
+			// fields of unexported embedded structs are promoted, unless they would need allocating
+			if et.Kind() == reflect.Struct && !isScalar(et) && (f.IsExported() || f.Type.Kind() == reflect.Struct) {
+				collectFields(et, f.Index, seen, fields)
+				continue
+			}
+		}
+
+		if name, ok := fieldName(f); ok {
+			*fields = append(*fields, structField{f, name, tag != ""})
+		}
+	}
+}
+
+// fieldValue returns the field of the struct rv at index, allocating the embedded structs pointers lead to if alloc is true, or false if one is nil.
+func fieldValue(rv reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() && !alloc {
+				return reflect.Value{}, false
+			} else if rv.IsNil() {
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, true
+}
+
+func fromStruct(rv reflect.Value) (p []Directive, err error) {
+	for _, f := range structFields(rv.Type()) {
+		fv, ok := fieldValue(rv, f.Index, false)
+		opts := tagOptions(f.StructField)
+		if !ok || fv.Kind() == reflect.Pointer && fv.IsNil() || opts.omitEmpty && isEmpty(fv) {
+			continue
+		}
+
+		var ds []Directive
+		if m := indirect(fv); opts.key && m.Kind() == reflect.Map {
+			ds, err = fromKeyed(f.name, m)
+		} else if opts.inline && m.Kind() == reflect.Struct {
+			var d Directive
+			d, err = fromInline(f.name, m)
+			ds = []Directive{d}
+		} else {
+			ds, err = fromEntry(f.name, fv)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		if opts.comment != "" && len(ds) > 0 {
+			ds[0].LeadingComments = append(ds[0].LeadingComments, "# "+opts.comment)
+		}
+		p = append(p, ds...)
+	}
+	return
+}
+
+// isEmpty reports whether rv is left out by the "omitempty" option: the zero value, or an empty slice or map.
+func isEmpty(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	}
+	return rv.IsZero()
+}
+
+// fromInline converts a struct into a single directive with one argument per field, in order.
+func fromInline(name string, rv reflect.Value) (Directive, error) {
+	d := Directive{Arguments: []string{name}}
+	for _, f := range structFields(rv.Type()) {
+		fv, _ := fieldValue(rv, f.Index, false)
+		s, ok, err := scalarString(indirect(fv))
+		if err != nil {
+			return d, err
+		} else if !ok {
+			return d, fmt.Errorf("%s: %w of type %s in inline struct", f.Name, errUnsupported, f.Type)
+		}
+		d.Arguments = append(d.Arguments, s)
+	}
+	return d, nil
+}
+
+// fromKeyed converts a map into one directive per entry, with the key as its second argument, ordered by key.
+func fromKeyed(name string, rv reflect.Value) ([]Directive, error) {
+	keys := make([]string, 0, rv.Len())
+	entries := map[string]reflect.Value{}
+	for it := rv.MapRange(); it.Next(); {
+		k, ok, err := scalarString(it.Key())
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, fmt.Errorf("%w map key of type %s", errUnsupported, it.Key().Type())
+		}
+		keys = append(keys, k)
+		entries[k] = it.Value()
+	}
+	slices.Sort(keys)
+
+	p := make([]Directive, len(keys))
+	for i, k := range keys {
+		d, err := fromSingle(name, indirect(entries[k]))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		d.Arguments = slices.Insert(d.Arguments, 1, k)
+		p[i] = d
+	}
+	return p, nil
+}
+
+// scalarString returns the single argument rv becomes, or false if it is not a scalar.
+func scalarString(rv reflect.Value) (string, bool, error) {
+	if !rv.IsValid() {
+		return "", false, nil
+	} else if m, ok := textMarshaler(rv); ok {
+		text, err := m.MarshalText()
+		return string(text), true, err
+	} else if rv.Type() == urlType {
+		u := rv.Interface().(url.URL)
+		return u.String(), true, nil
+	} else if rv.Type() == durationType {
+		return time.Duration(rv.Int()).String(), true, nil
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), true, nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), true, nil
+	case reflect.Float32:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 32), true, nil
+	case reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 64), true, nil
+	}
+	return "", false, nil
+}
+
+// textMarshaler returns rv as an encoding.TextMarshaler, if it or a pointer to it is one.
+func textMarshaler(rv reflect.Value) (encoding.TextMarshaler, bool) {
+	if m, ok := rv.Interface().(encoding.TextMarshaler); ok {
+		return m, true
+	} else if rv.CanAddr() {
+		m, ok := rv.Addr().Interface().(encoding.TextMarshaler)
+		return m, ok
+	}
+	return nil, false
+}
+
+func isList(rv reflect.Value) bool {
+	return rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array
+}
+
+var errUnsupported = errors.New("unsupported value")
+
+// fromEntry converts one map entry into directives.
+func fromEntry(name string, rv reflect.Value) ([]Directive, error) {
+	rv = indirect(rv)
+	if !isList(rv) || rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+		d, err := fromSingle(name, rv)
+		if err != nil {
+			return nil, err
+		}
+		return []Directive{d}, nil
+	}
+
+	// a list of scalars, optionally ending in a map, is a single directive
+	single := true
+	for i := range rv.Len() {
+		e := indirect(rv.Index(i))
+		if _, ok, _ := scalarString(e); !ok && (i < rv.Len()-1 || e.Kind() != reflect.Map) {
+			single = false
+			break
+		}
+	}
+	if single {
+		d, err := fromSingle(name, rv)
+		if err != nil {
+			return nil, err
+		}
+		return []Directive{d}, nil
+	}
+
+	p := make([]Directive, rv.Len())
+	for i := range rv.Len() {
+		d, err := fromSingle(name, indirect(rv.Index(i)))
+		if err != nil {
+			return nil, err
+		}
+		p[i] = d
+	}
+	return p, nil
+}
+
+// fromSingle converts a value into exactly one directive.
+func fromSingle(name string, rv reflect.Value) (Directive, error) {
+	d := Directive{Arguments: []string{name}}
+	if !rv.IsValid() {
+		return d, nil
+	} else if s, ok, err := scalarString(rv); err != nil {
+		return d, err
+	} else if ok {
+		d.Arguments = append(d.Arguments, s)
+		return d, nil
+	}
+
+	switch {
+	case rv.Kind() == reflect.Map:
+		subs, err := fromMap(rv)
+		if err != nil {
+			return d, err
+		}
+		d.Subdirectives = subs
+		return d, nil
+
+	case rv.Kind() == reflect.Struct:
+		subs, err := fromStruct(rv)
+		if err != nil {
+			return d, err
+		}
+		d.Subdirectives = subs
+		return d, nil
+
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		d.Arguments = append(d.Arguments, string(rv.Bytes()))
+		return d, nil
+
+	case isList(rv):
+		for i := range rv.Len() {
+			e := indirect(rv.Index(i))
+			if !e.IsValid() {
+				return d, fmt.Errorf("%w nil in list", errUnsupported)
+			} else if s, ok, err := scalarString(e); err != nil {
+				return d, err
+			} else if ok {
+				d.Arguments = append(d.Arguments, s)
+				continue
+			} else if i == rv.Len()-1 && e.Kind() == reflect.Map {
+				subs, err := fromMap(e)
+				if err != nil {
+					return d, err
+				}
+				d.Subdirectives = subs
+				continue
+			}
+			return d, fmt.Errorf("%w of type %s in list", errUnsupported, e.Type())
+		}
+		return d, nil
+	}
+
+	return d, fmt.Errorf("%w of type %s", errUnsupported, rv.Type())
+}
+
+// ToValue converts directives into generic Go values, reversing FromValue. The result is a map[string]any keyed by directive name, whose values are:
+//
+//   - nil for a directive with no further arguments
+//   - a string for a directive with one further argument
+//   - a []any of strings for a directive with several further arguments
+//   - a map[string]any for a directive with subdirectives
+//   - a []any of strings ending in a map[string]any for a directive with both
+//   - a []any of the above for a repeated directive
+//
+// A repeated directive with one argument each converts back to a single directive with several arguments.
+func ToValue(p []Directive) any {
+	return toMap(p)
+}
+
+// ToMap converts the directives of doc into nested map[string]any and []any values by the rules of ToValue, for code that consumes generic values, such as templates or encoding/json.
+func (doc Document) ToMap() map[string]any {
+	return toMap(doc.Directives)
+}
+
+func toMap(p []Directive) map[string]any {
+	m := make(map[string]any, len(p))
+	counts := map[string]int{}
+	for _, d := range p {
+		if len(d.Arguments) > 0 {
+			counts[d.Arguments[0]]++
+		}
+	}
+
+	for _, d := range p {
+		if len(d.Arguments) == 0 {
+			continue
+		}
+
+		name, v := d.Arguments[0], toSingle(d)
+		if counts[name] == 1 {
+			m[name] = v
+			continue
+		}
+
+		vs, _ := m[name].([]any)
+		m[name] = append(vs, v)
+	}
+	return m
+}
+
+func toSingle(d Directive) any {
+	args := d.Arguments[1:]
+	switch {
+	case len(d.Subdirectives) > 0 && len(args) == 0:
+		return toMap(d.Subdirectives)
+	case len(d.Subdirectives) > 0:
+		vs := make([]any, 0, len(args)+1)
+		for _, a := range args {
+			vs = append(vs, a)
+		}
+		return append(vs, toMap(d.Subdirectives))
+	case len(args) == 0:
+		return nil
+	case len(args) == 1:
+		return args[0]
+	}
+
+	vs := make([]any, len(args))
+	for i, a := range args {
+		vs[i] = a
+	}
+	return vs
+}
@@ -0,0 +1,75 @@
+package confetti
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// substitute removes variable definitions from p and replaces references to variables in the remaining arguments. scope holds the variables of the enclosing blocks.
+func substitute(p []Directive, set string, scope map[string]string) ([]Directive, error) {
+	if set == "" {
+		set = "set"
+	}
+	scope = maps.Clone(scope)
+	if scope == nil {
+		scope = map[string]string{}
+	}
+
+	var out []Directive
+	for _, d := range p {
+		args := make([]string, len(d.Arguments))
+		for i, a := range d.Arguments {
+			s, err := expand(a, scope)
+			if err != nil {
+				return nil, &ParseError{Pos: d.Pos, Token: a, Err: err}
+			}
+			args[i] = s
+		}
+
+		if d.Name() == set {
+			if len(args) != 3 || len(d.Subdirectives) > 0 {
+				return nil, &ParseError{Pos: d.Pos, Token: d.Name(), Err: ErrMalformedDefinition}
+			}
+			scope[d.Arguments[1]] = args[2]
+			continue
+		}
+
+		subs, err := substitute(d.Subdirectives, set, scope)
+		if err != nil {
+			return nil, err
+		}
+		d.Arguments, d.Subdirectives = args, subs
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// expand replaces the variable references in s.
+func expand(s string, scope map[string]string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		} else if i > 0 && s[i-1] == '$' {
+			// escaped
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+
+		j := strings.IndexByte(s[i+2:], '}')
+		if j < 0 {
+			return "", ErrUnclosedReference
+		}
+		name := s[i+2 : i+2+j]
+		v, ok := scope[name]
+		if !ok {
+			return "", fmt.Errorf("%w %q", ErrUndefinedVariable, name)
+		}
+		b.WriteString(s[:i] + v)
+		s = s[i+3+j:]
+	}
+}
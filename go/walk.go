@@ -0,0 +1,25 @@
+package confetti
+
+// Walk calls f for each directive in p and their subdirectives, depth first, with the depth of the directive starting at 0. If f returns false, the subdirectives of that directive are skipped.
+// f may modify the directive it is given, including its subdirectives before they are visited.
+func Walk(p []Directive, f func(d *Directive, depth int) bool) {
+	walk(p, 0, f)
+}
+
+func walk(p []Directive, depth int, f func(d *Directive, depth int) bool) {
+	for i := range p {
+		if f(&p[i], depth) {
+			walk(p[i].Subdirectives, depth+1, f)
+		}
+	}
+}
+
+// Inspect traverses p in depth-first order like go/ast.Inspect: it calls f(d) for each directive, and if f returns true, inspects its subdirectives followed by a call of f(nil).
+func Inspect(p []Directive, f func(d *Directive) bool) {
+	for i := range p {
+		if f(&p[i]) {
+			Inspect(p[i].Subdirectives, f)
+			f(nil)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package confetti
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// WatcherOptions configures the watcher returned by NewWatcher.
+type WatcherOptions struct {
+	// Interval is how often the files are checked for changes, or every second if it is 0.
+	Interval time.Duration
+	// Options are used to parse each file, which is named by its path unless WithName is given.
+	Options []Option
+	// Schema, if not nil, must be matched by each document, as Document.Validate checks, before it is passed on.
+	Schema *Schema
+	// OnError, if not nil, is called with each error reading, parsing, or validating a changed file, after which the file is not read again until it next changes.
+	OnError func(path string, err error)
+}
+
+// fileStamp is what a file is checked against to tell whether it has changed.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// Watcher reloads configuration files when they change, the hot-reload pattern of long-running programs. Files are checked by polling, so it works on every file system.
+type Watcher struct {
+	paths  []string
+	reload func(Document)
+	opts   WatcherOptions
+
+	stamps []fileStamp
+	hashes [][32]byte // of the last document passed on from each file
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewWatcher loads the files at paths, calling reload with the document of each in turn, and then watches them until Close is called.
+// When a file's size or modification time changes, it is parsed again, and if it parses, matches the schema, and has changed other than in formatting or comments, as Document.Hash tells, reload is called with the new document from the watcher's goroutine. Files it includes are not watched.
+// NewWatcher fails without watching if any file cannot be loaded at first.
+func NewWatcher(paths []string, reload func(Document), opts WatcherOptions) (*Watcher, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	w := &Watcher{
+		paths:  paths,
+		reload: reload,
+		opts:   opts,
+		stamps: make([]fileStamp, len(paths)),
+		hashes: make([][32]byte, len(paths)),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	docs := make([]Document, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		w.stamps[i] = fileStamp{info.Size(), info.ModTime()}
+		if docs[i], err = loadValid(path, w.opts.Options, w.opts.Schema); err != nil {
+			return nil, err
+		}
+		w.hashes[i] = docs[i].Hash()
+	}
+	for _, doc := range docs {
+		reload(doc)
+	}
+
+	go w.watch()
+	return w, nil
+}
+
+// loadValid parses the file at path, failing if it does not match the schema, if there is one.
+func loadValid(path string, opts []Option, schema *Schema) (Document, error) {
+	doc, err := parseFile(path, opts)
+	if err != nil || schema == nil {
+		return doc, err
+	}
+
+	var errs []error
+	for _, ve := range doc.Validate(*schema) {
+		errs = append(errs, ve)
+	}
+	if err = errors.Join(errs...); err != nil {
+		return Document{}, err
+	}
+	return doc, nil
+}
+
+func (w *Watcher) watch() {
+	defer close(w.done)
+	t := time.NewTicker(w.opts.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+		}
+
+		for i, path := range w.paths {
+			if err := w.check(i, path); err != nil && w.opts.OnError != nil {
+				w.opts.OnError(path, err)
+			}
+		}
+	}
+}
+
+// check reloads the file at path, the ith watched, if it has changed.
+func (w *Watcher) check(i int, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if w.stamps[i].modTime.IsZero() {
+			return nil // already reported
+		}
+		w.stamps[i] = fileStamp{}
+		return err
+	}
+	if info.Size() == w.stamps[i].size && info.ModTime().Equal(w.stamps[i].modTime) {
+		return nil
+	}
+	w.stamps[i] = fileStamp{info.Size(), info.ModTime()}
+
+	doc, err := loadValid(path, w.opts.Options, w.opts.Schema)
+	if err != nil {
+		return err
+	} else if h := doc.Hash(); h != w.hashes[i] {
+		w.hashes[i] = h
+		w.reload(doc)
+	}
+	return nil
+}
+
+// Close stops watching the files, waiting for a reload in progress to return. It must not be called from reload.
+func (w *Watcher) Close() error {
+	close(w.stop)
+	<-w.done
+	return nil
+}
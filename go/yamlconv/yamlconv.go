@@ -0,0 +1,196 @@
+// Package yamlconv converts between Confetti documents and YAML, following the same rules as confetti.ToValue and confetti.FromValue but keeping the order of directives and mapping keys.
+package yamlconv
+
+import (
+	"errors"
+	"fmt"
+
+	confetti "github.com/Heliodex/confetti"
+	"gopkg.in/yaml.v3"
+)
+
+// ToYAML converts a document into a YAML mapping keyed by directive name. A directive becomes:
+//
+//   - null if it has no further arguments
+//   - a string if it has one further argument
+//   - a sequence of strings if it has several further arguments
+//   - a mapping if it has subdirectives
+//   - a sequence of strings ending in a mapping if it has both
+//
+// A repeated directive becomes a sequence of the above, placed where its first occurrence was. Arguments are quoted where YAML would read them as another type, such as 80 or true, so that they stay strings.
+func ToYAML(doc confetti.Document) ([]byte, error) {
+	return yaml.Marshal(toMapping(doc.Directives))
+}
+
+func toMapping(p []confetti.Directive) *yaml.Node {
+	m := &yaml.Node{Kind: yaml.MappingNode}
+	values := map[string]*yaml.Node{}
+	counts := map[string]int{}
+	for _, d := range p {
+		if len(d.Arguments) > 0 {
+			counts[d.Name()]++
+		}
+	}
+
+	for _, d := range p {
+		if len(d.Arguments) == 0 {
+			continue
+		}
+
+		name, v := d.Name(), toNode(d)
+		if counts[name] == 1 {
+			m.Content = append(m.Content, scalar(name), v)
+			continue
+		}
+
+		seq, ok := values[name]
+		if !ok {
+			seq = &yaml.Node{Kind: yaml.SequenceNode}
+			values[name] = seq
+			m.Content = append(m.Content, scalar(name), seq)
+		}
+		seq.Content = append(seq.Content, v)
+	}
+	return m
+}
+
+func scalar(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
+
+func toNode(d confetti.Directive) *yaml.Node {
+	args := d.Arguments[1:]
+	if len(d.Subdirectives) == 0 && len(args) == 0 {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	} else if len(d.Subdirectives) == 0 && len(args) == 1 {
+		return scalar(args[0])
+	} else if len(args) == 0 {
+		return toMapping(d.Subdirectives)
+	}
+
+	seq := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, a := range args {
+		seq.Content = append(seq.Content, scalar(a))
+	}
+	if len(d.Subdirectives) > 0 {
+		seq.Content = append(seq.Content, toMapping(d.Subdirectives))
+	} else {
+		seq.Style = yaml.FlowStyle
+	}
+	return seq
+}
+
+// FromYAML converts a YAML mapping into a document, reversing ToYAML. Scalars of any type become arguments as written. A sequence of scalars, optionally ending in a mapping, becomes a single directive, and any other sequence becomes one directive per element.
+func FromYAML(data []byte) (confetti.Document, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return confetti.Document{}, err
+	} else if len(root.Content) == 0 {
+		return confetti.Document{}, nil
+	}
+
+	m := resolve(root.Content[0])
+	if m.Kind != yaml.MappingNode {
+		return confetti.Document{}, fmt.Errorf("line %d: expected a mapping", m.Line)
+	}
+	p, err := fromMapping(m)
+	if err != nil {
+		return confetti.Document{}, err
+	}
+	return confetti.Document{Directives: p}, nil
+}
+
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	return n
+}
+
+func isNull(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+func fromMapping(m *yaml.Node) (p []confetti.Directive, err error) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		k, v := resolve(m.Content[i]), resolve(m.Content[i+1])
+		if k.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("line %d: expected a scalar key", k.Line)
+		}
+
+		ds, err := fromEntry(k.Value, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k.Value, err)
+		}
+		p = append(p, ds...)
+	}
+	return
+}
+
+// single reports whether a sequence converts to a single directive.
+func single(seq *yaml.Node) bool {
+	for i, e := range seq.Content {
+		e = resolve(e)
+		if e.Kind == yaml.ScalarNode && !isNull(e) {
+			continue
+		} else if i == len(seq.Content)-1 && e.Kind == yaml.MappingNode {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func fromEntry(name string, v *yaml.Node) ([]confetti.Directive, error) {
+	if v.Kind != yaml.SequenceNode || single(v) {
+		d, err := fromSingle(name, v)
+		if err != nil {
+			return nil, err
+		}
+		return []confetti.Directive{d}, nil
+	}
+
+	p := make([]confetti.Directive, len(v.Content))
+	for i, e := range v.Content {
+		d, err := fromSingle(name, resolve(e))
+		if err != nil {
+			return nil, err
+		}
+		p[i] = d
+	}
+	return p, nil
+}
+
+var errNested = errors.New("nested sequences are not supported")
+
+func fromSingle(name string, v *yaml.Node) (confetti.Directive, error) {
+	d := confetti.Directive{Arguments: []string{name}}
+	switch {
+	case isNull(v):
+		return d, nil
+
+	case v.Kind == yaml.ScalarNode:
+		d.Arguments = append(d.Arguments, v.Value)
+		return d, nil
+
+	case v.Kind == yaml.MappingNode:
+		subs, err := fromMapping(v)
+		d.Subdirectives = subs
+		return d, err
+
+	case v.Kind == yaml.SequenceNode && single(v):
+		for _, e := range v.Content {
+			if e = resolve(e); e.Kind == yaml.MappingNode {
+				subs, err := fromMapping(e)
+				if err != nil {
+					return d, err
+				}
+				d.Subdirectives = subs
+				continue
+			}
+			d.Arguments = append(d.Arguments, e.Value)
+		}
+		return d, nil
+	}
+	return d, fmt.Errorf("line %d: %w", v.Line, errNested)
+}
@@ -0,0 +1,82 @@
+package yamlconv_test
+
+import (
+	"testing"
+
+	confetti "github.com/Heliodex/confetti"
+	"github.com/Heliodex/confetti/yamlconv"
+)
+
+func TestYAML(t *testing.T) {
+	doc, err := confetti.Parse(`name app
+port 80
+debug
+allow 10.0.0.1 10.0.0.2
+server {
+    root /srv
+}
+upstream a { weight 2 }
+upstream b { weight 1 }
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+
+	data, err := yamlconv.ToYAML(doc)
+	if err != nil {
+		t.Fatalf("Failed to convert to YAML: %v", err)
+	}
+
+	const expected = `name: app
+port: "80"
+debug: null
+allow: [10.0.0.1, 10.0.0.2]
+server:
+    root: /srv
+upstream:
+    - - a
+      - weight: "2"
+    - - b
+      - weight: "1"
+`
+	if string(data) != expected {
+		t.Fatalf("Output mismatch\n-- Expected:\n%s\n-- Got:\n%s", expected, data)
+	}
+
+	back, err := yamlconv.FromYAML(data)
+	if err != nil {
+		t.Fatalf("Failed to convert from YAML: %v", err)
+	} else if len(back.Directives) != len(doc.Directives) {
+		t.Fatalf("Expected %d directives, got %d", len(doc.Directives), len(back.Directives))
+	}
+	for i, d := range back.Directives {
+		if !d.Equals(doc.Directives[i]) {
+			t.Fatalf("Directive mismatch at index %d\nExpected:\n%v\nGot:\n%v", i, doc.Directives[i], d)
+		}
+	}
+
+	// arguments that YAML would read as another type stay strings
+	doc, err = confetti.Parse(`a null
+b ~
+c true
+d 1e3
+e ""
+f null ~ ""
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	} else if data, err = yamlconv.ToYAML(doc); err != nil {
+		t.Fatalf("Failed to convert to YAML: %v", err)
+	} else if back, err = yamlconv.FromYAML(data); err != nil {
+		t.Fatalf("Failed to convert from YAML: %v", err)
+	}
+	for i, d := range back.Directives {
+		if !d.Equals(doc.Directives[i]) {
+			t.Fatalf("Directive mismatch at index %d\nExpected:\n%v\nGot:\n%v", i, doc.Directives[i], d)
+		}
+	}
+
+	if _, err = yamlconv.FromYAML([]byte("- a\n- b\n")); err == nil {
+		t.Fatal("Expected an error converting a sequence")
+	}
+}